@@ -34,22 +34,30 @@ func main() {
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&ctl.PingCmd{}, "System")
+	subcommands.Register(&ctl.WatchCmd{}, "System")
+	subcommands.Register(&ctl.BackupCmd{}, "System")
+	subcommands.Register(&ctl.FsckCmd{}, "System")
+	subcommands.Register(&ctl.AuditCmd{}, "System")
 	subcommands.Register(&ctl.AuthCmd{}, "Authentication")
 	subcommands.Register(&ctl.GetTokenCmd{}, "Authentication")
 	subcommands.Register(&ctl.DestroyTokenCmd{}, "Authentication")
 	subcommands.Register(&ctl.ValidateTokenCmd{}, "Authentication")
 	subcommands.Register(&ctl.InspectTokenCmd{}, "Authentication")
 	subcommands.Register(&ctl.ChangeSecretCmd{}, "Authentication")
+	subcommands.Register(&ctl.ImportSecretCmd{}, "Authentication")
 
 	subcommands.Register(&ctl.CreateEntityCmd{}, "Entity Administration")
 	subcommands.Register(&ctl.DestroyEntityCmd{}, "Entity Administration")
+	subcommands.Register(&ctl.RenameEntityCmd{}, "Entity Administration")
 	subcommands.Register(&ctl.EntityInfoCmd{}, "Entity Administration")
 	subcommands.Register(&ctl.ModifyMetaCmd{}, "Entity Administration")
 	subcommands.Register(&ctl.ModifyKeysCmd{}, "Entity Administration")
 	subcommands.Register(&ctl.LockEntityCmd{}, "Entity Administration")
+	subcommands.Register(&ctl.AuthFailuresCmd{}, "Entity Administration")
 
 	subcommands.Register(&ctl.CreateGroupCmd{}, "Group Administration")
 	subcommands.Register(&ctl.DestroyGroupCmd{}, "Group Administration")
+	subcommands.Register(&ctl.RenameGroupCmd{}, "Group Administration")
 	subcommands.Register(&ctl.ListGroupsCmd{}, "Group Administration")
 	subcommands.Register(&ctl.ModifyGroupCmd{}, "Group Administration")
 	subcommands.Register(&ctl.GroupInfoCmd{}, "Group Administration")
@@ -3,16 +3,27 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
 	"strings"
 
+	"github.com/NetAuth/NetAuth/internal/audit"
+	_ "github.com/NetAuth/NetAuth/internal/audit/all"
 	"github.com/NetAuth/NetAuth/internal/crypto"
 	_ "github.com/NetAuth/NetAuth/internal/crypto/all"
 	"github.com/NetAuth/NetAuth/internal/db"
 	_ "github.com/NetAuth/NetAuth/internal/db/all"
+	"github.com/NetAuth/NetAuth/internal/db/backup"
+	"github.com/NetAuth/NetAuth/internal/db/cache"
+	"github.com/NetAuth/NetAuth/internal/db/changefeed"
+	"github.com/NetAuth/NetAuth/internal/db/migrate"
 	"github.com/NetAuth/NetAuth/internal/token"
 	_ "github.com/NetAuth/NetAuth/internal/token/all"
+	"github.com/NetAuth/NetAuth/internal/token/revocation"
+	_ "github.com/NetAuth/NetAuth/internal/tree/hooks/all"
 
 	"github.com/NetAuth/NetAuth/internal/rpc"
 	"github.com/NetAuth/NetAuth/internal/tree"
@@ -31,7 +42,17 @@ var (
 	keyFile    = flag.String("key_file", "netauth.certkey", "Path to key file")
 	bootstrap  = flag.String("make_bootstrap", "", "ID:secret to give GLOBAL_ROOT - for bootstrapping")
 	dbImpl     = flag.String("db", "ProtoDB", "Database implementation to use.")
+	dbCache    = flag.Bool("db_cache", false, "Cache entities and groups in memory in front of the database.")
 	cryptoImpl = flag.String("crypto", "bcrypt", "Crypto implementation to use.")
+	treeHooks  = flag.String("tree_hooks", "SetEntitySecretByID:secret-policy,ValidateSecret:auto-lock", "Comma separated list of Function:hook pairs to run, in order.  The secret policy and automatic lockout are on by default, set this to replace them.")
+	auditSinks = flag.String("audit", "", "Comma separated list of audit sinks to record changes to.")
+	rotateKey  = flag.Bool("rotate_token_key", false, "Generate and promote a new token signing key, then exit.")
+	restore    = flag.String("restore", "", "Restore the backup at this path into an empty database, then exit.")
+	backupPass = flag.String("restore_passphrase_file", "", "File containing the passphrase of an encrypted backup.")
+	migrateTo  = flag.String("migrate_to", "", "Copy everything from --db into this empty database, then exit.")
+	migrateDry = flag.Bool("migrate_dry_run", false, "Report what --migrate_to would copy without copying it.")
+	fsck       = flag.Bool("fsck", false, "Check the database for inconsistencies, then exit.")
+	fsckRepair = flag.Bool("fsck_repair", false, "Repair what --fsck finds where it is safe to do so.")
 )
 
 func newServer() *rpc.NetAuthServer {
@@ -40,15 +61,28 @@ func newServer() *rpc.NetAuthServer {
 	if err != nil {
 		log.Fatalf("Fatal database error! (%s)", err)
 	}
+	if *dbCache {
+		log.Println("Caching database in memory")
+		db = cache.New(db)
+	}
+
+	// Changes are recorded on the way into the database so that
+	// clients can watch for them.
+	changes := changefeed.New(db)
 
-	crypto, err := crypto.New(*cryptoImpl)
+	// Secrets are verified with whichever engine secured them,
+	// so the engine can be changed without locking anyone out.
+	crypto, err := crypto.NewMulti(*cryptoImpl)
 	if err != nil {
 		log.Fatalf("Fatal crypto error! (%s)", err)
 	}
 
 	// Initialize the entity tree
 	log.Printf("Initializing new Entity Tree with %s and %s", *dbImpl, *cryptoImpl)
-	tree := tree.New(db, crypto)
+	tree := tree.New(changes, crypto)
+	if err := tree.SetHooks(strings.Split(*treeHooks, ",")); err != nil {
+		log.Fatalf("Fatal error configuring tree hooks: %s", err)
+	}
 
 	// Initialize the token service
 	log.Println("Initializing token service")
@@ -57,15 +91,192 @@ func newServer() *rpc.NetAuthServer {
 		log.Fatalf("Fatal error initializing token service: %s", err)
 	}
 
-	return &rpc.NetAuthServer{
-		Tree:  tree,
-		Token: tokenService,
+	// Tokens that have been revoked are stored in the tree, so
+	// the token service needs to check there before accepting
+	// any token.
+	tokenService = revocation.New(tokenService, tree)
+
+	srv := &rpc.NetAuthServer{
+		Tree:    tree,
+		Token:   tokenService,
+		Changes: changes,
+		Store:   changes,
+	}
+
+	// Changes made through the server are recorded to every
+	// audit sink that was asked for.
+	if *auditSinks != "" {
+		auditLog, err := audit.New(strings.Split(*auditSinks, ","))
+		if err != nil {
+			log.Fatalf("Fatal error initializing audit log: %s", err)
+		}
+		srv.Audit = auditLog
+	}
+
+	return srv
+}
+
+// rotateTokenKey replaces the signing key of the token service.  The
+// server must be restarted to start signing with the new key.
+func rotateTokenKey() {
+	tokenService, err := token.New()
+	if err != nil {
+		log.Fatalf("Fatal error initializing token service: %s", err)
+	}
+
+	r, ok := tokenService.(token.Rotator)
+	if !ok {
+		log.Fatalf("Token service does not support key rotation")
+	}
+	if r.Generated() {
+		// Loading the service created the first key, so
+		// rotating now would just replace it with another.
+		log.Println("Token key generated, restart the server to begin using it")
+		return
+	}
+	if err := r.Rotate(); err != nil {
+		log.Fatalf("Key rotation failed: %s", err)
+	}
+	log.Println("Token key rotated, restart the server to begin using it")
+}
+
+// restoreBackup loads a backup into the database, which must be
+// empty.  Any database can be restored to, no matter which one the
+// backup was taken from.
+func restoreBackup() {
+	var passphrase string
+	if *backupPass != "" {
+		p, err := ioutil.ReadFile(*backupPass)
+		if err != nil {
+			log.Fatalf("Could not read passphrase: %s", err)
+		}
+		passphrase = strings.TrimRight(string(p), "\r\n")
+	}
+
+	f, err := os.Open(*restore)
+	if err != nil {
+		log.Fatalf("Could not open backup: %s", err)
+	}
+	defer f.Close()
+
+	a, err := backup.Read(f, passphrase)
+	if err != nil {
+		log.Fatalf("Could not read backup: %s", err)
+	}
+	log.Printf("Backup taken %s contains %d entities and %d groups", a.Created, len(a.Entities), len(a.Groups))
+
+	d, err := db.New(*dbImpl)
+	if err != nil {
+		log.Fatalf("Fatal database error! (%s)", err)
+	}
+	if c, ok := d.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if err := a.Restore(d); err != nil {
+		log.Fatalf("Restore failed: %s", err)
+	}
+	log.Printf("Backup restored to %s", *dbImpl)
+}
+
+// migrateDB copies everything from the database named by --db into
+// the one named by --migrate_to, and then checks that everything
+// arrived intact.
+func migrateDB() {
+	if *migrateTo == *dbImpl {
+		log.Fatalf("Cannot migrate %s to itself", *dbImpl)
+	}
+
+	src, err := db.New(*dbImpl)
+	if err != nil {
+		log.Fatalf("Could not open %s: %s", *dbImpl, err)
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	dst, err := db.New(*migrateTo)
+	if err != nil {
+		log.Fatalf("Could not open %s: %s", *migrateTo, err)
+	}
+	if c, ok := dst.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	report, err := migrate.Copy(src, dst, *migrateDry)
+	if err != nil {
+		log.Fatalf("Migration failed: %s", err)
+	}
+	if *migrateDry {
+		log.Printf("Dry run, would copy from %s to %s: %s", *dbImpl, *migrateTo, report)
+		return
+	}
+	log.Printf("Copied from %s to %s: %s", *dbImpl, *migrateTo, report)
+
+	report, err = migrate.Verify(src, dst)
+	if err != nil {
+		log.Fatalf("Verification failed: %s", err)
+	}
+	if len(report.Problems) != 0 {
+		log.Fatalf("Verification found problems: %s", report)
+	}
+	log.Printf("Verified %d entities and %d groups", report.Entities, report.Groups)
+}
+
+// fsckDB checks the database while the server is not running.  The
+// exit status is non-zero if any problems were left unrepaired.
+func fsckDB() {
+	d, err := db.New(*dbImpl)
+	if err != nil {
+		log.Fatalf("Fatal database error! (%s)", err)
+	}
+	crypto, err := crypto.NewMulti(*cryptoImpl)
+	if err != nil {
+		log.Fatalf("Fatal crypto error! (%s)", err)
+	}
+
+	problems, err := tree.New(d, crypto).Fsck(*fsckRepair)
+	if c, ok := d.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		log.Fatalf("Check failed: %s", err)
+	}
+
+	unrepaired := 0
+	for _, p := range problems {
+		if !p.GetRepaired() {
+			unrepaired++
+		}
+	}
+	log.Printf("Found %d problems, %d left unrepaired", len(problems), unrepaired)
+	if unrepaired != 0 {
+		os.Exit(1)
 	}
 }
 
 func main() {
 	flag.Parse()
 
+	if *rotateKey {
+		rotateTokenKey()
+		return
+	}
+
+	if *restore != "" {
+		restoreBackup()
+		return
+	}
+
+	if *migrateTo != "" {
+		migrateDB()
+		return
+	}
+
+	if *fsck {
+		fsckDB()
+		return
+	}
+
 	log.Println("NetAuth server is starting!")
 
 	// Bind early so that if this fails we can just bail out.
@@ -114,6 +325,22 @@ func main() {
 		log.Printf("  %s", b)
 	}
 
+	// Spit out what hooks we know about, and where they can go
+	log.Printf("The following tree hooks are registered:")
+	for _, h := range tree.GetHookList() {
+		log.Printf("  %s", h)
+	}
+	log.Printf("Tree hooks may be attached to the following functions:")
+	for _, p := range tree.GetHookPointList() {
+		log.Printf("  %s", p)
+	}
+
+	// Spit out the audit sinks we know about
+	log.Printf("The following audit sinks are registered:")
+	for _, s := range audit.GetSinkList() {
+		log.Printf("  %s", s)
+	}
+
 	// Spit out the token services we know about
 	log.Printf("The following token services are registered:")
 	for _, b := range token.GetBackendList() {
@@ -138,6 +365,10 @@ func main() {
 	// Instantiate and launch.  This will block and the server
 	// will server forever.
 	log.Println("Ready to Serve...")
+	opts = append(opts,
+		grpc.UnaryInterceptor(srv.UnaryInterceptor),
+		grpc.StreamInterceptor(srv.StreamInterceptor),
+	)
 	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterNetAuthServer(grpcServer, srv)
 
@@ -0,0 +1,6 @@
+package all
+
+import (
+	// Register the sink in init()
+	_ "github.com/NetAuth/NetAuth/internal/audit/syslog"
+)
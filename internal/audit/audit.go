@@ -0,0 +1,177 @@
+// Package audit keeps a record of the changes made through the
+// server: who made them, from where, what was changed, and how.
+// Records are written to one or more sinks, which only ever append to
+// what they already hold.
+package audit
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A Sink is somewhere that audit records are kept.  A Sink must never
+// change or remove a record once it has been appended.
+type Sink interface {
+	Append(*pb.AuditRecord) error
+}
+
+// A Querier is a Sink that can read back the records it holds.  This
+// is optional, callers must check for it with a type assertion.
+type Querier interface {
+	// Query returns the records that match the filter, oldest
+	// first.  A Querier should apply the Limit of the filter as it
+	// reads, the caller applies it again either way.
+	Query(Filter) ([]*pb.AuditRecord, error)
+}
+
+// Factory defines the function which can be used to register new
+// sinks.
+type Factory func() (Sink, error)
+
+var (
+	sinks map[string]Factory
+)
+
+func init() {
+	sinks = make(map[string]Factory)
+}
+
+// Register takes in a name of the sink to register and a function
+// signature to bind to that name.
+func Register(name string, newFunc Factory) {
+	if _, ok := sinks[name]; ok {
+		// Return if the sink is already registered.
+		return
+	}
+	sinks[name] = newFunc
+}
+
+// GetSinkList returns a string list of the sinks that are available.
+func GetSinkList() []string {
+	var l []string
+
+	for s := range sinks {
+		l = append(l, s)
+	}
+
+	return l
+}
+
+// A Filter selects audit records.  Fields left at their zero value
+// match every record.
+type Filter struct {
+	Actor  string
+	Target string
+	Action string
+	Since  time.Time
+	Until  time.Time
+
+	// Limit is the most records to return.  When more records
+	// match only the newest are returned.
+	Limit int
+}
+
+// Match checks if a record is selected by the filter.
+func (f Filter) Match(r *pb.AuditRecord) bool {
+	switch {
+	case f.Actor != "" && f.Actor != r.GetActor():
+		return false
+	case f.Target != "" && f.Target != r.GetTarget():
+		return false
+	case f.Action != "" && f.Action != r.GetAction():
+		return false
+	case !f.Since.IsZero() && r.GetTime() < f.Since.Unix():
+		return false
+	case !f.Until.IsZero() && r.GetTime() > f.Until.Unix():
+		return false
+	}
+	return true
+}
+
+// A Log records to every sink that it was created with.
+type Log struct {
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+// New returns a Log that records to the named sinks.  Empty names
+// are ignored, so a Log with no sinks at all records nothing.
+func New(names []string) (*Log, error) {
+	l := &Log{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f, ok := sinks[name]
+		if !ok {
+			l.Close()
+			return nil, ErrUnknownSink
+		}
+		s, err := f()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, s)
+		log.Printf("Recording audit records to %s", name)
+	}
+	return l, nil
+}
+
+// Record appends a record to every sink, stamping it with the current
+// time if it doesn't already have one.  By the time a change is
+// recorded it has already been made, so failures are logged rather
+// than returned.
+func (l *Log) Record(r *pb.AuditRecord) {
+	if r.Time == nil {
+		now := time.Now().Unix()
+		r.Time = &now
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.sinks {
+		if err := s.Append(r); err != nil {
+			log.Printf("Could not record audit record for %s on '%s': %s", r.GetAction(), r.GetTarget(), err)
+		}
+	}
+}
+
+// Query returns the records that match the filter, oldest first,
+// from the first sink that can be queried.
+func (l *Log) Query(f Filter) ([]*pb.AuditRecord, error) {
+	for _, s := range l.sinks {
+		q, ok := s.(Querier)
+		if !ok {
+			continue
+		}
+		records, err := q.Query(f)
+		if err != nil {
+			return nil, err
+		}
+		if f.Limit > 0 && len(records) > f.Limit {
+			records = records[len(records)-f.Limit:]
+		}
+		return records, nil
+	}
+	return nil, ErrNotQueryable
+}
+
+// Close closes every sink that needs it.
+func (l *Log) Close() error {
+	var err error
+	for _, s := range l.sinks {
+		if c, ok := s.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+type memSink struct {
+	records []*pb.AuditRecord
+	err     error
+}
+
+func (m *memSink) Append(r *pb.AuditRecord) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, r)
+	return nil
+}
+
+type queryableSink struct {
+	memSink
+}
+
+func (q *queryableSink) Query(f Filter) ([]*pb.AuditRecord, error) {
+	var out []*pb.AuditRecord
+	for _, r := range q.records {
+		if f.Match(r) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func TestNewUnknownSink(t *testing.T) {
+	if _, err := New([]string{"NoSuchSink"}); err != ErrUnknownSink {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	l, err := New([]string{"", " "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.sinks) != 0 {
+		t.Errorf("Sinks made from empty names: %v", l.sinks)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := &memSink{}
+	Register("mem", func() (Sink, error) { return s, nil })
+	Register("mem", func() (Sink, error) { return nil, errors.New("replaced") })
+
+	found := false
+	for _, name := range GetSinkList() {
+		if name == "mem" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Registered sink is not listed")
+	}
+
+	l, err := New([]string{"mem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(&pb.AuditRecord{Action: proto.String("NewEntity")})
+	if len(s.records) != 1 || s.records[0].GetTime() == 0 {
+		t.Errorf("Record was not stamped and written: %v", s.records)
+	}
+}
+
+func TestLogRecordAndQuery(t *testing.T) {
+	broken := &memSink{err: errors.New("disk full")}
+	plain := &memSink{}
+	q := &queryableSink{}
+	l := &Log{sinks: []Sink{broken, plain, q}}
+
+	for i, target := range []string{"foo", "bar", "foo", "foo"} {
+		l.Record(&pb.AuditRecord{
+			Time:   proto.Int64(int64(100 + i)),
+			Actor:  proto.String("admin"),
+			Action: proto.String("ModifyEntityMeta"),
+			Target: proto.String(target),
+		})
+	}
+	if len(plain.records) != 4 || len(q.records) != 4 {
+		t.Fatalf("A failing sink stopped the others: %d %d", len(plain.records), len(q.records))
+	}
+
+	records, err := l.Query(Filter{Target: "foo", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].GetTime() != 102 || records[1].GetTime() != 103 {
+		t.Errorf("Wrong records: %v", records)
+	}
+
+	l = &Log{sinks: []Sink{plain}}
+	if _, err := l.Query(Filter{}); err != ErrNotQueryable {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	r := &pb.AuditRecord{
+		Time:   proto.Int64(1000),
+		Actor:  proto.String("admin"),
+		Action: proto.String("NewGroup"),
+		Target: proto.String("foo"),
+	}
+
+	s := []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{}, true},
+		{Filter{Actor: "admin", Action: "NewGroup", Target: "foo"}, true},
+		{Filter{Actor: "someone"}, false},
+		{Filter{Action: "DeleteGroup"}, false},
+		{Filter{Target: "bar"}, false},
+		{Filter{Since: time.Unix(1000, 0), Until: time.Unix(1000, 0)}, true},
+		{Filter{Since: time.Unix(1001, 0)}, false},
+		{Filter{Until: time.Unix(999, 0)}, false},
+	}
+
+	for i, c := range s {
+		if got := c.f.Match(r); got != c.want {
+			t.Errorf("%d: Got %t; Want %t", i, got, c.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := &pb.Entity{
+		ID:     proto.String("foo"),
+		Number: proto.Int32(1),
+		Secret: proto.String("hash1"),
+		Meta: &pb.EntityMeta{
+			Shell:  proto.String("/bin/sh"),
+			Groups: []string{"a"},
+		},
+	}
+	after := &pb.Entity{
+		ID:     proto.String("foo"),
+		Number: proto.Int32(1),
+		Secret: proto.String("hash2"),
+		Meta: &pb.EntityMeta{
+			Shell:        proto.String("/bin/bash"),
+			Groups:       []string{"a", "b"},
+			Capabilities: []pb.Capability{pb.Capability_GLOBAL_ROOT},
+		},
+	}
+
+	want := map[string][2]string{
+		"Secret":            {"<REDACTED>", "<REDACTED>"},
+		"Meta.Shell":        {"/bin/sh", "/bin/bash"},
+		"Meta.Groups":       {"a", "a, b"},
+		"Meta.Capabilities": {"", "GLOBAL_ROOT"},
+	}
+	changes := Diff(before, after)
+	if len(changes) != len(want) {
+		t.Errorf("Wrong changes: %v", changes)
+	}
+	for _, c := range changes {
+		w, ok := want[c.GetField()]
+		if !ok || c.GetBefore() != w[0] || c.GetAfter() != w[1] {
+			t.Errorf("Wrong change: %v", c)
+		}
+	}
+
+	// A created record shows every field that is set, an unset
+	// nested message shows nothing.
+	changes = Diff(nil, &pb.Group{Name: proto.String("foo"), Number: proto.Int32(2)})
+	if len(changes) != 2 {
+		t.Errorf("Wrong changes for a new record: %v", changes)
+	}
+
+	var deleted *pb.Group
+	changes = Diff(&pb.Group{Name: proto.String("foo")}, deleted)
+	if len(changes) != 1 || changes[0].GetAfter() != "" {
+		t.Errorf("Wrong changes for a deleted record: %v", changes)
+	}
+
+	if changes := Diff(nil, nil); len(changes) != 0 {
+		t.Errorf("Changes between nothing: %v", changes)
+	}
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// redacted fields hold secrets, so only the fact that they changed is
+// recorded.
+var redacted = map[string]bool{
+	"Secret":        true,
+	"SecretHistory": true,
+}
+
+// Diff lists the fields that differ between two versions of a record.
+// Either may be nil for a record that was created or deleted.
+// Nested messages are compared field by field, and a field that is
+// unset is the same as one set to its zero value.
+func Diff(before, after proto.Message) []*pb.AuditChange {
+	b := reflect.ValueOf(before)
+	a := reflect.ValueOf(after)
+
+	var t reflect.Type
+	switch {
+	case b.IsValid() && !b.IsNil():
+		t = b.Type()
+	case a.IsValid() && !a.IsNil():
+		t = a.Type()
+	default:
+		return nil
+	}
+	if !b.IsValid() {
+		b = reflect.Zero(t)
+	}
+	if !a.IsValid() {
+		a = reflect.Zero(t)
+	}
+
+	var changes []*pb.AuditChange
+	diffMessage("", t.Elem(), b, a, &changes)
+	return changes
+}
+
+// diffMessage compares two pointers to messages of type t, either of
+// which may be nil.
+func diffMessage(prefix string, t reflect.Type, b, a reflect.Value, changes *[]*pb.AuditChange) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.HasPrefix(f.Name, "XXX_") {
+			continue
+		}
+		bv := field(b, i, f.Type)
+		av := field(a, i, f.Type)
+		name := prefix + f.Name
+
+		if f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct {
+			diffMessage(name+".", f.Type.Elem(), bv, av, changes)
+			continue
+		}
+
+		before, after := format(bv), format(av)
+		if before == after {
+			continue
+		}
+		if redacted[f.Name] {
+			before, after = redact(before), redact(after)
+		}
+		*changes = append(*changes, &pb.AuditChange{
+			Field:  proto.String(name),
+			Before: proto.String(before),
+			After:  proto.String(after),
+		})
+	}
+}
+
+// field returns field i of the message that p points to, or the zero
+// value if p is nil.
+func field(p reflect.Value, i int, t reflect.Type) reflect.Value {
+	if p.IsNil() {
+		return reflect.Zero(t)
+	}
+	return p.Elem().Field(i)
+}
+
+func format(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		if m, ok := v.Interface().(proto.Message); ok {
+			return proto.CompactTextString(m)
+		}
+		return fmt.Sprint(v.Elem().Interface())
+	case reflect.Slice:
+		l := make([]string, v.Len())
+		for i := range l {
+			l[i] = format(v.Index(i))
+		}
+		return strings.Join(l, ", ")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "<REDACTED>"
+}
@@ -0,0 +1,15 @@
+package audit
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnknownSink is returned for an attempt to record to a
+	// sink that hasn't been registered.
+	ErrUnknownSink = errors.New("the specified audit sink does not exist")
+
+	// ErrNotQueryable is returned when records are requested but
+	// none of the sinks in use can read them back.
+	ErrNotQueryable = errors.New("no audit sink in use can be queried")
+)
@@ -0,0 +1,93 @@
+// Package jsonlines is an audit sink that writes one JSON object per
+// line to a local file.  The file is rotated when it grows too large,
+// and the records in it can be queried.
+package jsonlines
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	file    = flag.String("audit_jsonl_file", "audit.jsonl", "File to write JSON lines audit records to")
+	maxSize = flag.Int64("audit_jsonl_max_size", 100<<20, "Size in bytes at which the audit file is rotated, 0 disables rotation")
+	keep    = flag.Int("audit_jsonl_keep", 0, "Number of rotated audit files to keep, 0 keeps them all")
+)
+
+// Sink appends audit records to a file as JSON lines.
+type Sink struct {
+	f *audit.RotatingFile
+}
+
+func init() {
+	audit.Register("JSONLines", New)
+}
+
+// New returns a sink writing to the file named on the command line.
+func New() (audit.Sink, error) {
+	return open(*file, *maxSize, *keep)
+}
+
+func open(path string, maxSize int64, keep int) (*Sink, error) {
+	f, err := audit.OpenRotatingFile(path, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{f: f}, nil
+}
+
+// Append writes the record as a single line.
+func (s *Sink) Append(r *pb.AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Query reads back every file in turn.  Lines that cannot be read,
+// such as one left part written by a crash, are logged and skipped.
+// Only the newest matches up to the Limit of the filter are held on
+// to while reading.
+func (s *Sink) Query(f audit.Filter) ([]*pb.AuditRecord, error) {
+	var records []*pb.AuditRecord
+	err := s.f.Read(func(rd io.Reader) error {
+		scanner := bufio.NewScanner(rd)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			r := &pb.AuditRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+				log.Printf("Skipping unreadable audit record: %s", err)
+				continue
+			}
+			if !f.Match(r) {
+				continue
+			}
+			records = append(records, r)
+			if f.Limit > 0 && len(records) >= 2*f.Limit {
+				records = append(records[:0], records[len(records)-f.Limit:]...)
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[len(records)-f.Limit:]
+	}
+	return records, nil
+}
+
+// Close closes the file.
+func (s *Sink) Close() error {
+	return s.f.Close()
+}
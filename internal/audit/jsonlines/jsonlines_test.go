@@ -0,0 +1,112 @@
+package jsonlines
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func TestAppendQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlinestest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	s, err := open(path, 512, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i, target := range []string{"foo", "bar", "foo", "baz", "foo"} {
+		r := &pb.AuditRecord{
+			Time:   proto.Int64(int64(100 + i)),
+			Actor:  proto.String("admin"),
+			Action: proto.String("ModifyEntityMeta"),
+			Target: proto.String(target),
+			Changes: []*pb.AuditChange{{
+				Field:  proto.String("Meta.Shell"),
+				Before: proto.String("/bin/sh"),
+				After:  proto.String("/bin/bash"),
+			}},
+		}
+		if err := s.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A line that was cut short doesn't hide the others.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"Time\":1\n")
+	f.Close()
+
+	// The records were spread over several files.
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.Query(audit.Filter{Target: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Wrong records: %v", records)
+	}
+	for i, r := range records {
+		if r.GetTime() != int64(100+2*i) {
+			t.Errorf("Records out of order: %v", records)
+		}
+		if len(r.GetChanges()) != 1 || r.GetChanges()[0].GetAfter() != "/bin/bash" {
+			t.Errorf("Changes lost: %v", r)
+		}
+	}
+}
+
+func TestQueryLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlinestest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := open(filepath.Join(dir, "audit.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		r := &pb.AuditRecord{
+			Time:   proto.Int64(int64(100 + i)),
+			Action: proto.String("ModifyEntityMeta"),
+		}
+		if err := s.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := s.Query(audit.Filter{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Wrong records: %v", records)
+	}
+	for i, r := range records {
+		if r.GetTime() != int64(107+i) {
+			t.Errorf("Wrong records: %v", records)
+		}
+	}
+}
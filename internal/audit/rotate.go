@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A RotatingFile is a file that is only ever appended to.  When a
+// write would take it past its maximum size the file is moved aside
+// to path.1, older files move along to path.2 and so on, and a new
+// file is started.  If keep is greater than 0 only that many old
+// files are kept, otherwise they all are.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it
+// if necessary.  A maxSize of 0 disables rotation, and a keep of 0
+// keeps every old file.
+func OpenRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+// Write appends p to the file in a single write, so that p is never
+// split across two files.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) name(i int) string {
+	if i == 0 {
+		return rf.path
+	}
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// oldest returns the index of the oldest file.  Files are numbered
+// from 0 with no gaps, except that when only keep files are kept some
+// of them may not exist yet.
+func (rf *RotatingFile) oldest() int {
+	if rf.keep > 0 {
+		return rf.keep
+	}
+	i := 0
+	for {
+		if _, err := os.Stat(rf.name(i + 1)); err != nil {
+			return i
+		}
+		i++
+	}
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	// Whatever happens to the old files a current file is needed
+	// for the next write.
+	err := rf.shift()
+	if oerr := rf.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+// shift moves each file along by one.  If only keep files are kept
+// the oldest falls off the end.
+func (rf *RotatingFile) shift() error {
+	n := rf.oldest()
+	if rf.keep > 0 {
+		if err := os.Remove(rf.name(n)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		n--
+	}
+	for i := n; i >= 0; i-- {
+		if err := os.Rename(rf.name(i), rf.name(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read calls fn with each of the files in turn, oldest first.  The
+// files are opened before fn is first called, and writes and
+// rotations carry on while fn runs.
+func (rf *RotatingFile) Read(fn func(io.Reader) error) error {
+	files, err := rf.openAll()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openAll opens every file for reading, oldest first.  A file that is
+// open stays readable after it has been renamed or removed, so once
+// this returns rotations no longer matter to the reader.
+func (rf *RotatingFile) openAll() ([]*os.File, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	var files []*os.File
+	for i := rf.oldest(); i >= 0; i-- {
+		f, err := os.Open(rf.name(i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, rf *RotatingFile) string {
+	var b strings.Builder
+	err := rf.Read(func(r io.Reader) error {
+		_, err := io.Copy(&b, r)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit")
+
+	rf, err := OpenRotatingFile(path, 8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaa\n", "bbb\n", "cc\n", "d\n", "eeeeeeeeee\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The oldest file has fallen off the end, and the write that
+	// was larger than the maximum still went in whole.
+	if got := readAll(t, rf); got != "bbb\ncc\nd\neeeeeeeeee\n" {
+		t.Errorf("Wrong contents: %q", got)
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Too many files kept: %v", err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening appends to what is already there.
+	rf, err = OpenRotatingFile(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("f\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, rf); got != "bbb\ncc\nd\neeeeeeeeee\nf\n" {
+		t.Errorf("Wrong contents after reopening: %q", got)
+	}
+}
+
+func TestRotatingFileKeepAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit")
+
+	rf, err := OpenRotatingFile(path, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, s := range []string{"aaa\n", "bbb\n", "ccc\n", "ddd\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := readAll(t, rf); got != "aaa\nbbb\nccc\nddd\n" {
+		t.Errorf("Wrong contents: %q", got)
+	}
+	for _, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRotatingFileFailedRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit")
+
+	rf, err := OpenRotatingFile(path, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err := rf.Write([]byte("aaa\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory where the oldest file is fails the rotation,
+	// but the file can still be written once it is gone.
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("bbb\n")); err == nil {
+		t.Fatal("Rotation did not fail")
+	}
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("ccc\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, rf); got != "aaa\nccc\n" {
+		t.Errorf("Wrong contents: %q", got)
+	}
+}
+
+func TestRotatingFileReadUnlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audittest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit")
+
+	rf, err := OpenRotatingFile(path, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, s := range []string{"aaa\n", "bbb\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writing from within fn would deadlock if the file were
+	// locked, and rotating away the files being read doesn't
+	// change what is read.
+	var b strings.Builder
+	err = rf.Read(func(r io.Reader) error {
+		if _, err := rf.Write([]byte("ccc\n")); err != nil {
+			return err
+		}
+		_, err := io.Copy(&b, r)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "aaa\nbbb\n" {
+		t.Errorf("Wrong contents: %q", got)
+	}
+}
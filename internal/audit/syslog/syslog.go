@@ -0,0 +1,119 @@
+// Package syslog is an audit sink that writes records to a local file
+// in the RFC 5424 syslog format, for collection by tools that already
+// understand syslog.  The file is rotated when it grows too large.
+package syslog
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	file    = flag.String("audit_syslog_file", "audit.log", "File to write syslog format audit records to")
+	maxSize = flag.Int64("audit_syslog_max_size", 100<<20, "Size in bytes at which the syslog audit file is rotated, 0 disables rotation")
+	keep    = flag.Int("audit_syslog_keep", 10, "Number of rotated syslog audit files to keep")
+)
+
+// Records are logged to the authpriv facility, as a notice when the
+// change was made and as a warning when it failed.
+const (
+	priNotice  = 10*8 + 5
+	priWarning = 10*8 + 4
+
+	// sdID identifies the structured data element, using the
+	// example enterprise number reserved by RFC 5612.
+	sdID = "audit@32473"
+)
+
+// Sink appends audit records to a file as syslog messages.
+type Sink struct {
+	f        *audit.RotatingFile
+	hostname string
+	pid      int
+}
+
+func init() {
+	audit.Register("Syslog", New)
+}
+
+// New returns a sink writing to the file named on the command line.
+func New() (audit.Sink, error) {
+	return open(*file, *maxSize, *keep)
+}
+
+func open(path string, maxSize int64, keep int) (*Sink, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	f, err := audit.OpenRotatingFile(path, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{f: f, hostname: hostname, pid: os.Getpid()}, nil
+}
+
+// Append writes the record as a single message.
+func (s *Sink) Append(r *pb.AuditRecord) error {
+	_, err := s.f.Write(s.format(r))
+	return err
+}
+
+// format renders the record with the details in structured data,
+// followed by a readable summary of what changed.
+func (s *Sink) format(r *pb.AuditRecord) []byte {
+	pri := priNotice
+	if r.GetError() != "" {
+		pri = priWarning
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s netauthd %d %s [%s", pri,
+		time.Unix(r.GetTime(), 0).UTC().Format(time.RFC3339),
+		s.hostname,
+		s.pid,
+		r.GetAction(),
+		sdID)
+	for _, p := range []struct{ name, value string }{
+		{"actor", r.GetActor()},
+		{"client", r.GetClientID()},
+		{"service", r.GetService()},
+		{"target", r.GetTarget()},
+		{"error", r.GetError()},
+	} {
+		if p.value != "" {
+			fmt.Fprintf(&b, " %s=\"%s\"", p.name, escape(p.value))
+		}
+	}
+	b.WriteString("]")
+
+	var msg []string
+	for _, c := range r.GetChanges() {
+		msg = append(msg, fmt.Sprintf("%s: %q -> %q", c.GetField(), c.GetBefore(), c.GetAfter()))
+	}
+	if len(msg) != 0 {
+		b.WriteString(" ")
+		b.WriteString(strings.Join(msg, "; "))
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// escape escapes the characters that are special in structured data
+// parameter values.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// Close closes the file.
+func (s *Sink) Close() error {
+	return s.f.Close()
+}
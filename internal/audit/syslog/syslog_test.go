@@ -0,0 +1,83 @@
+package syslog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func TestFormat(t *testing.T) {
+	s := &Sink{hostname: "host", pid: 42}
+
+	cases := []struct {
+		r    *pb.AuditRecord
+		want string
+	}{
+		{
+			&pb.AuditRecord{
+				Time:     proto.Int64(0),
+				Actor:    proto.String("admin"),
+				ClientID: proto.String("laptop"),
+				Service:  proto.String("netauth"),
+				Action:   proto.String("ModifyGroupMeta"),
+				Target:   proto.String(`a"b]c`),
+				Changes: []*pb.AuditChange{{
+					Field:  proto.String("DisplayName"),
+					Before: proto.String(""),
+					After:  proto.String("Group A"),
+				}},
+			},
+			`<85>1 1970-01-01T00:00:00Z host netauthd 42 ModifyGroupMeta [audit@32473 actor="admin" client="laptop" service="netauth" target="a\"b\]c"] DisplayName: "" -> "Group A"` + "\n",
+		},
+		{
+			&pb.AuditRecord{
+				Time:   proto.Int64(60),
+				Actor:  proto.String("admin"),
+				Action: proto.String("DeleteGroup"),
+				Target: proto.String("foo"),
+				Error:  proto.String("still referenced"),
+			},
+			`<84>1 1970-01-01T00:01:00Z host netauthd 42 DeleteGroup [audit@32473 actor="admin" target="foo" error="still referenced"]` + "\n",
+		},
+	}
+
+	for i, c := range cases {
+		if got := string(s.format(c.r)); got != c.want {
+			t.Errorf("%d: Got %q; Want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslogtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	s, err := open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &pb.AuditRecord{Time: proto.Int64(0), Action: proto.String("NewEntity")}
+	if err := s.Append(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(s.format(r)) {
+		t.Errorf("Wrong file contents: %q", b)
+	}
+}
@@ -0,0 +1,8 @@
+package all
+
+import (
+	// The blank import here permits the init() within the crypt
+	// module to register its implementation to the crypto plugin
+	// system.
+	_ "github.com/NetAuth/NetAuth/internal/crypto/crypt"
+)
@@ -0,0 +1,170 @@
+package argon2
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+)
+
+var (
+	memory      = flag.Uint("argon2_memory", 64*1024, "Memory in KiB to use when running the argon2id hashing algorithm")
+	timeCost    = flag.Uint("argon2_time", 3, "Number of passes to make when running the argon2id hashing algorithm")
+	parallelism = flag.Uint("argon2_parallelism", 2, "Number of threads to use when running the argon2id hashing algorithm")
+)
+
+const (
+	prefix  = "$argon2id$"
+	saltLen = 16
+	keyLen  = 32
+
+	// Hashes are refused if they ask for more than maxMemory KiB,
+	// or have a key outside of minKeyLen to maxKeyLen bytes, so
+	// that a hash can't be used to make the server allocate
+	// without bound.
+	maxMemory = 4 * 1024 * 1024
+	minKeyLen = 16
+	maxKeyLen = 64
+)
+
+func init() {
+	crypto.Register("argon2id", New)
+}
+
+// Engine binds the functions of the argon2id crypto system and
+// satisfies the crypto.EMCrypto interface.  The memory, time and
+// parallelism parameters set the cost of the algorithm and should be
+// set on a per-site basis.
+type Engine struct {
+	params params
+}
+
+// params holds the tunable parameters of argon2id, as they are
+// encoded into each hash.
+type params struct {
+	memory      uint32
+	time        uint32
+	parallelism uint8
+}
+
+// New registers this crypto type for use by the NetAuth server.
+func New() (crypto.EMCrypto, error) {
+	if *timeCost < 1 || *parallelism < 1 || *parallelism > 255 || *memory > maxMemory {
+		log.Printf("Crypto Fault: invalid argon2id parameters m=%d t=%d p=%d", *memory, *timeCost, *parallelism)
+		return nil, crypto.ErrInternalError
+	}
+	x := new(Engine)
+	x.params = params{
+		memory:      uint32(*memory),
+		time:        uint32(*timeCost),
+		parallelism: uint8(*parallelism),
+	}
+	return x, nil
+}
+
+// SecureSecret takes in a secret and generates an argon2id hash from
+// it in the PHC string format.  This is then returned for storage in
+// the database.
+func (a *Engine) SecureSecret(secret string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		log.Printf("Crypto Fault: %s", err)
+		return "", crypto.ErrInternalError
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, a.params.time, a.params.memory, a.params.parallelism, keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefix,
+		argon2.Version,
+		a.params.memory,
+		a.params.time,
+		a.params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifySecret verifies a given secret against a given hash and
+// returns either nil for a match or a crypto.ErrAuthorizationFailure
+// in the case that the secret did not match the stored one.  The
+// parameters stored in the hash are used, not the configured ones.
+func (a *Engine) VerifySecret(secret, hash string) error {
+	p, salt, key, err := decode(hash)
+	if err != nil {
+		log.Printf("Crypto Error: %s", err)
+		return crypto.ErrAuthorizationFailure
+	}
+
+	other := argon2.IDKey([]byte(secret), salt, p.time, p.memory, p.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return crypto.ErrAuthorizationFailure
+	}
+	return nil
+}
+
+// Recognizes returns true if the hash was produced by argon2id.
+func (a *Engine) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, prefix)
+}
+
+// Parses returns true if the hash is an argon2id hash with usable
+// parameters.
+func (a *Engine) Parses(hash string) bool {
+	_, _, _, err := decode(hash)
+	return err == nil
+}
+
+// NeedsRehash returns true if the hash was produced with parameters
+// other than the ones currently configured.
+func (a *Engine) NeedsRehash(hash string) bool {
+	p, _, _, err := decode(hash)
+	if err != nil {
+		return false
+	}
+	return p != a.params
+}
+
+// decode splits a hash into its parameters, salt and key.
+func decode(hash string) (params, []byte, []byte, error) {
+	var p params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || "$"+parts[1]+"$" != prefix {
+		return p, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var v int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &v); err != nil {
+		return p, nil, nil, err
+	}
+	if v != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", v)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.parallelism); err != nil {
+		return p, nil, nil, err
+	}
+	if p.time < 1 || p.parallelism < 1 || p.memory > maxMemory {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters %s", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	if len(key) < minKeyLen || len(key) > maxKeyLen {
+		return p, nil, nil, fmt.Errorf("invalid argon2id key length %d", len(key))
+	}
+	return p, salt, key, nil
+}
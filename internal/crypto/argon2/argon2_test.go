@@ -0,0 +1,152 @@
+package argon2
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+)
+
+func newTestEngine(t *testing.T) crypto.EMCrypto {
+	// Keep the cost low, this is only testing correctness.
+	*memory = 1024
+	*timeCost = 1
+	*parallelism = 1
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	e := newTestEngine(t)
+
+	hash, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Bad hash format: %s", hash)
+	}
+
+	if err := e.VerifySecret("foo", hash); err != nil {
+		t.Error(err)
+	}
+	if !e.(crypto.Parser).Parses(hash) {
+		t.Errorf("Hash does not parse: %s", hash)
+	}
+	if err := e.VerifySecret("bar", hash); err != crypto.ErrAuthorizationFailure {
+		t.Error(err)
+	}
+}
+
+func TestSaltedHashes(t *testing.T) {
+	e := newTestEngine(t)
+
+	h1, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 == h2 {
+		t.Error("Hashes of the same secret are identical")
+	}
+}
+
+func TestBadDecode(t *testing.T) {
+	e := newTestEngine(t)
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, keyLen))
+	long := base64.RawStdEncoding.EncodeToString(make([]byte, maxKeyLen+1))
+
+	hashes := []string{
+		"",
+		"$argon2id$",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=foo$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$!!!",
+
+		// Parameters that can't be used, or would cost too
+		// much to try.
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$" + long,
+	}
+	for i, h := range hashes {
+		if err := e.VerifySecret("foo", h); err != crypto.ErrAuthorizationFailure {
+			t.Errorf("%d: Bad crypto error: %v", i, err)
+		}
+		if e.(crypto.Parser).Parses(h) {
+			t.Errorf("%d: Bad hash parses", i)
+		}
+	}
+}
+
+func TestBadParameters(t *testing.T) {
+	*timeCost = 0
+	if _, err := New(); err != crypto.ErrInternalError {
+		t.Error(err)
+	}
+
+	*timeCost = 1
+	*parallelism = 256
+	if _, err := New(); err != crypto.ErrInternalError {
+		t.Error(err)
+	}
+
+	*parallelism = 1
+	*memory = maxMemory + 1
+	if _, err := New(); err != crypto.ErrInternalError {
+		t.Error(err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	e := newTestEngine(t)
+	hash, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := e.(crypto.Rehasher)
+	if r.NeedsRehash(hash) {
+		t.Error("Current hash needs a rehash")
+	}
+
+	// Raising the cost makes the old hash outdated, but it must
+	// still verify.
+	*timeCost = 2
+	e2, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e2.(crypto.Rehasher).NeedsRehash(hash) {
+		t.Error("Outdated hash does not need a rehash")
+	}
+	if err := e2.VerifySecret("foo", hash); err != nil {
+		t.Error(err)
+	}
+
+	if r.NeedsRehash("not a hash") {
+		t.Error("Garbage needs a rehash")
+	}
+}
+
+func TestRecognizes(t *testing.T) {
+	e := newTestEngine(t).(crypto.Recognizer)
+
+	if !e.Recognizes("$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5") {
+		t.Error("argon2id hash not recognized")
+	}
+	if e.Recognizes("$2a$10$abcdefghijklmnopqrstuv") {
+		t.Error("bcrypt hash recognized")
+	}
+}
@@ -3,6 +3,7 @@ package bcrypt
 import (
 	"flag"
 	"log"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -13,6 +14,14 @@ var (
 	cost = flag.Int("bcrypt_cost", 15, "Cost to use when running the bcrypt hashing algorithm")
 )
 
+const (
+	// A hash is the version, the cost and then the salt and key,
+	// which are encoded with bcrypt64.
+	hashLen    = 60
+	saltKeyLen = 53
+	bcrypt64   = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
 func init() {
 	crypto.Register("bcrypt", New)
 }
@@ -54,3 +63,40 @@ func (b *Engine) VerifySecret(secret, hash string) error {
 	}
 	return nil
 }
+
+// Recognizes returns true if the hash was produced by bcrypt.
+func (b *Engine) Recognizes(hash string) bool {
+	for _, p := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses returns true if the hash is a bcrypt hash with a usable cost
+// and a salt and key of the right length.
+func (b *Engine) Parses(hash string) bool {
+	if !b.Recognizes(hash) || len(hash) != hashLen {
+		return false
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return false
+	}
+	for _, r := range hash[len(hash)-saltKeyLen:] {
+		if !strings.ContainsRune(bcrypt64, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsRehash returns true if the hash was produced with a cost other
+// than the one currently configured.
+func (b *Engine) NeedsRehash(hash string) bool {
+	c, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false
+	}
+	return c != b.cost
+}
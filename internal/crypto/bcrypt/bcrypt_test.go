@@ -58,3 +58,57 @@ func TestCostTooHigh(t *testing.T) {
 		t.Errorf("Bcrypt error: %s", err)
 	}
 }
+
+func TestRecognizes(t *testing.T) {
+	*cost = 4
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := e.(crypto.Recognizer)
+	if !r.Recognizes(hash) {
+		t.Errorf("bcrypt hash not recognized: %s", hash)
+	}
+	if r.Recognizes("$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5") {
+		t.Error("argon2id hash recognized")
+	}
+
+	p := e.(crypto.Parser)
+	if !p.Parses(hash) {
+		t.Errorf("bcrypt hash does not parse: %s", hash)
+	}
+	for _, h := range []string{"$2a$10$abcdefghijklmnopqrstuv", "$2a$99$" + hash[7:], hash[:59] + "!"} {
+		if p.Parses(h) {
+			t.Errorf("Bad hash parses: %s", h)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	*cost = 4
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := e.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.(crypto.Rehasher).NeedsRehash(hash) {
+		t.Error("Current hash needs a rehash")
+	}
+
+	*cost = 5
+	e, err = New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.(crypto.Rehasher).NeedsRehash(hash) {
+		t.Error("Outdated hash does not need a rehash")
+	}
+}
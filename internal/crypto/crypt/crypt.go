@@ -0,0 +1,110 @@
+// Package crypt verifies secrets against hashes in the crypt(3)
+// formats found in /etc/shadow and many LDAP directories.  It can't
+// secure new secrets, it exists so that such hashes can be imported
+// and then upgraded to the primary engine on the next successful
+// authentication.
+package crypt
+
+import (
+	"crypto/subtle"
+	"log"
+	"strings"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+)
+
+func init() {
+	crypto.Register("crypt", New)
+}
+
+// Engine binds the functions of the crypt(3) verifier and satisfies
+// the crypto.EMCrypto interface.  The engine is verify-only.
+type Engine struct{}
+
+// New registers this crypto type for use by the NetAuth server.
+func New() (crypto.EMCrypto, error) {
+	return new(Engine), nil
+}
+
+// SecureSecret always fails, crypt(3) formats are only supported for
+// verification.
+func (c *Engine) SecureSecret(string) (string, error) {
+	log.Println("Crypto Fault: the crypt engine can't secure secrets")
+	return "", crypto.ErrVerifyOnly
+}
+
+// VerifySecret verifies a given secret against a given hash and
+// returns either nil for a match or a crypto.ErrAuthorizationFailure
+// in the case that the secret did not match the stored one.
+func (c *Engine) VerifySecret(secret, hash string) error {
+	computed, err := compute(secret, hash)
+	if err != nil {
+		log.Printf("Crypto Error: %s", err)
+		return crypto.ErrAuthorizationFailure
+	}
+
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) != 1 {
+		return crypto.ErrAuthorizationFailure
+	}
+	return nil
+}
+
+// Recognizes returns true if the hash is in one of the supported
+// crypt(3) formats.
+func (c *Engine) Recognizes(hash string) bool {
+	for _, p := range []string{sha512Prefix, sha256Prefix, md5Prefix} {
+		if strings.HasPrefix(hash, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses returns true if the hash is in one of the supported crypt(3)
+// formats and a secret could match it.  The settings are checked by
+// computing a hash with them, which must come out in the same form.
+func (c *Engine) Parses(hash string) bool {
+	computed, err := compute("", hash)
+	if err != nil || len(computed) != len(hash) {
+		return false
+	}
+	i := strings.LastIndexByte(hash, '$')
+	if computed[:i] != hash[:i] {
+		return false
+	}
+	for _, r := range hash[i+1:] {
+		if !strings.ContainsRune(crypt64, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// compute hashes the secret with the algorithm and settings of the
+// given hash.
+func compute(secret, hash string) (string, error) {
+	switch {
+	case strings.HasPrefix(hash, sha512Prefix):
+		return shaCrypt(sha512Spec, secret, hash)
+	case strings.HasPrefix(hash, sha256Prefix):
+		return shaCrypt(sha256Spec, secret, hash)
+	case strings.HasPrefix(hash, md5Prefix):
+		return md5Crypt(secret, hash)
+	}
+	return "", errUnknownFormat
+}
+
+// crypt64 is the alphabet used by crypt(3) to encode hashes.  It is
+// not the same as any of the standard base64 alphabets.
+const crypt64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// encode24 appends n characters encoding the three bytes given, least
+// significant first.
+func encode24(out []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out = append(out, crypt64[w&0x3f])
+		w >>= 6
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+)
+
+func TestVerifySecret(t *testing.T) {
+	// Hashes produced by glibc and openssl.
+	cases := []struct {
+		secret string
+		hash   string
+	}{
+		{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$6$rounds=1400$anotherlongsalts$5FGyu8c4BZDX4wJgs0Un26YOw2XibT5eTkHF1I1aP3QqStoJI9BHD2YPJYsAjEePVGUyBjdZxcNqMWlrrbIOC."},
+		{"a much longer password than sixty four bytes, which is the sha512 digest size!", "$6$x$t1TGoeifhqkMvXxbPwaJvTDwSxTCwVy1EqDpXJ0mKJ55iARjo1h1N4CAeewrU24x0lz9opadMgib34ZhMuM75."},
+		{"password", "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"", "$1$abc$Or2rbeUYTvt12aiVzMuS/."},
+		{"a much longer password than sixteen bytes", "$1$12345678$yLppq.aqtfjKiej5RWDLq/"},
+	}
+
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		if err := e.VerifySecret(c.secret, c.hash); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+		if !e.(crypto.Parser).Parses(c.hash) {
+			t.Errorf("%d: Hash does not parse", i)
+		}
+		if err := e.VerifySecret(c.secret+"x", c.hash); err != crypto.ErrAuthorizationFailure {
+			t.Errorf("%d: Wrong secret accepted: %v", i, err)
+		}
+	}
+}
+
+func TestVerifySecretBadHash(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := []string{
+		"",
+		"$2a$10$abcdefghijklmnopqrstuv",
+		"$5$rounds=",
+		"$6$rounds=lots$salt$hash",
+		"$6$saltstring$truncated",
+		"$6$saltstring",
+		"$6$rounds=10$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35in!1",
+		"$1$saltsalt$qjXMvbEw8oaL.CzflDtaK",
+	}
+	for i, h := range hashes {
+		if err := e.VerifySecret("Hello world!", h); err != crypto.ErrAuthorizationFailure {
+			t.Errorf("%d: %v", i, err)
+		}
+		if e.(crypto.Parser).Parses(h) {
+			t.Errorf("%d: Bad hash parses", i)
+		}
+	}
+}
+
+func TestSecureSecret(t *testing.T) {
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.SecureSecret("foo"); err != crypto.ErrVerifyOnly {
+		t.Error(err)
+	}
+}
+
+func TestRecognizes(t *testing.T) {
+	e := new(Engine)
+
+	for _, h := range []string{"$1$abc$", "$5$abc$", "$6$abc$"} {
+		if !e.Recognizes(h) {
+			t.Errorf("%s not recognized", h)
+		}
+	}
+	for _, h := range []string{"", "$2a$10$abc", "$argon2id$v=19$", "plain"} {
+		if e.Recognizes(h) {
+			t.Errorf("%s recognized", h)
+		}
+	}
+}
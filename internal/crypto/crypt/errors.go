@@ -0,0 +1,15 @@
+package crypt
+
+import (
+	"errors"
+)
+
+var (
+	// errUnknownFormat is returned for hashes that are not in a
+	// supported crypt(3) format.
+	errUnknownFormat = errors.New("hash is not in a supported crypt format")
+
+	// errBadRounds is returned when the rounds parameter of a
+	// hash can't be parsed.
+	errBadRounds = errors.New("hash has an invalid rounds parameter")
+)
@@ -0,0 +1,76 @@
+package crypt
+
+import (
+	"crypto/md5"
+	"strings"
+)
+
+const (
+	md5Prefix     = "$1$"
+	md5MaxSaltLen = 8
+	md5Rounds     = 1000
+)
+
+// md5Crypt computes the MD5-crypt hash of the secret using the salt
+// of the given hash, and returns it in the same form so that the two
+// can be compared.
+func md5Crypt(secret, setting string) (string, error) {
+	salt := strings.TrimPrefix(setting, md5Prefix)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > md5MaxSaltLen {
+		salt = salt[:md5MaxSaltLen]
+	}
+
+	p := []byte(secret)
+	s := []byte(salt)
+
+	h := md5.New()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	final := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(p)
+	h.Write([]byte(md5Prefix))
+	h.Write(s)
+	h.Write(repeat(final, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+	final = h.Sum(nil)
+
+	for i := 0; i < md5Rounds; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(p)
+		}
+		final = h.Sum(nil)
+	}
+
+	out := []byte(md5Prefix + salt + "$")
+	for _, o := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		out = encode24(out, final[o[0]], final[o[1]], final[o[2]], 4)
+	}
+	out = encode24(out, 0, 0, final[11], 2)
+	return string(out), nil
+}
@@ -0,0 +1,175 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	sha256Prefix = "$5$"
+	sha512Prefix = "$6$"
+
+	roundsPrefix  = "rounds="
+	defaultRounds = 5000
+	minRounds     = 1000
+	maxRounds     = 999999999
+	maxSaltLen    = 16
+)
+
+// shaSpec describes the differences between the SHA-256 and SHA-512
+// variants of the algorithm.
+type shaSpec struct {
+	prefix string
+	new    func() hash.Hash
+
+	// order is the byte order of the final encoding, three bytes
+	// at a time, and tail encodes whatever is left over.
+	order [][3]int
+	tail  func([]byte, []byte) []byte
+}
+
+var sha256Spec = shaSpec{
+	prefix: sha256Prefix,
+	new:    sha256.New,
+	order: [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	},
+	tail: func(out, sum []byte) []byte { return encode24(out, 0, sum[31], sum[30], 3) },
+}
+
+var sha512Spec = shaSpec{
+	prefix: sha512Prefix,
+	new:    sha512.New,
+	order: [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	},
+	tail: func(out, sum []byte) []byte { return encode24(out, 0, 0, sum[63], 2) },
+}
+
+// shaCrypt computes the SHA-crypt hash of the secret using the
+// parameters of the given hash, and returns it in the same form so
+// that the two can be compared.
+func shaCrypt(spec shaSpec, secret, setting string) (string, error) {
+	rest := strings.TrimPrefix(setting, spec.prefix)
+
+	rounds := defaultRounds
+	customRounds := false
+	if strings.HasPrefix(rest, roundsPrefix) {
+		i := strings.IndexByte(rest, '$')
+		if i < 0 {
+			return "", errBadRounds
+		}
+		r, err := strconv.Atoi(rest[len(roundsPrefix):i])
+		if err != nil {
+			return "", errBadRounds
+		}
+		if r < minRounds {
+			r = minRounds
+		}
+		if r > maxRounds {
+			r = maxRounds
+		}
+		rounds = r
+		customRounds = true
+		rest = rest[i+1:]
+	}
+
+	salt := rest
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > maxSaltLen {
+		salt = salt[:maxSaltLen]
+	}
+
+	p := []byte(secret)
+	s := []byte(salt)
+
+	// Digest B is used to build digest A.
+	h := spec.new()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h = spec.new()
+	h.Write(p)
+	h.Write(s)
+	h.Write(repeat(b, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	// The P and S sequences.
+	h = spec.new()
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+	pseq := repeat(h.Sum(nil), len(p))
+
+	h = spec.new()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	sseq := repeat(h.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h = spec.new()
+		if i&1 != 0 {
+			h.Write(pseq)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sseq)
+		}
+		if i%7 != 0 {
+			h.Write(pseq)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pseq)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := []byte(spec.prefix)
+	if customRounds {
+		out = append(out, roundsPrefix+strconv.Itoa(rounds)+"$"...)
+	}
+	out = append(out, salt...)
+	out = append(out, '$')
+	for _, o := range spec.order {
+		out = encode24(out, c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	out = spec.tail(out, c)
+	return string(out), nil
+}
+
+// repeat returns n bytes made by repeating b.
+func repeat(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		r := n - len(out)
+		if r > len(b) {
+			r = len(b)
+		}
+		out = append(out, b[:r]...)
+	}
+	return out
+}
@@ -8,6 +8,27 @@ type EMCrypto interface {
 	VerifySecret(string, string) error
 }
 
+// A Recognizer is an EMCrypto that can tell whether a stored hash was
+// produced by its algorithm.  Engines that implement this can verify
+// secrets even when they are not the engine selected for securing
+// new ones.
+type Recognizer interface {
+	Recognizes(string) bool
+}
+
+// A Parser is an EMCrypto that can tell whether a hash it recognizes
+// is well formed, so that a hash secured elsewhere can be checked
+// before it is stored.
+type Parser interface {
+	Parses(string) bool
+}
+
+// A Rehasher is an EMCrypto that can tell whether a stored hash was
+// produced with outdated parameters and should be secured again.
+type Rehasher interface {
+	NeedsRehash(string) bool
+}
+
 // The Factory type is to be implemented by crypto implementations and
 // shall be fed to the Register function.
 type Factory func() (EMCrypto, error)
@@ -20,4 +20,8 @@ var (
 	// module determines that the provided secret does not match
 	// the one secured earlier.
 	ErrAuthorizationFailure = errors.New("Authorization failed - bad credentials")
+
+	// ErrVerifyOnly is returned when an engine that can only
+	// verify secrets is asked to secure one.
+	ErrVerifyOnly = errors.New("The crypto engine can only verify secrets")
 )
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"log"
+	"sort"
+)
+
+// Multi secures secrets with a primary engine, but verifies them with
+// whichever registered engine recognizes the stored hash.  This lets
+// the primary engine be changed without breaking the secrets already
+// secured by the old one.
+type Multi struct {
+	primary EMCrypto
+	others  []EMCrypto
+}
+
+// NewMulti returns a Multi using the named engine as the primary.
+// Every other registered engine that can recognize its own hashes is
+// initialized for verification.
+func NewMulti(name string) (*Multi, error) {
+	primary, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Multi{primary: primary}
+
+	// Sort the names so that engines are always tried in the
+	// same order.
+	names := GetBackendList()
+	sort.Strings(names)
+	for _, n := range names {
+		if n == name {
+			continue
+		}
+		e, err := New(n)
+		if err != nil {
+			log.Printf("Crypto engine %s unavailable for verification: %s", n, err)
+			continue
+		}
+		if _, ok := e.(Recognizer); ok {
+			m.others = append(m.others, e)
+		}
+	}
+	return m, nil
+}
+
+// SecureSecret secures the secret with the primary engine.
+func (m *Multi) SecureSecret(secret string) (string, error) {
+	return m.primary.SecureSecret(secret)
+}
+
+// VerifySecret verifies the secret with the engine that produced the
+// hash.  Hashes that no engine recognizes are handed to the primary.
+func (m *Multi) VerifySecret(secret, hash string) error {
+	return m.engineFor(hash).VerifySecret(secret, hash)
+}
+
+// NeedsRehash returns true if the hash was produced by an engine
+// other than the primary, or by the primary with outdated
+// parameters.
+func (m *Multi) NeedsRehash(hash string) bool {
+	e := m.engineFor(hash)
+	if e != m.primary {
+		return true
+	}
+	if r, ok := m.primary.(Rehasher); ok {
+		return r.NeedsRehash(hash)
+	}
+	return false
+}
+
+// Recognizes returns true if any of the engines recognizes the hash.
+func (m *Multi) Recognizes(hash string) bool {
+	if r, ok := m.primary.(Recognizer); ok && r.Recognizes(hash) {
+		return true
+	}
+	for _, e := range m.others {
+		if e.(Recognizer).Recognizes(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses returns true if an engine recognizes the hash and finds it
+// well formed.  Engines that can't check their own hashes accept any
+// hash that they recognize.
+func (m *Multi) Parses(hash string) bool {
+	e := m.engineFor(hash)
+	if r, ok := e.(Recognizer); !ok || !r.Recognizes(hash) {
+		return false
+	}
+	if p, ok := e.(Parser); ok {
+		return p.Parses(hash)
+	}
+	return true
+}
+
+// engineFor returns the engine that produced the hash, preferring
+// the primary.
+func (m *Multi) engineFor(hash string) EMCrypto {
+	if r, ok := m.primary.(Recognizer); ok && r.Recognizes(hash) {
+		return m.primary
+	}
+	for _, e := range m.others {
+		if e.(Recognizer).Recognizes(hash) {
+			return e
+		}
+	}
+	return m.primary
+}
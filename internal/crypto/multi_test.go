@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+// prefixCrypto "secures" secrets by adding its prefix to them, and
+// wants a rehash of anything secured with the wrong version.
+type prefixCrypto struct {
+	prefix  string
+	version string
+}
+
+func (p *prefixCrypto) SecureSecret(s string) (string, error) {
+	return p.prefix + p.version + ":" + s, nil
+}
+
+func (p *prefixCrypto) VerifySecret(s, h string) error {
+	if !strings.HasPrefix(h, p.prefix) || !strings.HasSuffix(h, ":"+s) {
+		return ErrAuthorizationFailure
+	}
+	return nil
+}
+
+func (p *prefixCrypto) Recognizes(h string) bool {
+	return strings.HasPrefix(h, p.prefix)
+}
+
+func (p *prefixCrypto) NeedsRehash(h string) bool {
+	return !strings.HasPrefix(h, p.prefix+p.version+":")
+}
+
+func setupMulti(t *testing.T) *Multi {
+	backends = make(map[string]Factory)
+	Register("a", func() (EMCrypto, error) { return &prefixCrypto{"a", "2"}, nil })
+	Register("b", func() (EMCrypto, error) { return &prefixCrypto{"b", "1"}, nil })
+	Register("dummy", dummyCryptoFactory)
+
+	m, err := NewMulti("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMultiSecureSecret(t *testing.T) {
+	m := setupMulti(t)
+
+	h, err := m.SecureSecret("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != "a2:foo" {
+		t.Errorf("Secret secured by wrong engine: %s", h)
+	}
+}
+
+func TestMultiVerifySecret(t *testing.T) {
+	m := setupMulti(t)
+
+	cases := []struct {
+		secret  string
+		hash    string
+		wantErr error
+	}{
+		{"foo", "a2:foo", nil},
+		{"foo", "a1:foo", nil},
+		{"foo", "b1:foo", nil},
+		{"bar", "b1:foo", ErrAuthorizationFailure},
+		{"foo", "c1:foo", ErrAuthorizationFailure},
+	}
+	for i, c := range cases {
+		if err := m.VerifySecret(c.secret, c.hash); err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestMultiNeedsRehash(t *testing.T) {
+	m := setupMulti(t)
+
+	cases := []struct {
+		hash string
+		want bool
+	}{
+		{"a2:foo", false},
+		{"a1:foo", true},
+		{"b1:foo", true},
+		{"c1:foo", true},
+	}
+	for i, c := range cases {
+		if got := m.NeedsRehash(c.hash); got != c.want {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestMultiParses(t *testing.T) {
+	m := setupMulti(t)
+
+	cases := []struct {
+		hash string
+		want bool
+	}{
+		{"a2:foo", true},
+		{"b1:foo", true},
+		{"c1:foo", false},
+	}
+	for i, c := range cases {
+		if got := m.Parses(c.hash); got != c.want {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestNewMultiUnknown(t *testing.T) {
+	backends = make(map[string]Factory)
+	if _, err := NewMulti("unknown"); err != ErrUnknownCrypto {
+		t.Error(err)
+	}
+}
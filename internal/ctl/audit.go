@@ -0,0 +1,98 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/subcommands"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// AuditCmd shows the changes that have been made on the server.
+type AuditCmd struct {
+	actor  string
+	target string
+	action string
+	since  time.Duration
+	limit  int
+}
+
+// Name of this cmdlet is 'audit'
+func (*AuditCmd) Name() string { return "audit" }
+
+// Synopsis returns short-form usage information.
+func (*AuditCmd) Synopsis() string { return "Show the audit log of changes made on the server" }
+
+// Usage returns long-form usage information.
+func (*AuditCmd) Usage() string {
+	return `audit [--actor <ID>] [--target <name>] [--action <method>] [--since <duration>] [--limit <n>]
+
+Show who changed what on the server, oldest first.  The target is the
+entity or group that was changed and the action is the name of the
+request that changed it, such as ModifyEntityMeta.  Requires
+GLOBAL_ROOT.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *AuditCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.actor, "actor", "", "Only show changes made by this entity")
+	f.StringVar(&p.target, "target", "", "Only show changes made to this entity or group")
+	f.StringVar(&p.action, "action", "", "Only show changes made by this request")
+	f.DurationVar(&p.since, "since", 0, "Only show changes made within this long")
+	f.IntVar(&p.limit, "limit", 100, "Show at most this many of the most recent changes, 0 for all")
+}
+
+// Execute runs the cmdlet.
+func (p *AuditCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	query := &pb.QueryAuditRequest{
+		Actor:  &p.actor,
+		Target: &p.target,
+		Action: &p.action,
+		Limit:  proto.Int32(int32(p.limit)),
+	}
+	if p.since != 0 {
+		query.Since = proto.Int64(time.Now().Add(-p.since).Unix())
+	}
+
+	records, err := c.QueryAudit(t, query)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	for _, r := range records {
+		fmt.Printf("%s %s '%s' by %s (%s@%s)\n",
+			time.Unix(r.GetTime(), 0).Format(time.RFC3339),
+			r.GetAction(),
+			r.GetTarget(),
+			r.GetActor(),
+			r.GetService(),
+			r.GetClientID())
+		for _, ch := range r.GetChanges() {
+			fmt.Printf("  %s: %q -> %q\n", ch.GetField(), ch.GetBefore(), ch.GetAfter())
+		}
+		if r.GetError() != "" {
+			fmt.Printf("  Failed: %s\n", r.GetError())
+		}
+	}
+	return subcommands.ExitSuccess
+}
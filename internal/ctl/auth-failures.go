@@ -0,0 +1,82 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+// AuthFailuresCmd shows or clears the failed authentications recorded
+// against an entity.
+type AuthFailuresCmd struct {
+	entityID string
+	clear    bool
+}
+
+// Name of this cmdlet is 'auth-failures'
+func (*AuthFailuresCmd) Name() string { return "auth-failures" }
+
+// Synopsis returns short-form usage information.
+func (*AuthFailuresCmd) Synopsis() string { return "Show or clear failed authentications" }
+
+// Usage returns long-form usage information.
+func (*AuthFailuresCmd) Usage() string {
+	return `auth-failures --entity <entityID> [--clear]
+
+Show the failed authentications that have been counted against an
+entity, or clear them to end a temporary lockout.  Requires the
+LOCK_ENTITY capability.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *AuthFailuresCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.entityID, "entity", getEntity(), "ID for the entity to inspect")
+	f.BoolVar(&p.clear, "clear", false, "Clear the failures for the named entity")
+}
+
+// Execute runs the cmdlet.
+func (p *AuthFailuresCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	if p.clear {
+		result, err := c.ClearAuthFailures(t, p.entityID)
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		fmt.Println(result.GetMsg())
+		return subcommands.ExitSuccess
+	}
+
+	failures, err := c.GetAuthFailures(t, p.entityID)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("Recent failures: %d\n", len(failures.GetTimes()))
+	for _, ts := range failures.GetTimes() {
+		fmt.Printf("  %s\n", time.Unix(ts, 0))
+	}
+	if until := time.Unix(failures.GetLockedUntil(), 0); until.After(time.Now()) {
+		fmt.Printf("Locked out until: %s\n", until)
+	}
+
+	return subcommands.ExitSuccess
+}
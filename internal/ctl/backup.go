@@ -0,0 +1,101 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bgentry/speakeasy"
+	"github.com/google/subcommands"
+)
+
+// BackupCmd saves an archive of everything on the server.
+type BackupCmd struct {
+	out     string
+	encrypt bool
+}
+
+// Name of this cmdlet is 'backup'
+func (*BackupCmd) Name() string { return "backup" }
+
+// Synopsis returns short-form usage information.
+func (*BackupCmd) Synopsis() string { return "Save a backup of the server" }
+
+// Usage returns long-form usage information.
+func (*BackupCmd) Usage() string {
+	return `backup --out <file> [--encrypt]
+
+Save an archive of every entity and group on the server, secrets
+included.  With --encrypt the archive is encrypted to a passphrase
+that will be prompted for.  Requires GLOBAL_ROOT.  The archive can be
+restored with netauthd --restore.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *BackupCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.out, "out", "", "File to write the backup to")
+	f.BoolVar(&p.encrypt, "encrypt", false, "Encrypt the backup to a passphrase")
+}
+
+// Execute runs the cmdlet.
+func (p *BackupCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.out == "" {
+		fmt.Println("--out must be specified")
+		return subcommands.ExitUsageError
+	}
+
+	var passphrase string
+	if p.encrypt {
+		var err error
+		passphrase, err = speakeasy.Ask("Passphrase: ")
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		again, err := speakeasy.Ask("Passphrase again: ")
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		if passphrase != again || passphrase == "" {
+			fmt.Println("Passphrases did not match")
+			return subcommands.ExitFailure
+		}
+	}
+
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	out, err := os.OpenFile(p.out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	if err := c.Backup(t, passphrase, out); err != nil {
+		fmt.Println(err)
+		out.Close()
+		os.Remove(p.out)
+		return subcommands.ExitFailure
+	}
+	if err := out.Close(); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("Backup saved to %s\n", p.out)
+	return subcommands.ExitSuccess
+}
@@ -7,6 +7,9 @@ import (
 
 	"github.com/bgentry/speakeasy"
 	"github.com/google/subcommands"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ChangeSecretCmd services the ChangeSecret RPC
@@ -62,6 +65,13 @@ func (p *ChangeSecretCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 
 	// Change the secret
 	result, err := c.ChangeSecret(getEntity(), getSecret(), p.entityID, p.secret, t)
+	if s, ok := status.FromError(err); ok && s.Code() == codes.FailedPrecondition {
+		// The server refused the secret on policy grounds,
+		// so tell the user why so they can pick a better
+		// one.
+		fmt.Println(s.Message())
+		return subcommands.ExitFailure
+	}
 	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
@@ -11,6 +11,7 @@ import (
 // DestroyGroupCmd deletes a group
 type DestroyGroupCmd struct {
 	groupName string
+	force     bool
 }
 
 // Name returns the name of this cmdlet.
@@ -21,14 +22,17 @@ func (*DestroyGroupCmd) Synopsis() string { return "Delete a group existing on t
 
 // Usage returns the long-form info form this cmdlet.
 func (*DestroyGroupCmd) Usage() string {
-	return `destroy-group --group <name>
-Delete the named group.
+	return `destroy-group --group <name> [--force]
+Delete the named group.  The group is not deleted while entities are
+members of it or other groups refer to it, unless --force is given, in
+which case all references to the group are removed as well.
 `
 }
 
 // SetFlags is the interface function which sets flags specific to this cmdlet.
 func (p *DestroyGroupCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.groupName, "group", "", "Name of the group to destroy.")
+	f.BoolVar(&p.force, "force", false, "Remove all references to the group as well.")
 }
 
 // Execute is the interface function which runs this cmdlet.
@@ -47,7 +51,11 @@ func (p *DestroyGroupCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...inter
 		return subcommands.ExitFailure
 	}
 
-	result, err := c.DeleteGroup(p.groupName, t)
+	deleteGroup := c.DeleteGroup
+	if p.force {
+		deleteGroup = c.ForceDeleteGroup
+	}
+	result, err := deleteGroup(p.groupName, t)
 	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
@@ -9,7 +9,9 @@ import (
 )
 
 // DestroyTokenCmd clears the local token.
-type DestroyTokenCmd struct{}
+type DestroyTokenCmd struct {
+	all bool
+}
 
 // Name to return for this cmdlet.
 func (*DestroyTokenCmd) Name() string { return "destroy-token" }
@@ -19,18 +21,21 @@ func (*DestroyTokenCmd) Synopsis() string { return "Destroy an existing local to
 
 // Usage for the cmdlet.
 func (*DestroyTokenCmd) Usage() string {
-	return `destroy-token
+	return `destroy-token [--all]
   Attempt to destroy the local authority token.  This command will
-  make a best effort attempt to remove the local token.
+  make a best effort attempt to revoke the token on the server and
+  remove the local token.  With --all every token that has been
+  issued to the entity is revoked.
 `
 }
 
-// SetFlags is required by the interface but DestroyTokenCmd has no
-// flags to set.
-func (*DestroyTokenCmd) SetFlags(f *flag.FlagSet) {}
+// SetFlags sets the cmdlet specific flags.
+func (p *DestroyTokenCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.all, "all", false, "Revoke all tokens issued to the entity")
+}
 
 // Execute is the interface method that runs the actions of the cmdlet.
-func (*DestroyTokenCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (p *DestroyTokenCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	// Grab a client
 	c, err := getClient()
 	if err != nil {
@@ -38,6 +43,20 @@ func (*DestroyTokenCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interfa
 		return subcommands.ExitFailure
 	}
 
+	if p.all {
+		t, err := getToken(c, getEntity())
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		result, err := c.RevokeAllTokens(t, getEntity())
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		fmt.Println(result.GetMsg())
+	}
+
 	// Destroy the token
 	if err := c.DestroyToken(getEntity()); err != nil {
 		fmt.Printf("Error during token destruction: %s\n", err)
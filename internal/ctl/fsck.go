@@ -0,0 +1,74 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+)
+
+// FsckCmd checks the server's data for inconsistencies.
+type FsckCmd struct {
+	repair bool
+}
+
+// Name of this cmdlet is 'fsck'
+func (*FsckCmd) Name() string { return "fsck" }
+
+// Synopsis returns short-form usage information.
+func (*FsckCmd) Synopsis() string { return "Check the server's data for inconsistencies" }
+
+// Usage returns long-form usage information.
+func (*FsckCmd) Usage() string {
+	return `fsck [--repair]
+
+Check every entity and group for references to groups that don't
+exist, duplicate numbers, missing secrets, and expansion cycles.  With
+--repair, references to groups that don't exist are removed.  Requires
+GLOBAL_ROOT.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *FsckCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.repair, "repair", false, "Repair the problems that can be repaired safely")
+}
+
+// Execute runs the cmdlet.
+func (p *FsckCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	problems, err := c.Fsck(t, p.repair)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	status := subcommands.ExitSuccess
+	for _, pr := range problems {
+		state := ""
+		if pr.GetRepaired() {
+			state = " (repaired)"
+		} else {
+			status = subcommands.ExitFailure
+		}
+		fmt.Printf("%s %s: %s%s\n", pr.GetCheck(), pr.GetRecord(), pr.GetDetail(), state)
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+	}
+	return status
+}
@@ -3,6 +3,7 @@ package ctl
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	pb "github.com/NetAuth/Protocol"
 )
@@ -24,6 +25,8 @@ func printEntity(entity *pb.Entity, fields string) {
 			"shell",
 			"graphicalShell",
 			"badgeNumber",
+			"secretExpiry",
+			"revision",
 		}
 	}
 
@@ -65,6 +68,18 @@ func printEntity(entity *pb.Entity, fields string) {
 			if entity.Meta != nil && entity.GetMeta().GetBadgeNumber() != "" {
 				fmt.Printf("badgeNumber: %s\n", entity.GetMeta().GetBadgeNumber())
 			}
+		case "secretexpiry":
+			if entity.GetSecretExpires() == 0 {
+				continue
+			}
+			left := time.Until(time.Unix(entity.GetSecretExpires(), 0)).Round(time.Minute)
+			if left > 0 {
+				fmt.Printf("Secret expires in: %s\n", left)
+			} else {
+				fmt.Println("Secret has expired")
+			}
+		case "revision":
+			fmt.Printf("Revision: %d\n", entity.GetRevision())
 		}
 	}
 }
@@ -81,6 +96,7 @@ func printGroup(group *pb.Group, fields string) {
 			"number",
 			"managedBy",
 			"expansions",
+			"revision",
 		}
 	}
 
@@ -101,6 +117,8 @@ func printGroup(group *pb.Group, fields string) {
 			for _, exp := range group.GetExpansions() {
 				fmt.Printf("Expansion: %s\n", exp)
 			}
+		case "revision":
+			fmt.Printf("Revision: %d\n", group.GetRevision())
 		}
 	}
 }
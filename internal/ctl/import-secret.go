@@ -0,0 +1,127 @@
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/subcommands"
+
+	"github.com/NetAuth/NetAuth/pkg/client"
+)
+
+// ImportSecretCmd imports secrets that were secured outside of
+// NetAuth, either one at a time or from a shadow file.
+type ImportSecretCmd struct {
+	entityID string
+	hash     string
+	shadow   string
+}
+
+// Name of this cmdlet is 'import-secret'
+func (*ImportSecretCmd) Name() string { return "import-secret" }
+
+// Synopsis returns short-form usage information.
+func (*ImportSecretCmd) Synopsis() string { return "Import secured secrets from another system" }
+
+// Usage returns long-form usage information.
+func (*ImportSecretCmd) Usage() string {
+	return `import-secret --entity <ID> --hash <hash>
+import-secret --shadow <file>
+
+Import a secret that was secured by another system, such as a crypt(3)
+hash from /etc/shadow.  The hash is stored as is and will be secured
+again by the server the first time the entity authenticates.  With
+--shadow every entry in a shadow formatted file is imported, entries
+without a usable hash are skipped.  Requires the CHANGE_ENTITY_SECRET
+capability.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *ImportSecretCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.entityID, "entity", "", "ID of the entity to import a secret for")
+	f.StringVar(&p.hash, "hash", "", "Secured secret to import")
+	f.StringVar(&p.shadow, "shadow", "", "Shadow file to import secrets from")
+}
+
+// Execute runs the cmdlet.
+func (p *ImportSecretCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.shadow == "" && (p.entityID == "" || p.hash == "") {
+		fmt.Println("Either --shadow or both --entity and --hash must be given")
+		return subcommands.ExitFailure
+	}
+
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	if p.shadow == "" {
+		result, err := c.ImportSecret(t, p.entityID, p.hash)
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		fmt.Println(result.GetMsg())
+		return subcommands.ExitSuccess
+	}
+
+	if err := importShadow(c, t, p.shadow); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// importShadow imports each usable entry in a shadow file.  Failures
+// for individual entries are reported but don't stop the import.
+func importShadow(c *client.NetAuthClient, t, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	imported, skipped, failed := 0, 0, 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		id, hash := fields[0], fields[1]
+
+		// Empty, locked and disabled entries have nothing
+		// that can be imported.
+		if !strings.HasPrefix(hash, "$") {
+			skipped++
+			continue
+		}
+
+		if _, err := c.ImportSecret(t, id, hash); err != nil {
+			fmt.Printf("%s: %s\n", id, err)
+			failed++
+			continue
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d secrets, skipped %d, %d failed\n", imported, skipped, failed)
+	return nil
+}
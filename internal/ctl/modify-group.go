@@ -15,6 +15,7 @@ type ModifyGroupCmd struct {
 	groupName   string
 	displayName string
 	managedby   string
+	revision    uint64
 }
 
 // Name of this cmdlet is 'modify-group'
@@ -25,8 +26,10 @@ func (*ModifyGroupCmd) Synopsis() string { return "Modify mutable fields on a gr
 
 // Usage returns the long-form usage information.
 func (*ModifyGroupCmd) Usage() string {
-	return `modify-group --group <name> [fields-to-be-modified]
+	return `modify-group --group <name> [--revision <revision>] [fields-to-be-modified]
 Modify a group by updating the named fields to the provided values.
+If a revision is given the group is only modified if it has not
+changed since that revision.
 `
 }
 
@@ -35,6 +38,7 @@ func (p *ModifyGroupCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.groupName, "group", "", "Name of the group to modify")
 	f.StringVar(&p.displayName, "display_name", "NO_CHANGE", "Group displayName")
 	f.StringVar(&p.managedby, "managed_by", "NO_CHANGE", "Group that manages this group")
+	f.Uint64Var(&p.revision, "revision", 0, "Revision the group is expected to be at")
 }
 
 // Execute runs the cmdlet.
@@ -53,7 +57,7 @@ func (p *ModifyGroupCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interf
 		return subcommands.ExitFailure
 	}
 
-	group := &pb.Group{Name: &p.groupName}
+	group := &pb.Group{Name: &p.groupName, Revision: &p.revision}
 
 	// This if block is kind of a hack, it is needed to ensure
 	// that fields that weren't set to be modified in the command
@@ -21,6 +21,7 @@ type ModifyMetaCmd struct {
 	shell          string
 	graphicalShell string
 	badgeNumber    string
+	revision       uint64
 }
 
 // Name of this cmdlet is 'modify-meta'
@@ -31,8 +32,10 @@ func (*ModifyMetaCmd) Synopsis() string { return "Modify meta-data on an entity"
 
 // Usage returns long-form usage information.
 func (*ModifyMetaCmd) Usage() string {
-	return `modify-meta --entity <ID> [fields-to-be-modified]
+	return `modify-meta --entity <ID> [--revision <revision>] [fields-to-be-modified]
 Modify an entity by updating the named fields to the provided values.
+If a revision is given the entity is only modified if it has not
+changed since that revision.
 `
 }
 
@@ -47,6 +50,7 @@ func (p *ModifyMetaCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.shell, "shell", "NO_CHANGE", "User command interpreter to be used by the entity")
 	f.StringVar(&p.graphicalShell, "graphicalShell", "NO_CHANGE", "Graphical shell to be used by the entity")
 	f.StringVar(&p.badgeNumber, "badgeNumber", "NO_CHANGE", "Badge number for the entity")
+	f.Uint64Var(&p.revision, "revision", 0, "Revision the entity is expected to be at")
 }
 
 // Execute runs the cmdlet.
@@ -99,7 +103,7 @@ func (p *ModifyMetaCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interfa
 		meta.BadgeNumber = &p.badgeNumber
 	}
 
-	result, err := c.ModifyEntityMeta(p.entityID, t, meta)
+	result, err := c.ModifyEntityMetaAt(p.entityID, t, p.revision, meta)
 	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
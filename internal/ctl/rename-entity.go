@@ -0,0 +1,60 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+)
+
+// RenameEntityCmd changes the ID of an entity.
+type RenameEntityCmd struct {
+	entityID string
+	newID    string
+}
+
+// Name of this cmdlet is 'rename-entity'
+func (*RenameEntityCmd) Name() string { return "rename-entity" }
+
+// Synopsis returns the short-form usage information.
+func (*RenameEntityCmd) Synopsis() string { return "Change the ID of an entity" }
+
+// Usage returns the long-form usage information.
+func (*RenameEntityCmd) Usage() string {
+	return `rename-entity --entity <ID> --new_id <ID>
+Change the ID of the specified entity.  The number and secret of the
+entity are kept, but tokens issued to the old ID stop working.`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *RenameEntityCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.entityID, "entity", "", "ID of the entity to rename")
+	f.StringVar(&p.newID, "new_id", "", "New ID for the entity")
+}
+
+// Execute runs the cmdlet
+func (p *RenameEntityCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	result, err := c.RenameEntity(p.entityID, p.newID, t)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Println(result.GetMsg())
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,62 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+)
+
+// RenameGroupCmd changes the name of a group.
+type RenameGroupCmd struct {
+	groupName string
+	newName   string
+}
+
+// Name returns the name of this cmdlet.
+func (*RenameGroupCmd) Name() string { return "rename-group" }
+
+// Synopsis returns the short-form info for this cmdlet.
+func (*RenameGroupCmd) Synopsis() string { return "Change the name of a group." }
+
+// Usage returns the long-form info form this cmdlet.
+func (*RenameGroupCmd) Usage() string {
+	return `rename-group --group <name> --new_name <name>
+Change the name of the named group.  The number of the group is kept,
+and memberships, expansions, and managing groups that refer to it are
+changed to the new name as well.
+`
+}
+
+// SetFlags is the interface function which sets flags specific to this cmdlet.
+func (p *RenameGroupCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.groupName, "group", "", "Name of the group to rename.")
+	f.StringVar(&p.newName, "new_name", "", "New name for the group.")
+}
+
+// Execute is the interface function which runs this cmdlet.
+func (p *RenameGroupCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	result, err := c.RenameGroup(p.groupName, p.newName, t)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Println(result.GetMsg())
+	return subcommands.ExitSuccess
+}
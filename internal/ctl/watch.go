@@ -0,0 +1,66 @@
+package ctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+)
+
+// WatchCmd prints changes to entities and groups as they happen.
+type WatchCmd struct {
+	revision uint64
+}
+
+// Name of this cmdlet is 'watch'
+func (*WatchCmd) Name() string { return "watch" }
+
+// Synopsis returns short-form usage information.
+func (*WatchCmd) Synopsis() string { return "Watch for changes on the server" }
+
+// Usage returns long-form usage information.
+func (*WatchCmd) Usage() string {
+	return `watch [--revision <revision>]
+
+Print changes to entities and groups as they are made.  If a revision
+is given the changes made since then are printed first.
+`
+}
+
+// SetFlags sets the cmdlet specific flags.
+func (p *WatchCmd) SetFlags(f *flag.FlagSet) {
+	f.Uint64Var(&p.revision, "revision", 0, "Revision to resume watching from")
+}
+
+// Execute runs the cmdlet.
+func (p *WatchCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	// Grab a client
+	c, err := getClient()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	// Get the authorization token
+	t, err := getToken(c, getEntity())
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	events, errc, err := c.WatchChanges(ctx, t, p.revision)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	for ev := range events {
+		fmt.Printf("%d %s %s\n", ev.GetRevision(), ev.GetType(), ev.GetName())
+	}
+	if err := <-errc; err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,6 @@
+package all
+
+import (
+	// Register the database in init()
+	_ "github.com/NetAuth/NetAuth/internal/db/sqldb"
+)
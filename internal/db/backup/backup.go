@@ -0,0 +1,322 @@
+// Package backup reads and writes archives of everything in a
+// NetAuth database.  Archives contain every entity and group in full,
+// secrets included, and so should be encrypted to a passphrase
+// whenever they will be stored anywhere that isn't as well protected
+// as the server itself.
+//
+// An archive starts with a short header:
+//
+//	magic    8 bytes  "NABACKUP"
+//	version  1 byte
+//	flags    1 byte   bit 0 set if encrypted
+//
+// which is followed by the body.  If the archive is encrypted, a
+// random salt and nonce come next and the body is sealed with
+// AES-256-GCM, using a key derived from the passphrase with Argon2id.
+// The body holds the time the archive was taken, then a record for
+// each entity and group made of a kind byte, a length, and the
+// marshaled proto.  A record of kind 0 ends the body, and is followed
+// by the SHA-256 of everything before it in the body.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/argon2"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+const (
+	magic = "NABACKUP"
+
+	// version is the version of the format written by this
+	// package.  Archives with a higher version are refused.
+	version = 1
+
+	flagEncrypted = 1 << 0
+
+	saltLen = 16
+
+	recordEnd    = 0
+	recordEntity = 1
+	recordGroup  = 2
+)
+
+// An Archive is a copy of everything in a database at one point in
+// time.
+type Archive struct {
+	Created  time.Time
+	Entities []*pb.Entity
+	Groups   []*pb.Group
+}
+
+// Take reads every entity and group from d.  The caller must make sure
+// that nothing is written to d until Take returns if the archive is
+// to be consistent.
+func Take(d db.DB) (*Archive, error) {
+	a := &Archive{Created: time.Now()}
+
+	IDs, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ID := range IDs {
+		e, err := d.LoadEntity(ID)
+		if err != nil {
+			return nil, err
+		}
+		a.Entities = append(a.Entities, e)
+	}
+
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		g, err := d.LoadGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		a.Groups = append(a.Groups, g)
+	}
+	return a, nil
+}
+
+// Restore saves everything in the archive to d, which must be empty.
+// If d supports transactions the archive is restored in one.  Tokens
+// issued before the restore are revoked for every restored entity,
+// since they may have been issued to an entity that was removed after
+// the archive was taken.
+func (a *Archive) Restore(d db.DB) error {
+	IDs, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return err
+	}
+	if len(IDs) != 0 || len(names) != 0 {
+		return ErrNotEmpty
+	}
+
+	cutoff := proto.Int64(time.Now().UnixNano())
+	restore := func(d db.DB) error {
+		for _, g := range a.Groups {
+			if err := d.SaveGroup(g); err != nil {
+				return err
+			}
+		}
+		for _, e := range a.Entities {
+			e = proto.Clone(e).(*pb.Entity)
+			e.TokenRevocation = &pb.TokenRevocation{IssuedBefore: cutoff}
+			if err := d.SaveEntity(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if t, ok := d.(db.Transactor); ok {
+		return t.Update(restore)
+	}
+	return restore(d)
+}
+
+// Write writes the archive to w.  If passphrase is not empty the
+// archive is encrypted to it.
+func (a *Archive) Write(w io.Writer, passphrase string) error {
+	body, err := a.marshalBody()
+	if err != nil {
+		return err
+	}
+
+	header := []byte(magic)
+	header = append(header, version, 0)
+	if passphrase == "" {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		_, err := w.Write(body)
+		return err
+	}
+
+	header[len(header)-1] |= flagEncrypted
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var out []byte
+	out = append(out, header...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated along with the body so that
+	// it can't be altered either.
+	out = aead.Seal(out, nonce, body, header)
+	_, err = w.Write(out)
+	return err
+}
+
+// Read reads an archive written by Write.  The passphrase is only
+// used if the archive is encrypted.
+func Read(r io.Reader, passphrase string) (*Archive, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(magic) + 2
+	if len(in) < headerLen || string(in[:len(magic)]) != magic {
+		return nil, ErrBadArchive
+	}
+	header := in[:headerLen]
+	if header[len(magic)] > version {
+		return nil, ErrUnsupportedVersion
+	}
+	flags := header[len(magic)+1]
+	body := in[headerLen:]
+
+	if flags&flagEncrypted != 0 {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		if len(body) < saltLen {
+			return nil, ErrBadArchive
+		}
+		salt := body[:saltLen]
+		aead, err := newAEAD(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) < saltLen+aead.NonceSize() {
+			return nil, ErrBadArchive
+		}
+		nonce := body[saltLen : saltLen+aead.NonceSize()]
+		body, err = aead.Open(nil, nonce, body[saltLen+aead.NonceSize():], header)
+		if err != nil {
+			return nil, ErrBadPassphrase
+		}
+	}
+
+	return unmarshalBody(body)
+}
+
+// newAEAD derives a key from the passphrase and returns the cipher
+// that archives are sealed with.
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (a *Archive) marshalBody() ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, a.Created.Unix())
+
+	writeRecord := func(kind byte, m proto.Message) error {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			return err
+		}
+		var l [binary.MaxVarintLen64]byte
+		buf.WriteByte(kind)
+		buf.Write(l[:binary.PutUvarint(l[:], uint64(len(data)))])
+		buf.Write(data)
+		return nil
+	}
+	for _, g := range a.Groups {
+		if err := writeRecord(recordGroup, g); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range a.Entities {
+		if err := writeRecord(recordEntity, e); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte(recordEnd)
+
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+func unmarshalBody(body []byte) (*Archive, error) {
+	if len(body) < sha256.Size {
+		return nil, ErrBadArchive
+	}
+	content := body[:len(body)-sha256.Size]
+	sum := sha256.Sum256(content)
+	if !bytes.Equal(sum[:], body[len(content):]) {
+		return nil, ErrBadArchive
+	}
+
+	r := bytes.NewReader(content)
+	var created int64
+	if err := binary.Read(r, binary.BigEndian, &created); err != nil {
+		return nil, ErrBadArchive
+	}
+	a := &Archive{Created: time.Unix(created, 0)}
+
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrBadArchive
+		}
+		if kind == recordEnd {
+			break
+		}
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(len(content)) {
+			return nil, ErrBadArchive
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, ErrBadArchive
+		}
+
+		switch kind {
+		case recordEntity:
+			e := &pb.Entity{}
+			if err := proto.Unmarshal(data, e); err != nil {
+				return nil, ErrBadArchive
+			}
+			a.Entities = append(a.Entities, e)
+		case recordGroup:
+			g := &pb.Group{}
+			if err := proto.Unmarshal(data, g); err != nil {
+				return nil, ErrBadArchive
+			}
+			a.Groups = append(a.Groups, g)
+		default:
+			return nil, ErrBadArchive
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrBadArchive
+	}
+	return a, nil
+}
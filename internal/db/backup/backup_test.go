@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/memdb"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func newTestDB(t *testing.T) db.DB {
+	m, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func newTestArchive(t *testing.T) *Archive {
+	d := newTestDB(t)
+	d.SaveEntity(&pb.Entity{
+		ID:     proto.String("foo"),
+		Number: proto.Int32(1),
+		Secret: proto.String("$2a$secret"),
+		Meta:   &pb.EntityMeta{Groups: []string{"bar"}},
+	})
+	d.SaveEntity(&pb.Entity{ID: proto.String("baz"), Number: proto.Int32(2)})
+	d.SaveGroup(&pb.Group{Name: proto.String("bar"), Number: proto.Int32(1)})
+
+	a, err := Take(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func checkRestored(t *testing.T, a *Archive) {
+	d := newTestDB(t)
+	if err := a.Restore(d); err != nil {
+		t.Fatal(err)
+	}
+	e, err := d.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetSecret() != "$2a$secret" || e.GetMeta().GetGroups()[0] != "bar" {
+		t.Errorf("Wrong entity restored: %v", e)
+	}
+	if e.GetTokenRevocation().GetIssuedBefore() == 0 {
+		t.Error("Tokens were not revoked for the restored entity")
+	}
+	if _, err := d.LoadEntity("baz"); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.LoadGroup("bar"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		var buf bytes.Buffer
+		if err := newTestArchive(t).Write(&buf, passphrase); err != nil {
+			t.Fatal(err)
+		}
+		a, err := Read(&buf, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a.Entities) != 2 || len(a.Groups) != 1 {
+			t.Errorf("Read %d entities and %d groups", len(a.Entities), len(a.Groups))
+		}
+		checkRestored(t, a)
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestArchive(t).Write(&buf, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("$2a$secret")) {
+		t.Error("Secret is readable in encrypted archive")
+	}
+
+	archive := buf.Bytes()
+	if _, err := Read(bytes.NewReader(archive), ""); err != ErrPassphraseRequired {
+		t.Error(err)
+	}
+	if _, err := Read(bytes.NewReader(archive), "battery staple"); err != ErrBadPassphrase {
+		t.Error(err)
+	}
+
+	// Clearing the encrypted flag must not get past the checks.
+	altered := append([]byte{}, archive...)
+	altered[len(magic)+1] = 0
+	if _, err := Read(bytes.NewReader(altered), ""); err != ErrBadArchive {
+		t.Error(err)
+	}
+}
+
+func TestDamaged(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestArchive(t).Write(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	flipped := append([]byte{}, archive...)
+	flipped[len(flipped)/2] ^= 0xff
+
+	newer := append([]byte{}, archive...)
+	newer[len(magic)] = version + 1
+
+	cases := []struct {
+		name    string
+		archive []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrBadArchive},
+		{"not-an-archive", []byte("hello world"), ErrBadArchive},
+		{"truncated", archive[:len(archive)-10], ErrBadArchive},
+		{"flipped", flipped, ErrBadArchive},
+		{"newer", newer, ErrUnsupportedVersion},
+	}
+	for _, c := range cases {
+		if _, err := Read(bytes.NewReader(c.archive), ""); err != c.wantErr {
+			t.Errorf("%s: got %v want %v", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestRestoreNotEmpty(t *testing.T) {
+	d := newTestDB(t)
+	d.SaveGroup(&pb.Group{Name: proto.String("existing")})
+	if err := newTestArchive(t).Restore(d); err != ErrNotEmpty {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,29 @@
+package backup
+
+import (
+	"errors"
+)
+
+var (
+	// ErrBadArchive is returned when an archive is truncated,
+	// corrupted, or isn't an archive at all.
+	ErrBadArchive = errors.New("the archive is damaged or is not a NetAuth backup")
+
+	// ErrUnsupportedVersion is returned for archives written in
+	// a format newer than this version of NetAuth understands.
+	ErrUnsupportedVersion = errors.New("the archive was written by a newer version of NetAuth")
+
+	// ErrPassphraseRequired is returned when an encrypted archive
+	// is read without a passphrase.
+	ErrPassphraseRequired = errors.New("the archive is encrypted and needs a passphrase")
+
+	// ErrBadPassphrase is returned when an encrypted archive
+	// cannot be decrypted with the passphrase given.  This is
+	// also what is returned if an encrypted archive has been
+	// tampered with, since the two cannot be told apart.
+	ErrBadPassphrase = errors.New("the passphrase is wrong or the archive has been altered")
+
+	// ErrNotEmpty is returned when an archive is restored to a
+	// database that already holds entities or groups.
+	ErrNotEmpty = errors.New("the database being restored to is not empty")
+)
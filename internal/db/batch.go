@@ -0,0 +1,223 @@
+package db
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// OpKind identifies the type of change an Op makes.
+type OpKind int
+
+// These are the changes that may be recorded in a Batch.
+const (
+	OpSaveEntity OpKind = iota
+	OpDeleteEntity
+	OpSaveGroup
+	OpDeleteGroup
+)
+
+// An Op is a single change recorded by a Batch.  Name is the entity
+// ID or group name, and exactly one of Entity or Group is set for a
+// save.
+type Op struct {
+	Kind   OpKind
+	Name   string
+	Entity *pb.Entity
+	Group  *pb.Group
+}
+
+// A Batch is a DB that records changes instead of making them.  Reads
+// see the recorded changes layered over the base database.  It is
+// the building block for implementations of Transactor, which only
+// need to apply the Ops at the end.
+type Batch struct {
+	base DB
+
+	// A nil value marks a record that has been deleted.
+	entities map[string]*pb.Entity
+	groups   map[string]*pb.Group
+
+	ops []Op
+}
+
+// NewBatch returns a Batch that reads through to base.
+func NewBatch(base DB) *Batch {
+	return &Batch{
+		base:     base,
+		entities: make(map[string]*pb.Entity),
+		groups:   make(map[string]*pb.Group),
+	}
+}
+
+// Ops returns the changes recorded so far, in the order they were
+// made.
+func (b *Batch) Ops() []Op {
+	return b.ops
+}
+
+// DiscoverEntityIDs returns the IDs in the base database with any
+// recorded changes applied.
+func (b *Batch) DiscoverEntityIDs() ([]string, error) {
+	IDs, err := b.base.DiscoverEntityIDs()
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool, len(b.entities))
+	for k, v := range b.entities {
+		changed[k] = v != nil
+	}
+	return mergeNames(IDs, changed), nil
+}
+
+// LoadEntity returns a copy of the entity, so that changes to it are
+// not seen until it is saved.
+func (b *Batch) LoadEntity(ID string) (*pb.Entity, error) {
+	if e, ok := b.entities[ID]; ok {
+		if e == nil {
+			return nil, ErrUnknownEntity
+		}
+		return proto.Clone(e).(*pb.Entity), nil
+	}
+	e, err := b.base.LoadEntity(ID)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(e).(*pb.Entity), nil
+}
+
+// SaveEntity records that the entity is to be saved.
+func (b *Batch) SaveEntity(e *pb.Entity) error {
+	if e == nil {
+		return ErrInternalError
+	}
+	e = proto.Clone(e).(*pb.Entity)
+	b.entities[e.GetID()] = e
+	b.ops = append(b.ops, Op{Kind: OpSaveEntity, Name: e.GetID(), Entity: e})
+	return nil
+}
+
+// DeleteEntity records that the entity is to be deleted.
+func (b *Batch) DeleteEntity(ID string) error {
+	if _, err := b.LoadEntity(ID); err != nil {
+		return err
+	}
+	b.entities[ID] = nil
+	b.ops = append(b.ops, Op{Kind: OpDeleteEntity, Name: ID})
+	return nil
+}
+
+// DiscoverGroupNames returns the names in the base database with any
+// recorded changes applied.
+func (b *Batch) DiscoverGroupNames() ([]string, error) {
+	names, err := b.base.DiscoverGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool, len(b.groups))
+	for k, v := range b.groups {
+		changed[k] = v != nil
+	}
+	return mergeNames(names, changed), nil
+}
+
+// LoadGroup returns a copy of the group, so that changes to it are
+// not seen until it is saved.
+func (b *Batch) LoadGroup(name string) (*pb.Group, error) {
+	if g, ok := b.groups[name]; ok {
+		if g == nil {
+			return nil, ErrUnknownGroup
+		}
+		return proto.Clone(g).(*pb.Group), nil
+	}
+	g, err := b.base.LoadGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(g).(*pb.Group), nil
+}
+
+// SaveGroup records that the group is to be saved.
+func (b *Batch) SaveGroup(g *pb.Group) error {
+	if g == nil {
+		return ErrInternalError
+	}
+	g = proto.Clone(g).(*pb.Group)
+	b.groups[g.GetName()] = g
+	b.ops = append(b.ops, Op{Kind: OpSaveGroup, Name: g.GetName(), Group: g})
+	return nil
+}
+
+// DeleteGroup records that the group is to be deleted.
+func (b *Batch) DeleteGroup(name string) error {
+	if _, err := b.LoadGroup(name); err != nil {
+		return err
+	}
+	b.groups[name] = nil
+	b.ops = append(b.ops, Op{Kind: OpDeleteGroup, Name: name})
+	return nil
+}
+
+// mergeNames applies changes to a list of names.  A name that maps to
+// true exists, one that maps to false has been deleted.
+func mergeNames(names []string, changed map[string]bool) []string {
+	out := make([]string, 0, len(names)+len(changed))
+	for _, n := range names {
+		if _, ok := changed[n]; !ok {
+			out = append(out, n)
+		}
+	}
+	for n, exists := range changed {
+		if exists {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Apply makes the changes in ops to d, in order.  Deleting a record
+// that doesn't exist is not an error, so a list that was partially
+// applied may safely be applied again.
+func Apply(d DB, ops []Op) error {
+	for _, op := range ops {
+		var err error
+		switch op.Kind {
+		case OpSaveEntity:
+			err = d.SaveEntity(op.Entity)
+		case OpDeleteEntity:
+			if err = d.DeleteEntity(op.Name); err == ErrUnknownEntity {
+				err = nil
+			}
+		case OpSaveGroup:
+			err = d.SaveGroup(op.Group)
+		case OpDeleteGroup:
+			if err = d.DeleteGroup(op.Name); err == ErrUnknownGroup {
+				err = nil
+			}
+		default:
+			err = ErrInternalError
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateAndRecord runs fn as a transaction on t and returns the
+// changes that were committed.  It allows a DB that wraps another to
+// implement Transactor and still learn what has changed.
+func UpdateAndRecord(t Transactor, fn func(DB) error) ([]Op, error) {
+	var b *Batch
+	err := t.Update(func(tx DB) error {
+		b = NewBatch(tx)
+		if err := fn(b); err != nil {
+			return err
+		}
+		return Apply(tx, b.Ops())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Ops(), nil
+}
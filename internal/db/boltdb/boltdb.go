@@ -0,0 +1,317 @@
+package boltdb
+
+// BoltDB keeps all entities and groups in a single file using the
+// bbolt embedded key/value store.  Every write is a transaction that
+// is synced to disk before it returns, and any number of reads may
+// happen at once.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/health"
+	"github.com/golang/protobuf/proto"
+
+	bolt "go.etcd.io/bbolt"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	entityBucket = []byte("entities")
+	groupBucket  = []byte("groups")
+)
+
+// The BoltDB type binds all methods that are a part of the boltdb
+// package.
+type BoltDB struct {
+	path string
+	db   *bolt.DB
+}
+
+var (
+	dbPath      = flag.String("boltdb_path", "./netauth.db", "Path to the BoltDB database file")
+	openTimeout = flag.Duration("boltdb_timeout", time.Second*5, "How long to wait for the BoltDB file lock")
+)
+
+func init() {
+	db.Register("BoltDB", New)
+}
+
+// New returns a new BoltDB instance that is initialized and ready for
+// use.  The database file is created if it does not exist.  Only one
+// process may have the file open at a time, so this will fail if the
+// file lock can't be taken before the timeout.
+func New() (db.DB, error) {
+	x := new(BoltDB)
+	x.path = *dbPath
+
+	var err error
+	x.db, err = bolt.Open(x.path, 0600, &bolt.Options{Timeout: *openTimeout})
+	if err != nil {
+		log.Printf("Could not open database '%s' (%s)", x.path, err)
+		return nil, db.ErrInternalError
+	}
+
+	if err := x.ensureBuckets(); err != nil {
+		x.db.Close()
+		return nil, err
+	}
+
+	health.RegisterCheck("BoltDB", x.healthCheck)
+
+	return x, nil
+}
+
+// Close closes the underlying database file, releasing the lock on
+// it.
+func (bdb *BoltDB) Close() error {
+	return bdb.db.Close()
+}
+
+// DiscoverEntityIDs returns a list of entity IDs that are stored in
+// the database.
+func (bdb *BoltDB) DiscoverEntityIDs() ([]string, error) {
+	return bdb.keys(entityBucket)
+}
+
+// LoadEntity loads a single entity from the database given the ID
+// associated with the entity.
+func (bdb *BoltDB) LoadEntity(ID string) (*pb.Entity, error) {
+	in, err := bdb.get(entityBucket, ID)
+	if err != nil {
+		return nil, err
+	}
+	if in == nil {
+		return nil, db.ErrUnknownEntity
+	}
+
+	e := &pb.Entity{}
+	if err := proto.Unmarshal(in, e); err != nil {
+		log.Printf("Failed to parse Entity from database: (%s):", err)
+		return nil, db.ErrInternalError
+	}
+	return e, nil
+}
+
+// SaveEntity writes an entity to the database.  The write is durable
+// when this function returns without error.
+func (bdb *BoltDB) SaveEntity(e *pb.Entity) error {
+	out, err := proto.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to marshal entity '%s' (%s)", e.GetID(), err)
+		return db.ErrInternalError
+	}
+	return bdb.put(entityBucket, e.GetID(), out)
+}
+
+// DeleteEntity removes an entity from the database.
+func (bdb *BoltDB) DeleteEntity(ID string) error {
+	return bdb.delete(entityBucket, ID, db.ErrUnknownEntity)
+}
+
+// DiscoverGroupNames returns a list of group names that are stored in
+// the database.
+func (bdb *BoltDB) DiscoverGroupNames() ([]string, error) {
+	return bdb.keys(groupBucket)
+}
+
+// LoadGroup loads a single group from the database given its name.
+func (bdb *BoltDB) LoadGroup(name string) (*pb.Group, error) {
+	in, err := bdb.get(groupBucket, name)
+	if err != nil {
+		return nil, err
+	}
+	if in == nil {
+		return nil, db.ErrUnknownGroup
+	}
+
+	g := &pb.Group{}
+	if err := proto.Unmarshal(in, g); err != nil {
+		log.Printf("Failed to parse Group from database: (%s):", err)
+		return nil, db.ErrInternalError
+	}
+	return g, nil
+}
+
+// SaveGroup writes a group to the database.  The write is durable
+// when this function returns without error.
+func (bdb *BoltDB) SaveGroup(g *pb.Group) error {
+	out, err := proto.Marshal(g)
+	if err != nil {
+		log.Printf("Failed to marshal group '%s' (%s)", g.GetName(), err)
+		return db.ErrInternalError
+	}
+	return bdb.put(groupBucket, g.GetName(), out)
+}
+
+// DeleteGroup removes a group from the database.
+func (bdb *BoltDB) DeleteGroup(name string) error {
+	return bdb.delete(groupBucket, name, db.ErrUnknownGroup)
+}
+
+// Update runs fn as a transaction.  The changes fn makes are written
+// in a single bolt transaction once it returns.
+func (bdb *BoltDB) Update(fn func(db.DB) error) error {
+	b := db.NewBatch(bdb)
+	if err := fn(b); err != nil {
+		return err
+	}
+
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range b.Ops() {
+			var err error
+			switch op.Kind {
+			case db.OpSaveEntity:
+				err = putProto(tx.Bucket(entityBucket), op.Name, op.Entity)
+			case db.OpDeleteEntity:
+				err = tx.Bucket(entityBucket).Delete([]byte(op.Name))
+			case db.OpSaveGroup:
+				err = putProto(tx.Bucket(groupBucket), op.Name, op.Group)
+			case db.OpDeleteGroup:
+				err = tx.Bucket(groupBucket).Delete([]byte(op.Name))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to commit transaction (%s)", err)
+		return db.ErrInternalError
+	}
+	return nil
+}
+
+func putProto(b *bolt.Bucket, key string, m proto.Message) error {
+	out, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), out)
+}
+
+// keys returns all the keys in a bucket.
+func (bdb *BoltDB) keys(bucket []byte) ([]string, error) {
+	keys := make([]string, 0)
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to list %s (%s)", bucket, err)
+		return nil, db.ErrInternalError
+	}
+	return keys, nil
+}
+
+// get returns a copy of the value stored under key, or nil if there
+// is no such key.  The copy is needed since values returned by bolt
+// are only valid during the transaction.
+func (bdb *BoltDB) get(bucket []byte, key string) ([]byte, error) {
+	var out []byte
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			out = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read '%s' from %s (%s)", key, bucket, err)
+		return nil, db.ErrInternalError
+	}
+	return out, nil
+}
+
+func (bdb *BoltDB) put(bucket []byte, key string, value []byte) error {
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+	if err != nil {
+		log.Printf("Failed to write '%s' to %s (%s)", key, bucket, err)
+		return db.ErrInternalError
+	}
+	return nil
+}
+
+// delete removes the key from the bucket, returning notFound if it
+// did not exist.
+func (bdb *BoltDB) delete(bucket []byte, key string, notFound error) error {
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b.Get([]byte(key)) == nil {
+			return notFound
+		}
+		return b.Delete([]byte(key))
+	})
+	if err == notFound {
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to delete '%s' from %s (%s)", key, bucket, err)
+		return db.ErrInternalError
+	}
+	return nil
+}
+
+// ensureBuckets is called during initialization to make sure the
+// buckets exist, so that no other function needs to check.
+func (bdb *BoltDB) ensureBuckets() error {
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{entityBucket, groupBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not create buckets (%s)", err)
+		return db.ErrInternalError
+	}
+	return nil
+}
+
+// healthCheck provides a sanity check that the database file is
+// still in place, has the right permissions, and can be read.
+func (bdb *BoltDB) healthCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{
+		OK:     true,
+		Name:   "BoltDB",
+		Status: "BoltDB is operating normally",
+	}
+
+	stat, err := os.Stat(bdb.path)
+	if err != nil {
+		status.OK = false
+		status.Status = fmt.Sprintf("Error while checking '%s': '%s'", bdb.path, err)
+		return status
+	}
+	if stat.Mode().Perm().String() != "-rw-------" {
+		status.OK = false
+		status.Status = fmt.Sprintf("Path '%s' has the wrong permissions '%v'", bdb.path, stat.Mode())
+		return status
+	}
+
+	err = bdb.db.View(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{entityBucket, groupBucket} {
+			if tx.Bucket(b) == nil {
+				return fmt.Errorf("bucket '%s' is missing", b)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		status.OK = false
+		status.Status = fmt.Sprintf("Database is unreadable: %s", err)
+		return status
+	}
+	return status
+}
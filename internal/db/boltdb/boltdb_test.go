@@ -0,0 +1,113 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func mkTmpTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("/tmp", "bdbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func cleanTmpTestDir(dir string, t *testing.T) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Log(err)
+	}
+}
+
+func newTestDB(t *testing.T, dir string) *BoltDB {
+	*dbPath = filepath.Join(dir, "netauth.db")
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x.(*BoltDB)
+}
+
+func TestContract(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+
+	var open []*BoltDB
+	defer func() {
+		for _, x := range open {
+			x.Close()
+		}
+	}()
+
+	dbtest.Run(t, func(t *testing.T) db.DB {
+		sub, err := ioutil.TempDir(dir, "contract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := newTestDB(t, sub)
+		open = append(open, x)
+		return x
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+
+	x := newTestDB(t, dir)
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SaveGroup(&pb.Group{Name: proto.String("bar"), Number: proto.Int32(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	x = newTestDB(t, dir)
+	defer x.Close()
+	e, err := x.LoadEntity("foo")
+	if err != nil || e.GetNumber() != 1 {
+		t.Errorf("Entity did not survive reopen: %v %v", e, err)
+	}
+	g, err := x.LoadGroup("bar")
+	if err != nil || g.GetNumber() != 2 {
+		t.Errorf("Group did not survive reopen: %v %v", g, err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+
+	x := newTestDB(t, dir)
+	defer x.Close()
+
+	if r := x.healthCheck(); !r.OK {
+		t.Errorf("Health check failed: %s", r.Status)
+	}
+
+	if err := os.Chmod(x.path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := x.healthCheck(); r.OK {
+		t.Error("Health check passed with bad permissions")
+	}
+
+	if err := os.Remove(x.path); err != nil {
+		t.Fatal(err)
+	}
+	if r := x.healthCheck(); r.OK {
+		t.Error("Health check passed with missing file")
+	}
+}
@@ -0,0 +1,202 @@
+// Package cache provides a db.DB that keeps recently used entities
+// and groups in memory in front of any other db.DB.  Records are
+// copied on the way in and on the way out, so nothing a caller does
+// with a record can change what is cached.
+package cache
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/health"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	entityLimit = flag.Int("db_cache_entities", 10000, "Maximum number of entities to cache")
+	groupLimit  = flag.Int("db_cache_groups", 1000, "Maximum number of groups to cache")
+)
+
+// The Cache type wraps another db.DB.  Loads are served from memory
+// when possible, and every change invalidates what it touches.
+type Cache struct {
+	db.DB
+
+	mu       sync.Mutex
+	entities *lru
+	groups   *lru
+
+	// generation is bumped by every change, a load that raced
+	// with a change doesn't get cached since it may have read the
+	// old record.
+	generation uint64
+}
+
+// New returns a Cache in front of d, with size limits taken from the
+// flags.
+func New(d db.DB) db.DB {
+	x := &Cache{
+		DB:       d,
+		entities: newLRU(*entityLimit),
+		groups:   newLRU(*groupLimit),
+	}
+
+	health.RegisterCheck("DBCache", x.healthCheck)
+
+	return x
+}
+
+// LoadEntity returns a copy of the entity from the cache, or loads it
+// from the database if it isn't cached.
+func (c *Cache) LoadEntity(ID string) (*pb.Entity, error) {
+	c.mu.Lock()
+	v, ok := c.entities.get(ID)
+	gen := c.generation
+	c.mu.Unlock()
+	if ok {
+		return proto.Clone(v).(*pb.Entity), nil
+	}
+
+	e, err := c.DB.LoadEntity(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if gen == c.generation {
+		c.entities.add(ID, proto.Clone(e))
+	}
+	c.mu.Unlock()
+	return e, nil
+}
+
+// SaveEntity saves the entity and drops it from the cache.  The cache
+// is filled again by the next load rather than here, since saves that
+// finish out of order would otherwise leave the older record cached.
+func (c *Cache) SaveEntity(e *pb.Entity) error {
+	err := c.DB.SaveEntity(e)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entities.remove(e.GetID())
+	return err
+}
+
+// DeleteEntity deletes the entity and drops it from the cache.
+func (c *Cache) DeleteEntity(ID string) error {
+	err := c.DB.DeleteEntity(ID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entities.remove(ID)
+	return err
+}
+
+// LoadGroup returns a copy of the group from the cache, or loads it
+// from the database if it isn't cached.
+func (c *Cache) LoadGroup(name string) (*pb.Group, error) {
+	c.mu.Lock()
+	v, ok := c.groups.get(name)
+	gen := c.generation
+	c.mu.Unlock()
+	if ok {
+		return proto.Clone(v).(*pb.Group), nil
+	}
+
+	g, err := c.DB.LoadGroup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if gen == c.generation {
+		c.groups.add(name, proto.Clone(g))
+	}
+	c.mu.Unlock()
+	return g, nil
+}
+
+// SaveGroup saves the group and drops it from the cache, for the same
+// reason as SaveEntity.
+func (c *Cache) SaveGroup(g *pb.Group) error {
+	err := c.DB.SaveGroup(g)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.groups.remove(g.GetName())
+	return err
+}
+
+// DeleteGroup deletes the group and drops it from the cache.
+func (c *Cache) DeleteGroup(name string) error {
+	err := c.DB.DeleteGroup(name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.groups.remove(name)
+	return err
+}
+
+// Update runs a transaction on the wrapped database if it supports
+// them.  Reads inside the transaction bypass the cache, and every
+// record the transaction changed is dropped from the cache once it
+// has been committed.
+func (c *Cache) Update(fn func(db.DB) error) error {
+	t, ok := c.DB.(db.Transactor)
+	if !ok {
+		return fn(c)
+	}
+
+	var fnErr error
+	ops, err := db.UpdateAndRecord(t, func(tx db.DB) error {
+		fnErr = fn(tx)
+		return fnErr
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if err != nil && fnErr == nil {
+		// The commit failed, and it isn't known how much of
+		// it made it to the database.
+		c.entities.purge()
+		c.groups.purge()
+		return err
+	}
+	for _, op := range ops {
+		switch op.Kind {
+		case db.OpSaveEntity, db.OpDeleteEntity:
+			c.entities.remove(op.Name)
+		case db.OpSaveGroup, db.OpDeleteGroup:
+			c.groups.remove(op.Name)
+		}
+	}
+	return err
+}
+
+// healthCheck reports how well the cache is working.  The cache
+// itself can't fail, so it is always OK.
+func (c *Cache) healthCheck() health.SubsystemStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return health.SubsystemStatus{
+		OK:   true,
+		Name: "DBCache",
+		Status: fmt.Sprintf("Entities: %s; Groups: %s",
+			stats(c.entities), stats(c.groups)),
+	}
+}
+
+func stats(l *lru) string {
+	return fmt.Sprintf("%d/%d cached, %d hits, %d misses, %d evictions",
+		l.len(), l.limit, l.hits, l.misses, l.evictions)
+}
@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
+	"github.com/NetAuth/NetAuth/internal/db/memdb"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// countingDB counts the loads that reach the database, and can be
+// told to fail commits or to run a function after each save.
+type countingDB struct {
+	db.DB
+	loads      int
+	failCommit bool
+	afterSave  func()
+}
+
+func (c *countingDB) SaveEntity(e *pb.Entity) error {
+	if err := c.DB.SaveEntity(e); err != nil {
+		return err
+	}
+	if c.afterSave != nil {
+		c.afterSave()
+	}
+	return nil
+}
+
+func (c *countingDB) LoadEntity(ID string) (*pb.Entity, error) {
+	c.loads++
+	return c.DB.LoadEntity(ID)
+}
+
+func (c *countingDB) LoadGroup(name string) (*pb.Group, error) {
+	c.loads++
+	return c.DB.LoadGroup(name)
+}
+
+func (c *countingDB) Update(fn func(db.DB) error) error {
+	return c.DB.(db.Transactor).Update(func(tx db.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if c.failCommit {
+			return db.ErrInternalError
+		}
+		return nil
+	})
+}
+
+func newTestCache(t *testing.T) (*Cache, *countingDB) {
+	m, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingDB{DB: m}
+	return New(backend).(*Cache), backend
+}
+
+func TestContract(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.DB {
+		c, _ := newTestCache(t)
+		return c
+	})
+}
+
+func TestHitsAndMisses(t *testing.T) {
+	c, backend := newTestCache(t)
+
+	if err := backend.DB.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.DB.SaveGroup(&pb.Group{Name: proto.String("bar")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.LoadEntity("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.LoadGroup("bar"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.loads != 2 {
+		t.Errorf("Database was loaded from %d times", backend.loads)
+	}
+	if c.entities.hits != 2 || c.entities.misses != 1 {
+		t.Errorf("Wrong entity stats: %s", stats(c.entities))
+	}
+	if c.groups.hits != 2 || c.groups.misses != 1 {
+		t.Errorf("Wrong group stats: %s", stats(c.groups))
+	}
+
+	// Unknown records are not cached.
+	for i := 0; i < 2; i++ {
+		if _, err := c.LoadEntity("unknown"); err != db.ErrUnknownEntity {
+			t.Error(err)
+		}
+	}
+	if c.entities.len() != 1 {
+		t.Errorf("Unknown entity was cached: %s", stats(c.entities))
+	}
+}
+
+func TestDefensiveCopies(t *testing.T) {
+	c, _ := newTestCache(t)
+
+	e := &pb.Entity{ID: proto.String("foo"), Number: proto.Int32(1)}
+	if err := c.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the saved entity doesn't change the cache.
+	e.Number = proto.Int32(2)
+	got, err := c.LoadEntity("foo")
+	if err != nil || got.GetNumber() != 1 {
+		t.Fatalf("Cache shares the saved entity: %v %v", got, err)
+	}
+
+	// Changing a loaded entity doesn't change the cache.
+	got.Number = proto.Int32(3)
+	got, err = c.LoadEntity("foo")
+	if err != nil || got.GetNumber() != 1 {
+		t.Fatalf("Cache shares the loaded entity: %v %v", got, err)
+	}
+
+	g := &pb.Group{Name: proto.String("bar"), Expansions: []string{"INCLUDE:baz"}}
+	if err := c.SaveGroup(g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadGroup("bar"); err != nil {
+		t.Fatal(err)
+	}
+	g.Expansions[0] = "EXCLUDE:baz"
+	gotg, err := c.LoadGroup("bar")
+	if err != nil || gotg.GetExpansions()[0] != "INCLUDE:baz" {
+		t.Fatalf("Cache shares the saved group: %v %v", gotg, err)
+	}
+	gotg.Expansions[0] = "EXCLUDE:baz"
+	gotg, err = c.LoadGroup("bar")
+	if err != nil || gotg.GetExpansions()[0] != "INCLUDE:baz" {
+		t.Fatalf("Cache shares the loaded group: %v %v", gotg, err)
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	c, backend := newTestCache(t)
+
+	if err := c.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadEntity("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+
+	if err := c.SaveGroup(&pb.Group{Name: proto.String("bar")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteGroup("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadGroup("bar"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+
+	// Changes made in a transaction are seen after commit.
+	if err := c.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Update(func(tx db.DB) error {
+		return tx.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(2)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := c.LoadEntity("foo"); err != nil || e.GetNumber() != 2 {
+		t.Errorf("Stale entity after commit: %v %v", e, err)
+	}
+
+	// A rolled back transaction leaves the cache alone.
+	errAbort := errors.New("abort")
+	err = c.Update(func(tx db.DB) error {
+		if err := tx.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(3)}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+	if c.entities.len() != 1 {
+		t.Errorf("Rollback changed the cache: %s", stats(c.entities))
+	}
+
+	// A failed commit empties the cache.
+	backend.failCommit = true
+	err = c.Update(func(tx db.DB) error {
+		return tx.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(4)})
+	})
+	if err != db.ErrInternalError {
+		t.Fatal(err)
+	}
+	if c.entities.len() != 0 {
+		t.Errorf("Cache survived failed commit: %s", stats(c.entities))
+	}
+}
+
+func TestSavesOutOfOrder(t *testing.T) {
+	c, backend := newTestCache(t)
+
+	// The first save reaches the database first, but is the
+	// last to return.
+	first := true
+	backend.afterSave = func() {
+		if first {
+			first = false
+			if err := c.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(2)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := c.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, err := c.LoadEntity("foo"); err != nil || e.GetNumber() != 2 {
+		t.Errorf("Stale entity after saves: %v %v", e, err)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	*entityLimit = 2
+	*groupLimit = 0
+	defer func() {
+		*entityLimit = 10000
+		*groupLimit = 1000
+	}()
+	c, _ := newTestCache(t)
+
+	load := func(id string) {
+		if _, err := c.LoadEntity(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := c.SaveEntity(&pb.Entity{ID: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+		load(id)
+	}
+	if c.entities.len() != 2 || c.entities.evictions != 1 {
+		t.Errorf("Limit not enforced: %s", stats(c.entities))
+	}
+
+	// The oldest entry is the one that goes.
+	if _, ok := c.entities.items["a"]; ok {
+		t.Error("Wrong entity evicted")
+	}
+
+	// Using an entry keeps it around.
+	load("b")
+	if err := c.SaveEntity(&pb.Entity{ID: proto.String("d")}); err != nil {
+		t.Fatal(err)
+	}
+	load("d")
+	if _, ok := c.entities.items["b"]; !ok {
+		t.Error("Recently used entity evicted")
+	}
+
+	// A limit of 0 disables the cache.
+	if err := c.SaveGroup(&pb.Group{Name: proto.String("grp")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.LoadGroup("grp"); err != nil {
+		t.Fatal(err)
+	}
+	if c.groups.len() != 0 {
+		t.Errorf("Disabled cache holds groups: %s", stats(c.groups))
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	c, _ := newTestCache(t)
+	if err := c.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.LoadEntity("foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := c.healthCheck()
+	if !r.OK {
+		t.Error("Cache reported a failure")
+	}
+	if !strings.Contains(r.Status, "1 hits") {
+		t.Errorf("Stats missing from status: %s", r.Status)
+	}
+}
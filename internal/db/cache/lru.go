@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"container/list"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// lru is a size limited map of protos that evicts the least recently
+// used entry when it is full.  It is not safe for concurrent use.
+type lru struct {
+	limit int
+	ll    *list.List
+	items map[string]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+type lruEntry struct {
+	key   string
+	value proto.Message
+}
+
+func newLRU(limit int) *lru {
+	return &lru{
+		limit: limit,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value stored under key, and counts the lookup as a
+// hit or a miss.
+func (c *lru) get(key string) (proto.Message, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+// add stores value under key, evicting old entries if needed.  A
+// limit of zero or less disables the cache.
+func (c *lru) add(key string, value proto.Message) {
+	if c.limit <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value})
+	for c.ll.Len() > c.limit {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *lru) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *lru) purge() {
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
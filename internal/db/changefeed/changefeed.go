@@ -0,0 +1,273 @@
+// Package changefeed provides a db.DB that reports every change made
+// through it.  Each change is given a revision, and the recent
+// history is kept so that watchers which disconnect can pick up
+// where they left off.
+package changefeed
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	historySize = flag.Int("db_change_history", 10000, "Number of changes to keep for watchers that resume")
+	watchBuffer = flag.Int("db_change_buffer", 100, "Number of changes to buffer for each watcher before dropping it")
+)
+
+// An Event describes a single change.  Name is the entity ID or the
+// group name.
+type Event struct {
+	Revision uint64
+	Type     pb.ChangeType
+	Name     string
+}
+
+// The Feed type wraps another db.DB and records the changes made
+// through it.
+type Feed struct {
+	db.DB
+
+	// wmu is held for every write so that revisions are handed
+	// out in the order that changes are made.
+	wmu sync.Mutex
+
+	// mu protects everything below.  The history is a ring of up
+	// to historySize events, with the oldest at start.
+	mu       sync.Mutex
+	revision uint64
+	history  []Event
+	start    int
+	watchers map[chan Event]struct{}
+}
+
+// New returns a Feed in front of d.
+func New(d db.DB) *Feed {
+	return &Feed{
+		DB: d,
+
+		// Revisions start from the time the server started,
+		// so they keep going up across restarts and a watcher
+		// can't mistake a revision from an earlier run for a
+		// current one.
+		revision: uint64(time.Now().UnixNano()),
+		watchers: make(map[chan Event]struct{}),
+	}
+}
+
+// Revision returns the revision of the most recent change.
+func (f *Feed) Revision() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.revision
+}
+
+// Watch returns a channel that receives every change after the given
+// revision, followed by changes as they happen.  A revision of 0
+// watches only for new changes.  The returned function must be called
+// to stop watching.  If the watcher falls behind the channel is
+// closed, and Watch may be called again with the last revision
+// received.
+func (f *Feed) Watch(revision uint64) (<-chan Event, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var backlog []Event
+	if revision != 0 {
+		// The oldest revision that can be resumed from is the
+		// one just before the oldest change that is kept.
+		oldest := f.revision
+		if len(f.history) > 0 {
+			oldest = f.history[f.start].Revision - 1
+		}
+		if revision < oldest || revision > f.revision {
+			return nil, nil, ErrRevisionUnavailable
+		}
+		for i := range f.history {
+			ev := f.history[(f.start+i)%len(f.history)]
+			if ev.Revision > revision {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	ch := make(chan Event, len(backlog)+*watchBuffer)
+	for _, ev := range backlog {
+		ch <- ev
+	}
+	f.watchers[ch] = struct{}{}
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.watchers[ch]; ok {
+			delete(f.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, nil
+}
+
+// publish assigns revisions to events, adds them to the history, and
+// sends them to every watcher.  Watchers whose buffers are full are
+// dropped rather than holding up the server.
+func (f *Feed) publish(events []Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, ev := range events {
+		f.revision++
+		ev.Revision = f.revision
+
+		// Once the history is full each event takes the place
+		// of the oldest.
+		switch {
+		case len(f.history) < *historySize:
+			f.history = append(f.history, ev)
+		case len(f.history) > 0:
+			f.history[f.start] = ev
+			f.start = (f.start + 1) % len(f.history)
+		}
+
+		for ch := range f.watchers {
+			select {
+			case ch <- ev:
+			default:
+				delete(f.watchers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// SaveEntity saves the entity and reports it as created or modified.
+func (f *Feed) SaveEntity(e *pb.Entity) error {
+	return f.write(db.Op{Kind: db.OpSaveEntity, Name: e.GetID(), Entity: e}, func() error {
+		return f.DB.SaveEntity(e)
+	})
+}
+
+// DeleteEntity deletes the entity and reports it as deleted.
+func (f *Feed) DeleteEntity(ID string) error {
+	return f.write(db.Op{Kind: db.OpDeleteEntity, Name: ID}, func() error {
+		return f.DB.DeleteEntity(ID)
+	})
+}
+
+// SaveGroup saves the group and reports it as created or modified.
+func (f *Feed) SaveGroup(g *pb.Group) error {
+	return f.write(db.Op{Kind: db.OpSaveGroup, Name: g.GetName(), Group: g}, func() error {
+		return f.DB.SaveGroup(g)
+	})
+}
+
+// DeleteGroup deletes the group and reports it as deleted.
+func (f *Feed) DeleteGroup(name string) error {
+	return f.write(db.Op{Kind: db.OpDeleteGroup, Name: name}, func() error {
+		return f.DB.DeleteGroup(name)
+	})
+}
+
+// write makes a single change with fn, and publishes it if it
+// succeeds.
+func (f *Feed) write(op db.Op, fn func() error) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	events := classify(f.DB, []db.Op{op})
+	if err := fn(); err != nil {
+		return err
+	}
+	f.publish(events)
+	return nil
+}
+
+// Update runs a transaction on the wrapped database if it supports
+// them.  The changes are published together once the transaction has
+// been committed.
+func (f *Feed) Update(fn func(db.DB) error) error {
+	t, ok := f.DB.(db.Transactor)
+	if !ok {
+		return fn(f)
+	}
+
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	var events []Event
+	err := t.Update(func(tx db.DB) error {
+		b := db.NewBatch(tx)
+		if err := fn(b); err != nil {
+			return err
+		}
+		events = classify(tx, b.Ops())
+		return db.Apply(tx, b.Ops())
+	})
+	if err != nil {
+		return err
+	}
+	f.publish(events)
+	return nil
+}
+
+// View runs fn with all writes held off until it returns, so that fn
+// sees the database as it was at a single revision, which is
+// returned.
+func (f *Feed) View(fn func(db.DB) error) (uint64, error) {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	return f.Revision(), fn(f.DB)
+}
+
+// classify works out which event each change will cause, by checking
+// what exists in d before the changes are made.
+func classify(d db.DB, ops []db.Op) []Event {
+	entities := make(map[string]bool)
+	groups := make(map[string]bool)
+	entityExists := func(ID string) bool {
+		if e, ok := entities[ID]; ok {
+			return e
+		}
+		_, err := d.LoadEntity(ID)
+		return err == nil
+	}
+	groupExists := func(name string) bool {
+		if g, ok := groups[name]; ok {
+			return g
+		}
+		_, err := d.LoadGroup(name)
+		return err == nil
+	}
+
+	events := make([]Event, 0, len(ops))
+	for _, op := range ops {
+		ev := Event{Name: op.Name}
+		switch op.Kind {
+		case db.OpSaveEntity:
+			ev.Type = pb.ChangeType_ENTITY_CREATED
+			if entityExists(op.Name) {
+				ev.Type = pb.ChangeType_ENTITY_MODIFIED
+			}
+			entities[op.Name] = true
+		case db.OpDeleteEntity:
+			ev.Type = pb.ChangeType_ENTITY_DELETED
+			entities[op.Name] = false
+		case db.OpSaveGroup:
+			ev.Type = pb.ChangeType_GROUP_CREATED
+			if groupExists(op.Name) {
+				ev.Type = pb.ChangeType_GROUP_MODIFIED
+			}
+			groups[op.Name] = true
+		case db.OpDeleteGroup:
+			ev.Type = pb.ChangeType_GROUP_DELETED
+			groups[op.Name] = false
+		}
+		events = append(events, ev)
+	}
+	return events
+}
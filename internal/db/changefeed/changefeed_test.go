@@ -0,0 +1,284 @@
+package changefeed
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
+	"github.com/NetAuth/NetAuth/internal/db/memdb"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// noTxnDB hides the transactions of the database it wraps.
+type noTxnDB struct {
+	db.DB
+}
+
+func newTestFeed(t *testing.T) *Feed {
+	m, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(m)
+}
+
+// drain reads the events that are currently waiting on ch.
+func drain(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func checkEvents(t *testing.T, got []Event, want []Event) {
+	if len(got) != len(want) {
+		t.Fatalf("Got %d events, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || got[i].Name != want[i].Name {
+			t.Errorf("Event %d is %v, want %v", i, got[i], want[i])
+		}
+		if i > 0 && got[i].Revision != got[i-1].Revision+1 {
+			t.Errorf("Revision %d does not follow %d", got[i].Revision, got[i-1].Revision)
+		}
+	}
+}
+
+func TestContract(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.DB { return newTestFeed(t) })
+}
+
+func TestEvents(t *testing.T) {
+	f := newTestFeed(t)
+	ch, cancel, err := f.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	e := &pb.Entity{ID: proto.String("foo")}
+	g := &pb.Group{Name: proto.String("bar")}
+	f.SaveEntity(e)
+	f.SaveEntity(e)
+	f.DeleteEntity("foo")
+	f.SaveGroup(g)
+	f.SaveGroup(g)
+	f.DeleteGroup("bar")
+
+	// Failed writes aren't changes.
+	if err := f.DeleteGroup("bar"); err != db.ErrUnknownGroup {
+		t.Fatal(err)
+	}
+
+	checkEvents(t, drain(ch), []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "foo"},
+		{Type: pb.ChangeType_ENTITY_MODIFIED, Name: "foo"},
+		{Type: pb.ChangeType_ENTITY_DELETED, Name: "foo"},
+		{Type: pb.ChangeType_GROUP_CREATED, Name: "bar"},
+		{Type: pb.ChangeType_GROUP_MODIFIED, Name: "bar"},
+		{Type: pb.ChangeType_GROUP_DELETED, Name: "bar"},
+	})
+}
+
+func TestResume(t *testing.T) {
+	f := newTestFeed(t)
+	f.SaveEntity(&pb.Entity{ID: proto.String("a")})
+	rev := f.Revision()
+	f.SaveEntity(&pb.Entity{ID: proto.String("b")})
+	f.SaveEntity(&pb.Entity{ID: proto.String("c")})
+
+	ch, cancel, err := f.Watch(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	f.SaveEntity(&pb.Entity{ID: proto.String("d")})
+
+	events := drain(ch)
+	checkEvents(t, events, []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "b"},
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "c"},
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "d"},
+	})
+	if events[0].Revision != rev+1 {
+		t.Errorf("First revision is %d, want %d", events[0].Revision, rev+1)
+	}
+}
+
+func TestRevisionUnavailable(t *testing.T) {
+	*historySize = 2
+	defer func() { *historySize = 10000 }()
+
+	f := newTestFeed(t)
+	start := f.Revision()
+	for _, ID := range []string{"a", "b", "c"} {
+		f.SaveEntity(&pb.Entity{ID: proto.String(ID)})
+	}
+
+	for _, rev := range []uint64{start, f.Revision() + 1} {
+		if _, _, err := f.Watch(rev); err != ErrRevisionUnavailable {
+			t.Errorf("Watch(%d): %v", rev, err)
+		}
+	}
+
+	ch, cancel, err := f.Watch(start + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	checkEvents(t, drain(ch), []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "b"},
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "c"},
+	})
+}
+
+func TestHistoryWraps(t *testing.T) {
+	*historySize = 3
+	defer func() { *historySize = 10000 }()
+
+	f := newTestFeed(t)
+	for _, ID := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		f.SaveEntity(&pb.Entity{ID: proto.String(ID)})
+	}
+
+	rev := f.Revision()
+	if _, _, err := f.Watch(rev - 4); err != ErrRevisionUnavailable {
+		t.Errorf("Watch(%d): %v", rev-4, err)
+	}
+	ch, cancel, err := f.Watch(rev - 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	checkEvents(t, drain(ch), []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "e"},
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "f"},
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "g"},
+	})
+}
+
+func TestSlowWatcher(t *testing.T) {
+	*watchBuffer = 1
+	defer func() { *watchBuffer = 100 }()
+
+	f := newTestFeed(t)
+	ch, cancel, err := f.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	f.SaveEntity(&pb.Entity{ID: proto.String("a")})
+	f.SaveEntity(&pb.Entity{ID: proto.String("b")})
+
+	if ev := <-ch; ev.Name != "a" {
+		t.Errorf("Got %v", ev)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("Slow watcher was not disconnected")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	f := newTestFeed(t)
+	ch, cancel, err := f.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	cancel()
+
+	f.SaveEntity(&pb.Entity{ID: proto.String("a")})
+	if _, ok := <-ch; ok {
+		t.Error("Cancelled watcher got an event")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	f := newTestFeed(t)
+	f.SaveGroup(&pb.Group{Name: proto.String("g")})
+	ch, cancel, err := f.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	// A transaction that fails reports nothing.
+	fail := errors.New("fail")
+	if err := f.Update(func(tx db.DB) error {
+		tx.SaveEntity(&pb.Entity{ID: proto.String("a")})
+		return fail
+	}); err != fail {
+		t.Fatal(err)
+	}
+	if events := drain(ch); len(events) != 0 {
+		t.Fatalf("Failed transaction reported %v", events)
+	}
+
+	if err := f.Update(func(tx db.DB) error {
+		tx.SaveEntity(&pb.Entity{ID: proto.String("a")})
+		tx.SaveEntity(&pb.Entity{ID: proto.String("a")})
+		tx.SaveGroup(&pb.Group{Name: proto.String("g")})
+		return tx.DeleteGroup("g")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, drain(ch), []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "a"},
+		{Type: pb.ChangeType_ENTITY_MODIFIED, Name: "a"},
+		{Type: pb.ChangeType_GROUP_MODIFIED, Name: "g"},
+		{Type: pb.ChangeType_GROUP_DELETED, Name: "g"},
+	})
+}
+
+func TestUpdateNoTxn(t *testing.T) {
+	m, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(noTxnDB{m})
+	ch, cancel, err := f.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err := f.Update(func(tx db.DB) error {
+		return tx.SaveEntity(&pb.Entity{ID: proto.String("a")})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, drain(ch), []Event{
+		{Type: pb.ChangeType_ENTITY_CREATED, Name: "a"},
+	})
+}
+
+func TestView(t *testing.T) {
+	f := newTestFeed(t)
+	f.SaveEntity(&pb.Entity{ID: proto.String("a")})
+
+	var IDs []string
+	rev, err := f.View(func(d db.DB) error {
+		var err error
+		IDs, err = d.DiscoverEntityIDs()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != f.Revision() || len(IDs) != 1 {
+		t.Errorf("View at %d saw %v", rev, IDs)
+	}
+}
@@ -0,0 +1,18 @@
+package changefeed
+
+import (
+	"errors"
+)
+
+var (
+	// ErrRevisionUnavailable is returned when a watch is asked to
+	// resume from a revision that is no longer in the history,
+	// or that this server never issued.  The watcher must reload
+	// everything it cares about and start watching afresh.
+	ErrRevisionUnavailable = errors.New("the requested revision is not available")
+
+	// ErrWatcherFellBehind is reported when a watcher does not
+	// keep up with the changes being made and is disconnected.
+	// It may resume from the last revision it received.
+	ErrWatcherFellBehind = errors.New("the watcher did not keep up with changes")
+)
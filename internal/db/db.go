@@ -22,6 +22,17 @@ type DB interface {
 	DeleteGroup(string) error
 }
 
+// A Transactor is a DB that can apply a group of changes as one.
+// This is optional, callers must check for it with a type assertion
+// and fall back to making changes one at a time.
+type Transactor interface {
+	// Update calls fn with a DB that reads through to the
+	// database and records every change made.  If fn returns nil
+	// the changes are applied together, otherwise they are all
+	// discarded and the error is returned.
+	Update(fn func(DB) error) error
+}
+
 // Factory defines the function which can be used to register new
 // implementations.
 type Factory func() (DB, error)
@@ -0,0 +1,260 @@
+// Package dbtest provides the tests that every implementation of
+// db.DB must pass.  Implementations call Run from their own tests so
+// that all backends are held to the same contract.
+package dbtest
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A Factory returns a new, empty, instance of the database under
+// test.  Any cleanup should be registered by the factory itself.
+type Factory func(*testing.T) db.DB
+
+// Run runs every contract test against databases returned by the
+// factory.  Each test gets a fresh database.  Tests of optional
+// interfaces are skipped for databases that don't implement them.
+func Run(t *testing.T, f Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, db.DB)
+	}{
+		{"DiscoverEntities", testDiscoverEntities},
+		{"EntitySaveLoadDelete", testEntitySaveLoadDelete},
+		{"EntityOverwrite", testEntityOverwrite},
+		{"EntityUnknown", testEntityUnknown},
+		{"DiscoverGroups", testDiscoverGroups},
+		{"GroupSaveLoadDelete", testGroupSaveLoadDelete},
+		{"GroupOverwrite", testGroupOverwrite},
+		{"GroupUnknown", testGroupUnknown},
+		{"EntitiesAndGroupsSeparate", testEntitiesAndGroupsSeparate},
+		{"TxnCommit", testTxnCommit},
+		{"TxnRollback", testTxnRollback},
+		{"TxnIsolation", testTxnIsolation},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, f(t)) })
+	}
+}
+
+func sorted(l []string) string {
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func testDiscoverEntities(t *testing.T, x db.DB) {
+	l, err := x.DiscoverEntityIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 0 {
+		t.Errorf("Entities discovered in an empty database: %v", l)
+	}
+
+	for _, id := range []string{"foo", "bar", "baz"} {
+		if err := x.SaveEntity(&pb.Entity{ID: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err = x.DiscoverEntityIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sorted(l); got != "bar,baz,foo" {
+		t.Errorf("Wrong entities discovered: %s", got)
+	}
+}
+
+func testEntitySaveLoadDelete(t *testing.T, x db.DB) {
+	e := &pb.Entity{
+		ID:     proto.String("foo"),
+		Number: proto.Int32(42),
+		Secret: proto.String("secret"),
+		Meta: &pb.EntityMeta{
+			Groups: []string{"group1", "group2"},
+		},
+		Revision: proto.Uint64(7),
+	}
+
+	if err := x.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+
+	ne, err := x.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(e, ne) {
+		t.Errorf("Loaded entity and original are not equivalent! '%v', '%v'", e, ne)
+	}
+
+	if err := x.DeleteEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.LoadEntity("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	l, err := x.DiscoverEntityIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 0 {
+		t.Errorf("Deleted entity was discovered: %v", l)
+	}
+}
+
+func testEntityOverwrite(t *testing.T, x db.DB) {
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := x.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != 2 {
+		t.Errorf("Entity was not overwritten: %v", e)
+	}
+	l, err := x.DiscoverEntityIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 {
+		t.Errorf("Overwriting created a new entity: %v", l)
+	}
+}
+
+func testEntityUnknown(t *testing.T, x db.DB) {
+	if _, err := x.LoadEntity("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	if err := x.DeleteEntity("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
+
+func testDiscoverGroups(t *testing.T, x db.DB) {
+	l, err := x.DiscoverGroupNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 0 {
+		t.Errorf("Groups discovered in an empty database: %v", l)
+	}
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := x.SaveGroup(&pb.Group{Name: proto.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err = x.DiscoverGroupNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sorted(l); got != "bar,baz,foo" {
+		t.Errorf("Wrong groups discovered: %s", got)
+	}
+}
+
+func testGroupSaveLoadDelete(t *testing.T, x db.DB) {
+	g := &pb.Group{
+		Name:        proto.String("foo"),
+		DisplayName: proto.String("Foo Group"),
+		Number:      proto.Int32(42),
+		Expansions:  []string{"INCLUDE:bar"},
+		Revision:    proto.Uint64(7),
+	}
+
+	if err := x.SaveGroup(g); err != nil {
+		t.Fatal(err)
+	}
+
+	ng, err := x.LoadGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, ng) {
+		t.Errorf("Loaded group and original are not equivalent! '%v', '%v'", g, ng)
+	}
+
+	if err := x.DeleteGroup("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.LoadGroup("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+	l, err := x.DiscoverGroupNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 0 {
+		t.Errorf("Deleted group was discovered: %v", l)
+	}
+}
+
+func testGroupOverwrite(t *testing.T, x db.DB) {
+	if err := x.SaveGroup(&pb.Group{Name: proto.String("foo"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SaveGroup(&pb.Group{Name: proto.String("foo"), Number: proto.Int32(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := x.LoadGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetNumber() != 2 {
+		t.Errorf("Group was not overwritten: %v", g)
+	}
+	l, err := x.DiscoverGroupNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 {
+		t.Errorf("Overwriting created a new group: %v", l)
+	}
+}
+
+func testGroupUnknown(t *testing.T, x db.DB) {
+	if _, err := x.LoadGroup("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+	if err := x.DeleteGroup("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+}
+
+func testEntitiesAndGroupsSeparate(t *testing.T, x db.DB) {
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.LoadGroup("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+
+	if err := x.SaveGroup(&pb.Group{Name: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.DeleteEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.LoadGroup("foo"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,128 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var errAbort = errors.New("abort")
+
+func transactor(t *testing.T, x db.DB) db.Transactor {
+	tx, ok := x.(db.Transactor)
+	if !ok {
+		t.Skip("database does not support transactions")
+	}
+	return tx
+}
+
+func seed(t *testing.T, x db.DB) {
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("old"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SaveGroup(&pb.Group{Name: proto.String("grp"), Number: proto.Int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTxnCommit(t *testing.T, x db.DB) {
+	tx := transactor(t, x)
+	seed(t, x)
+
+	err := tx.Update(func(d db.DB) error {
+		if err := d.SaveEntity(&pb.Entity{ID: proto.String("new"), Number: proto.Int32(2)}); err != nil {
+			return err
+		}
+		if err := d.DeleteEntity("old"); err != nil {
+			return err
+		}
+		g, err := d.LoadGroup("grp")
+		if err != nil {
+			return err
+		}
+		g.DisplayName = proto.String("Group")
+		if err := d.SaveGroup(g); err != nil {
+			return err
+		}
+
+		// Changes are visible inside the transaction.
+		if _, err := d.LoadEntity("new"); err != nil {
+			t.Errorf("Saved entity not visible: %v", err)
+		}
+		if _, err := d.LoadEntity("old"); err != db.ErrUnknownEntity {
+			t.Errorf("Deleted entity still visible: %v", err)
+		}
+		if err := d.DeleteEntity("old"); err != db.ErrUnknownEntity {
+			t.Errorf("Deleted entity deleted twice: %v", err)
+		}
+		if l, err := d.DiscoverEntityIDs(); err != nil || sorted(l) != "new" {
+			t.Errorf("Wrong entities discovered: %v %v", l, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := x.DiscoverEntityIDs(); err != nil || sorted(l) != "new" {
+		t.Errorf("Wrong entities after commit: %v %v", l, err)
+	}
+	g, err := x.LoadGroup("grp")
+	if err != nil || g.GetDisplayName() != "Group" {
+		t.Errorf("Group change not committed: %v %v", g, err)
+	}
+}
+
+func testTxnRollback(t *testing.T, x db.DB) {
+	tx := transactor(t, x)
+	seed(t, x)
+
+	err := tx.Update(func(d db.DB) error {
+		if err := d.SaveEntity(&pb.Entity{ID: proto.String("new")}); err != nil {
+			return err
+		}
+		if err := d.DeleteGroup("grp"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Wrong error from aborted transaction: %v", err)
+	}
+
+	if _, err := x.LoadEntity("new"); err != db.ErrUnknownEntity {
+		t.Errorf("Rolled back entity exists: %v", err)
+	}
+	if _, err := x.LoadGroup("grp"); err != nil {
+		t.Errorf("Rolled back delete happened: %v", err)
+	}
+}
+
+func testTxnIsolation(t *testing.T, x db.DB) {
+	tx := transactor(t, x)
+	seed(t, x)
+
+	// Changing a record that was loaded in a transaction that is
+	// then abandoned must not change what is stored.
+	err := tx.Update(func(d db.DB) error {
+		e, err := d.LoadEntity("old")
+		if err != nil {
+			return err
+		}
+		e.Number = proto.Int32(99)
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+
+	e, err := x.LoadEntity("old")
+	if err != nil || e.GetNumber() != 1 {
+		t.Errorf("Change leaked out of transaction: %v %v", e, err)
+	}
+}
@@ -101,6 +101,17 @@ func (m *MemDB) DeleteGroup(name string) error {
 	return nil
 }
 
+// Update runs fn as a transaction.  Changes are recorded and only
+// copied into the maps once fn has succeeded, which can't fail part
+// way through.
+func (m *MemDB) Update(fn func(db.DB) error) error {
+	b := db.NewBatch(m)
+	if err := fn(b); err != nil {
+		return err
+	}
+	return db.Apply(m, b.Ops())
+}
+
 func (m *MemDB) healthCheck() health.SubsystemStatus {
 	return health.SubsystemStatus{
 		OK:     true,
@@ -6,10 +6,21 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
 
 	pb "github.com/NetAuth/Protocol"
 )
 
+func TestContract(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.DB {
+		x, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	})
+}
+
 func TestDiscoverEntities(t *testing.T) {
 	x, err := New()
 	if err != nil {
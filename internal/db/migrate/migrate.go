@@ -0,0 +1,182 @@
+// Package migrate copies everything from one database to another, so
+// that a server can be moved between backends.
+package migrate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/backup"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A Report summarizes what was, or in a dry run would be, copied.
+type Report struct {
+	Entities    int
+	Groups      int
+	Secrets     int
+	Expansions  int
+	UntypedMeta int
+
+	// Problems lists every record that did not arrive intact at
+	// the destination.  It is only filled in by Verify.
+	Problems []string
+}
+
+// String formats the report for people to read.
+func (r *Report) String() string {
+	s := fmt.Sprintf("%d entities (%d with secrets), %d groups, %d expansions, %d untyped meta entries",
+		r.Entities, r.Secrets, r.Groups, r.Expansions, r.UntypedMeta)
+	for _, p := range r.Problems {
+		s += "\n  " + p
+	}
+	return s
+}
+
+// Copy copies every entity and group from src to dst, which must be
+// empty.  Records are copied whole, so numbers, secrets, memberships,
+// expansions and metadata are all kept.  As with any restore, tokens
+// issued before the copy are revoked.  If dryRun is set nothing is
+// written, but dst is still checked and the report shows what would
+// have been copied.
+func Copy(src, dst db.DB, dryRun bool) (*Report, error) {
+	a, err := backup.Take(src)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		Entities: len(a.Entities),
+		Groups:   len(a.Groups),
+	}
+	for _, e := range a.Entities {
+		if e.GetSecret() != "" {
+			r.Secrets++
+		}
+		r.UntypedMeta += len(e.GetMeta().GetUntypedMeta())
+	}
+	for _, g := range a.Groups {
+		r.Expansions += len(g.GetExpansions())
+		r.UntypedMeta += len(g.GetUntypedMeta())
+	}
+
+	if dryRun {
+		if err := checkEmpty(dst); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	if err := a.Restore(dst); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Verify compares src and dst record by record, and reports any
+// record that is missing, different, or only present in dst.
+func Verify(src, dst db.DB) (*Report, error) {
+	r := &Report{}
+
+	srcIDs, err := src.DiscoverEntityIDs()
+	if err != nil {
+		return nil, err
+	}
+	dstIDs, err := dst.DiscoverEntityIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ID := range srcIDs {
+		r.Entities++
+		e, err := src.LoadEntity(ID)
+		if err != nil {
+			return nil, err
+		}
+		ne, err := dst.LoadEntity(ID)
+		switch {
+		case err == db.ErrUnknownEntity:
+			r.Problems = append(r.Problems, fmt.Sprintf("entity %s is missing", ID))
+		case err != nil:
+			return nil, err
+		case !sameEntity(e, ne):
+			r.Problems = append(r.Problems, fmt.Sprintf("entity %s differs", ID))
+		}
+	}
+	for _, ID := range extra(srcIDs, dstIDs) {
+		r.Problems = append(r.Problems, fmt.Sprintf("entity %s was not in the source", ID))
+	}
+
+	srcNames, err := src.DiscoverGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	dstNames, err := dst.DiscoverGroupNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range srcNames {
+		r.Groups++
+		g, err := src.LoadGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		ng, err := dst.LoadGroup(name)
+		switch {
+		case err == db.ErrUnknownGroup:
+			r.Problems = append(r.Problems, fmt.Sprintf("group %s is missing", name))
+		case err != nil:
+			return nil, err
+		case !proto.Equal(g, ng):
+			r.Problems = append(r.Problems, fmt.Sprintf("group %s differs", name))
+		}
+	}
+	for _, name := range extra(srcNames, dstNames) {
+		r.Problems = append(r.Problems, fmt.Sprintf("group %s was not in the source", name))
+	}
+
+	return r, nil
+}
+
+// sameEntity reports whether two entities are the same apart from
+// their token revocations, which Copy resets on purpose.
+func sameEntity(a, b *pb.Entity) bool {
+	a = proto.Clone(a).(*pb.Entity)
+	b = proto.Clone(b).(*pb.Entity)
+	a.TokenRevocation = nil
+	b.TokenRevocation = nil
+	return proto.Equal(a, b)
+}
+
+// checkEmpty returns backup.ErrNotEmpty if d has any records.
+func checkEmpty(d db.DB) error {
+	IDs, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return err
+	}
+	if len(IDs) != 0 || len(names) != 0 {
+		return backup.ErrNotEmpty
+	}
+	return nil
+}
+
+// extra returns the names in b that are not in a, sorted.
+func extra(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, n := range a {
+		seen[n] = true
+	}
+	var out []string
+	for _, n := range b {
+		if !seen[n] {
+			out = append(out, n)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,136 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/backup"
+	"github.com/NetAuth/NetAuth/internal/db/memdb"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func newTestDB(t *testing.T) db.DB {
+	m, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func newTestSource(t *testing.T) db.DB {
+	d := newTestDB(t)
+	d.SaveEntity(&pb.Entity{
+		ID:     proto.String("foo"),
+		Number: proto.Int32(1000),
+		Secret: proto.String("secret"),
+		Meta: &pb.EntityMeta{
+			Groups:      []string{"bar"},
+			UntypedMeta: []string{"k:v"},
+		},
+	})
+	d.SaveEntity(&pb.Entity{ID: proto.String("baz"), Number: proto.Int32(1001)})
+	d.SaveGroup(&pb.Group{
+		Name:       proto.String("bar"),
+		Number:     proto.Int32(10),
+		Expansions: []string{"INCLUDE:qux"},
+	})
+	d.SaveGroup(&pb.Group{Name: proto.String("qux"), Number: proto.Int32(11)})
+	return d
+}
+
+func checkReport(t *testing.T, r *Report) {
+	if r.Entities != 2 || r.Groups != 2 || r.Secrets != 1 || r.Expansions != 1 || r.UntypedMeta != 1 {
+		t.Errorf("Wrong report: %s", r)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestDB(t)
+
+	r, err := Copy(src, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(t, r)
+
+	r, err = Verify(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Problems) != 0 {
+		t.Errorf("Problems after copy: %s", r)
+	}
+
+	e, err := dst.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != 1000 || e.GetSecret() != "secret" {
+		t.Errorf("Entity not copied whole: %v", e)
+	}
+	if e.GetTokenRevocation().GetIssuedBefore() == 0 {
+		t.Error("Tokens were not revoked for the copied entity")
+	}
+}
+
+func TestCopyDryRun(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestDB(t)
+
+	r, err := Copy(src, dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(t, r)
+
+	IDs, _ := dst.DiscoverEntityIDs()
+	names, _ := dst.DiscoverGroupNames()
+	if len(IDs) != 0 || len(names) != 0 {
+		t.Errorf("Dry run wrote %v %v", IDs, names)
+	}
+}
+
+func TestCopyNotEmpty(t *testing.T) {
+	dst := newTestDB(t)
+	dst.SaveEntity(&pb.Entity{ID: proto.String("existing")})
+
+	for _, dryRun := range []bool{true, false} {
+		if _, err := Copy(newTestSource(t), dst, dryRun); err != backup.ErrNotEmpty {
+			t.Errorf("dryRun %v: %v", dryRun, err)
+		}
+	}
+}
+
+func TestVerifyProblems(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestDB(t)
+	if _, err := Copy(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.DeleteEntity("baz")
+	dst.SaveGroup(&pb.Group{Name: proto.String("bar"), Number: proto.Int32(12)})
+	dst.SaveGroup(&pb.Group{Name: proto.String("extra")})
+
+	r, err := Verify(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"entity baz is missing",
+		"group bar differs",
+		"group extra was not in the source",
+	}
+	if len(r.Problems) != len(want) {
+		t.Fatalf("Got problems %v", r.Problems)
+	}
+	for i := range want {
+		if r.Problems[i] != want[i] {
+			t.Errorf("Problem %d is %q, want %q", i, r.Problems[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package protodb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// tmpMarker is part of the name of every temporary file.
+	// Since it follows the .dat extension temporary files are
+	// never picked up during discovery.
+	tmpMarker = ".tmp-"
+
+	lockFile      = ".lock"
+	quarantineDir = "quarantine"
+)
+
+// writeFileAtomic replaces the file at path with data.  The data is
+// written to a temporary file in the same directory, flushed to disk,
+// and then renamed over the original, so a reader sees either the old
+// file or the new one but never a partial write.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	f, err := ioutil.TempFile(dir, base+tmpMarker)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if err := writeAndSync(f, data, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The rename is only durable once the directory is synced.
+	return syncDir(dir)
+}
+
+func writeAndSync(f *os.File, data []byte, perm os.FileMode) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// quarantineTempFiles moves temporary files that were left behind by
+// a crash out of the way.  They are kept rather than deleted so that
+// an operator can inspect them, but they are never loaded.
+func (pdb *ProtoDB) quarantineTempFiles() error {
+	dirs := []struct {
+		label, path string
+	}{
+		{"root", pdb.dataRoot},
+		{entitySubdir, filepath.Join(pdb.dataRoot, entitySubdir)},
+		{groupSubdir, filepath.Join(pdb.dataRoot, groupSubdir)},
+	}
+	for _, d := range dirs {
+		globs, _ := filepath.Glob(filepath.Join(d.path, "*"+tmpMarker+"*"))
+		for _, g := range globs {
+			qdir := filepath.Join(pdb.dataRoot, quarantineDir)
+			if err := os.MkdirAll(qdir, 0750); err != nil {
+				return err
+			}
+			name := fmt.Sprintf("%s-%s-%d", d.label, filepath.Base(g), time.Now().Unix())
+			log.Printf("Quarantining interrupted write '%s' as '%s'", g, name)
+			if err := os.Rename(g, filepath.Join(qdir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
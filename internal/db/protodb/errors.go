@@ -0,0 +1,11 @@
+package protodb
+
+import (
+	"errors"
+)
+
+var (
+	// ErrDataRootLocked is returned when another process already
+	// has the data_root open.
+	ErrDataRootLocked = errors.New("the data root is in use by another process")
+)
@@ -0,0 +1,142 @@
+package protodb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// Transactions are made atomic with a journal.  The complete list of
+// changes is written to the journal before any of them are made, and
+// the journal is removed once they all have been.  If the server
+// stops in between, the journal is replayed at the next start.  If
+// the changes fail to apply the journal is left in place, and is
+// replayed before the next transaction, which is refused for as long
+// as the replay fails.
+
+const journalFile = "journal"
+
+// journalEntry is the on disk form of a db.Op.  Records are stored
+// in their marshaled form.
+type journalEntry struct {
+	Kind db.OpKind
+	Name string
+	Data []byte
+}
+
+// Update runs fn as a transaction.  Either all of the changes made by
+// fn are applied, or none of them are.
+func (pdb *ProtoDB) Update(fn func(db.DB) error) error {
+	// An earlier transaction that failed part way has to be
+	// finished first, fn would otherwise see and build on the
+	// half applied state.
+	if err := pdb.replayJournal(); err != nil {
+		log.Printf("Refusing transaction, an earlier one could not be completed (%s)", err)
+		return db.ErrInternalError
+	}
+
+	b := db.NewBatch(pdb)
+	if err := fn(b); err != nil {
+		return err
+	}
+	ops := b.Ops()
+	if len(ops) == 0 {
+		return nil
+	}
+
+	if err := pdb.writeJournal(ops); err != nil {
+		log.Printf("Failed to write journal (%s)", err)
+		return db.ErrInternalError
+	}
+	if err := db.Apply(pdb, ops); err != nil {
+		// The journal is left in place so the transaction
+		// completes when it is replayed.
+		log.Printf("Failed to apply journal, it will be replayed before the next transaction (%s)", err)
+		return db.ErrInternalError
+	}
+	return pdb.clearJournal()
+}
+
+func (pdb *ProtoDB) writeJournal(ops []db.Op) error {
+	entries := make([]journalEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = journalEntry{Kind: op.Kind, Name: op.Name}
+
+		var err error
+		switch op.Kind {
+		case db.OpSaveEntity:
+			entries[i].Data, err = proto.Marshal(op.Entity)
+		case db.OpSaveGroup:
+			entries[i].Data, err = proto.Marshal(op.Group)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(pdb.dataRoot, journalFile), buf.Bytes(), 0640)
+}
+
+func (pdb *ProtoDB) clearJournal() error {
+	if err := os.Remove(filepath.Join(pdb.dataRoot, journalFile)); err != nil {
+		log.Printf("Failed to remove journal (%s)", err)
+		return db.ErrInternalError
+	}
+	if err := syncDir(pdb.dataRoot); err != nil {
+		log.Printf("Failed to sync data root (%s)", err)
+		return db.ErrInternalError
+	}
+	return nil
+}
+
+// replayJournal finishes a transaction that was interrupted or that
+// failed to apply.  It is called at startup while the data_root is
+// locked, and before each transaction.
+func (pdb *ProtoDB) replayJournal() error {
+	in, err := ioutil.ReadFile(filepath.Join(pdb.dataRoot, journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []journalEntry
+	if err := gob.NewDecoder(bytes.NewReader(in)).Decode(&entries); err != nil {
+		return err
+	}
+
+	ops := make([]db.Op, len(entries))
+	for i, j := range entries {
+		ops[i] = db.Op{Kind: j.Kind, Name: j.Name}
+		switch j.Kind {
+		case db.OpSaveEntity:
+			ops[i].Entity = &pb.Entity{}
+			err = proto.Unmarshal(j.Data, ops[i].Entity)
+		case db.OpSaveGroup:
+			ops[i].Group = &pb.Group{}
+			err = proto.Unmarshal(j.Data, ops[i].Group)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Replaying %d changes from an interrupted transaction", len(ops))
+	if err := db.Apply(pdb, ops); err != nil {
+		return err
+	}
+	return pdb.clearJournal()
+}
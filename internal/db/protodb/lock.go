@@ -0,0 +1,32 @@
+// +build !windows
+
+package protodb
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+)
+
+// lockDataRoot takes an advisory lock on the data_root so that two
+// servers can't write to the same tree at once.  The lock is held
+// until the returned file is closed, or the process exits.
+func lockDataRoot(root string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(root, lockFile), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		log.Printf("Could not open lock file (%s)", err)
+		return nil, db.ErrInternalError
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrDataRootLocked
+		}
+		log.Printf("Could not lock data root (%s)", err)
+		return nil, db.ErrInternalError
+	}
+	return f, nil
+}
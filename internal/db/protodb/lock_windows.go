@@ -0,0 +1,21 @@
+package protodb
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+)
+
+// lockDataRoot only creates the lock file on Windows, advisory locks
+// are not implemented there.
+func lockDataRoot(root string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(root, lockFile), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		log.Printf("Could not open lock file (%s)", err)
+		return nil, db.ErrInternalError
+	}
+	log.Println("Advisory locking is unavailable, do not share the data root!")
+	return f, nil
+}
@@ -2,7 +2,9 @@ package protodb
 
 // This is one of the simplest databases that just reads and writes
 // protos to the local disk.  It's probably quite usable in
-// environments that don't have high modification rates.
+// environments that don't have high modification rates.  Writes are
+// atomic, and the data_root is locked so that only one server may use
+// it at a time.
 
 import (
 	"flag"
@@ -27,6 +29,7 @@ const groupSubdir = "groups"
 // package.
 type ProtoDB struct {
 	dataRoot string
+	lock     *os.File
 }
 
 var (
@@ -42,7 +45,8 @@ func init() {
 // and fail out if it does not have permissions to write/stat the base
 // directory and children.  This function will bail out the entire
 // program as without the backing store the functionality of the rest
-// of the server is undefined!
+// of the server is undefined!  If another process holds the lock on
+// the data_root, ErrDataRootLocked is returned.
 func New() (db.DB, error) {
 	x := new(ProtoDB)
 	x.dataRoot = *dataRoot
@@ -51,11 +55,35 @@ func New() (db.DB, error) {
 		return nil, err
 	}
 
+	var err error
+	x.lock, err = lockDataRoot(x.dataRoot)
+	if err != nil {
+		log.Printf("Could not lock data directory! (%s)", err)
+		return nil, err
+	}
+
+	if err := x.quarantineTempFiles(); err != nil {
+		log.Printf("Could not quarantine temporary files! (%s)", err)
+		x.Close()
+		return nil, db.ErrInternalError
+	}
+
+	if err := x.replayJournal(); err != nil {
+		log.Printf("Could not replay journal! (%s)", err)
+		x.Close()
+		return nil, db.ErrInternalError
+	}
+
 	health.RegisterCheck("ProtoDB", x.healthCheck)
 
 	return x, nil
 }
 
+// Close releases the lock on the data_root.
+func (pdb *ProtoDB) Close() error {
+	return pdb.lock.Close()
+}
+
 // DiscoverEntityIDs returns a list of entity IDs that this loader can
 // retrieve by globbing the entity directory of the data_root.  This
 // is not foolproof, but assuming that the data_root is not modified
@@ -100,10 +128,9 @@ func (pdb *ProtoDB) LoadEntity(ID string) (*pb.Entity, error) {
 }
 
 // SaveEntity writes an entity to disk.  Errors may be returned for
-// proto marshal errors or for errors writing to disk.  No promises
-// are made regarding if the data has been written to disk at the
-// return of this function as the operatig system may choose to buffer
-// the data until a larger block may be written.
+// proto marshal errors or for errors writing to disk.  The entity is
+// on disk when this function returns without error, and a crash
+// during the write leaves the previous version intact.
 func (pdb *ProtoDB) SaveEntity(e *pb.Entity) error {
 	out, err := proto.Marshal(e)
 	if err != nil {
@@ -111,9 +138,9 @@ func (pdb *ProtoDB) SaveEntity(e *pb.Entity) error {
 		return db.ErrInternalError
 	}
 
-	if err := ioutil.WriteFile(filepath.Join(pdb.dataRoot, entitySubdir,
+	if err := writeFileAtomic(filepath.Join(pdb.dataRoot, entitySubdir,
 		fmt.Sprintf("%s.dat", e.GetID())), out, 0644); err != nil {
-		log.Printf("Failed to acquire write handle for '%s'", e.GetID())
+		log.Printf("Failed to write '%s' (%s)", e.GetID(), err)
 		return db.ErrInternalError
 	}
 
@@ -177,10 +204,9 @@ func (pdb *ProtoDB) LoadGroup(name string) (*pb.Group, error) {
 }
 
 // SaveGroup writes an group to disk.  Errors may be returned for
-// proto marshal errors or for errors writing to disk.  No promises
-// are made regarding if the data has been written to disk at the
-// return of this function as the operatig system may choose to buffer
-// the data until a larger block may be written.
+// proto marshal errors or for errors writing to disk.  The group is
+// on disk when this function returns without error, and a crash
+// during the write leaves the previous version intact.
 func (pdb *ProtoDB) SaveGroup(g *pb.Group) error {
 	out, err := proto.Marshal(g)
 	if err != nil {
@@ -188,9 +214,9 @@ func (pdb *ProtoDB) SaveGroup(g *pb.Group) error {
 		return db.ErrInternalError
 	}
 
-	if err := ioutil.WriteFile(filepath.Join(pdb.dataRoot, groupSubdir,
+	if err := writeFileAtomic(filepath.Join(pdb.dataRoot, groupSubdir,
 		fmt.Sprintf("%s.dat", g.GetName())), out, 0644); err != nil {
-		log.Printf("Failed to acquire write handle for '%s'", g.GetName())
+		log.Printf("Failed to write '%s' (%s)", g.GetName(), err)
 		return db.ErrInternalError
 	}
 
@@ -260,5 +286,11 @@ func (pdb *ProtoDB) healthCheck() health.SubsystemStatus {
 			return status
 		}
 	}
+
+	if _, err := os.Stat(filepath.Join(pdb.dataRoot, journalFile)); err == nil {
+		status.OK = false
+		status.Status = "A transaction failed to apply and is waiting to be replayed"
+		return status
+	}
 	return status
 }
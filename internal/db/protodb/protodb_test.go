@@ -9,6 +9,7 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
 
 	pb "github.com/NetAuth/Protocol"
 )
@@ -39,6 +40,25 @@ func cleanTmpTestDir(dir string, t *testing.T) {
 	}
 }
 
+func TestContract(t *testing.T) {
+	var dirs []string
+	defer func() {
+		for _, d := range dirs {
+			cleanTmpTestDir(d, t)
+		}
+	}()
+
+	dbtest.Run(t, func(t *testing.T) db.DB {
+		*dataRoot = mkTmpTestDir(t)
+		dirs = append(dirs, *dataRoot)
+		x, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	})
+}
+
 func TestDiscoverEntities(t *testing.T) {
 	// This is a slight race condition since we're manipulating
 	// flags, but this shouldn't actually be flaky.
@@ -302,8 +322,9 @@ func TestSaveEntityUnwritableFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Write an empty file to disk that will collide with a proper write
-	if err := ioutil.WriteFile(filepath.Join(*dataRoot, entitySubdir, "foo.dat"), []byte("foo"), 0000); err != nil {
+	// Put a directory where the file should go, it can't be
+	// replaced by the rename at the end of a write.
+	if err := os.MkdirAll(filepath.Join(*dataRoot, entitySubdir, "foo.dat", "x"), 0750); err != nil {
 		t.Fatal(err)
 	}
 
@@ -401,8 +422,9 @@ func TestSaveGroupUnwritableFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Write an empty file to disk that will collide with a proper write
-	if err := ioutil.WriteFile(filepath.Join(*dataRoot, groupSubdir, "group1.dat"), []byte("group1"), 0000); err != nil {
+	// Put a directory where the file should go, it can't be
+	// replaced by the rename at the end of a write.
+	if err := os.MkdirAll(filepath.Join(*dataRoot, groupSubdir, "group1.dat", "x"), 0750); err != nil {
 		t.Fatal(err)
 	}
 
@@ -523,3 +545,224 @@ func TestHealthCheckBadStat(t *testing.T) {
 		t.Errorf("Bad status: %v", status)
 	}
 }
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo"), Number: proto.Int32(int32(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(*dataRoot, entitySubdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "foo.dat" {
+		t.Errorf("Unexpected files after save: %v", files)
+	}
+	if files[0].Mode().Perm() != 0644 {
+		t.Errorf("Wrong mode on saved file: %v", files[0].Mode())
+	}
+
+	e, err := x.LoadEntity("foo")
+	if err != nil || e.GetNumber() != 2 {
+		t.Errorf("Wrong entity loaded: %v %v", e, err)
+	}
+}
+
+func TestDataRootLocked(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(); err != ErrDataRootLocked {
+		t.Errorf("Second open of data root: %v", err)
+	}
+
+	// Once closed the data root can be opened again.
+	if err := x.(*ProtoDB).Close(); err != nil {
+		t.Fatal(err)
+	}
+	y, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	y.(*ProtoDB).Close()
+}
+
+func TestQuarantineTempFiles(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	x.(*ProtoDB).Close()
+
+	// Simulate a crash part way through writing each kind of
+	// record.
+	stray := []string{
+		filepath.Join(*dataRoot, entitySubdir, "foo.dat"+tmpMarker+"123"),
+		filepath.Join(*dataRoot, groupSubdir, "bar.dat"+tmpMarker+"456"),
+	}
+	for _, f := range stray {
+		if err := ioutil.WriteFile(f, []byte("trunc"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	x, err = New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.(*ProtoDB).Close()
+
+	for _, f := range stray {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("Temp file %s was not moved: %v", f, err)
+		}
+	}
+	q, err := ioutil.ReadDir(filepath.Join(*dataRoot, quarantineDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q) != 2 {
+		t.Errorf("Wrong number of quarantined files: %v", q)
+	}
+
+	ids, err := x.DiscoverEntityIDs()
+	if err != nil || len(ids) != 1 || ids[0] != "foo" {
+		t.Errorf("Wrong entities discovered: %v %v", ids, err)
+	}
+	if _, err := x.LoadEntity("foo"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdb := x.(*ProtoDB)
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("old")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a journal but stop before applying it, as if the
+	// server had crashed.
+	ops := []db.Op{
+		{Kind: db.OpSaveEntity, Name: "new", Entity: &pb.Entity{ID: proto.String("new")}},
+		{Kind: db.OpDeleteEntity, Name: "old"},
+		{Kind: db.OpSaveGroup, Name: "grp", Group: &pb.Group{Name: proto.String("grp")}},
+	}
+	if err := pdb.writeJournal(ops); err != nil {
+		t.Fatal(err)
+	}
+	pdb.Close()
+
+	x, err = New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.(*ProtoDB).Close()
+
+	if _, err := os.Stat(filepath.Join(*dataRoot, journalFile)); !os.IsNotExist(err) {
+		t.Errorf("Journal was not removed: %v", err)
+	}
+	if _, err := x.LoadEntity("new"); err != nil {
+		t.Error(err)
+	}
+	if _, err := x.LoadEntity("old"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	if _, err := x.LoadGroup("grp"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateReplaysJournal(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdb := x.(*ProtoDB)
+	defer pdb.Close()
+
+	// A journal that is still there after a transaction failed to
+	// apply is finished before the next transaction starts.
+	ops := []db.Op{{Kind: db.OpSaveEntity, Name: "foo", Entity: &pb.Entity{ID: proto.String("foo")}}}
+	if err := pdb.writeJournal(ops); err != nil {
+		t.Fatal(err)
+	}
+	if r := pdb.healthCheck(); r.OK {
+		t.Error("Health check passed with a journal waiting")
+	}
+
+	err = pdb.Update(func(tx db.DB) error {
+		if _, err := tx.LoadEntity("foo"); err != nil {
+			t.Errorf("Transaction started before the journal was replayed: %v", err)
+		}
+		return tx.SaveEntity(&pb.Entity{ID: proto.String("bar")})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ID := range []string{"foo", "bar"} {
+		if _, err := pdb.LoadEntity(ID); err != nil {
+			t.Error(err)
+		}
+	}
+	if r := pdb.healthCheck(); !r.OK {
+		t.Error(r.Status)
+	}
+
+	// While the journal can't be replayed no more transactions
+	// are accepted.
+	if err := ioutil.WriteFile(filepath.Join(*dataRoot, journalFile), []byte("garbage"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	err = pdb.Update(func(tx db.DB) error {
+		return tx.SaveEntity(&pb.Entity{ID: proto.String("baz")})
+	})
+	if err != db.ErrInternalError {
+		t.Error(err)
+	}
+	if _, err := pdb.LoadEntity("baz"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
+
+func TestReplayJournalCorrupt(t *testing.T) {
+	*dataRoot = mkTmpTestDir(t)
+	defer cleanTmpTestDir(*dataRoot, t)
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.(*ProtoDB).Close()
+
+	if err := ioutil.WriteFile(filepath.Join(*dataRoot, journalFile), []byte("garbage"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(); err != db.ErrInternalError {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,152 @@
+package sqldb
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+)
+
+// A migration is a list of statements that move the schema from one
+// version to the next.  Migrations are only ever appended to this
+// list, a migration that has shipped must never be changed.
+type migration []string
+
+var migrations = []migration{
+	// 1: Initial schema
+	{
+		`CREATE TABLE entities (
+			id TEXT PRIMARY KEY,
+			number INTEGER,
+			secret TEXT,
+			secret_changed INTEGER,
+			primary_group TEXT,
+			gecos TEXT,
+			legal_name TEXT,
+			display_name TEXT,
+			home TEXT,
+			shell TEXT,
+			graphical_shell TEXT,
+			badge_number TEXT,
+			locked BOOLEAN,
+			extra BLOB
+		)`,
+		`CREATE TABLE groups (
+			name TEXT PRIMARY KEY,
+			display_name TEXT,
+			number INTEGER,
+			managed_by TEXT,
+			extra BLOB
+		)`,
+		`CREATE TABLE memberships (
+			entity_id TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (entity_id, position)
+		)`,
+		`CREATE INDEX memberships_group ON memberships (group_name)`,
+		`CREATE TABLE expansions (
+			parent TEXT NOT NULL,
+			mode TEXT NOT NULL,
+			child TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (parent, position)
+		)`,
+		`CREATE INDEX expansions_child ON expansions (child)`,
+	},
+
+	// 2: Tables for capabilities, keys and untyped meta.  Rows
+	// saved before this keep these fields in the extra column
+	// until they are next saved.
+	{
+		`CREATE TABLE entity_capabilities (
+			entity_id TEXT NOT NULL,
+			capability TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (entity_id, position)
+		)`,
+		`CREATE INDEX entity_capabilities_capability ON entity_capabilities (capability)`,
+		`CREATE TABLE entity_keys (
+			entity_id TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (entity_id, position)
+		)`,
+		`CREATE TABLE entity_meta (
+			entity_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (entity_id, position)
+		)`,
+		`CREATE INDEX entity_meta_name ON entity_meta (name)`,
+		`CREATE TABLE group_capabilities (
+			group_name TEXT NOT NULL,
+			capability TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (group_name, position)
+		)`,
+		`CREATE INDEX group_capabilities_capability ON group_capabilities (capability)`,
+		`CREATE TABLE group_meta (
+			group_name TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (group_name, position)
+		)`,
+		`CREATE INDEX group_meta_name ON group_meta (name)`,
+	},
+}
+
+// schemaVersion returns the version of the schema currently in the
+// database.  A database that has never been migrated is at version
+// 0.
+func schemaVersion(q querier) (int, error) {
+	var v int
+	err := q.QueryRow("SELECT version FROM schema_version").Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return v, err
+}
+
+// migrate brings the schema up to date.  Each migration is applied in
+// its own transaction along with the version bump, so a failed
+// migration leaves the database at the last good version.
+func (s *SQLDB) migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		log.Printf("Could not create schema_version table (%s)", err)
+		return db.ErrInternalError
+	}
+
+	current, err := schemaVersion(s.db)
+	if err != nil {
+		log.Printf("Could not determine schema version (%s)", err)
+		return db.ErrInternalError
+	}
+	if current > len(migrations) {
+		log.Printf("Database schema version %d is newer than this server understands (%d)", current, len(migrations))
+		return db.ErrInternalError
+	}
+
+	for v := current; v < len(migrations); v++ {
+		log.Printf("Migrating database schema to version %d", v+1)
+		err := s.inTx(func(tx *sql.Tx) error {
+			for _, stmt := range migrations[v] {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec("DELETE FROM schema_version"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_version (version) VALUES (?)", v+1)
+			return err
+		})
+		if err != nil {
+			log.Printf("Migration to version %d failed (%s)", v+1, err)
+			return db.ErrInternalError
+		}
+	}
+	return nil
+}
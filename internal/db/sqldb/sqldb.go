@@ -0,0 +1,627 @@
+package sqldb
+
+// SQLDB stores entities and groups in a relational database through
+// database/sql.  The schema has real tables for entities, groups,
+// direct memberships and group expansions so that the data can be
+// queried and backed up with standard tools.  Capabilities, keys and
+// untyped metadata have tables of their own as well.  Anything else
+// is kept in an extra column as serialized protobuf so that nothing
+// is lost.
+//
+// The statements are written for SQLite, which is the only driver
+// that is built in and needs no outside service.
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/health"
+	"github.com/golang/protobuf/proto"
+
+	// Register the SQLite driver with database/sql
+	_ "github.com/mattn/go-sqlite3"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// The SQLDB type binds all methods that are a part of the sqldb
+// package.
+type SQLDB struct {
+	driver string
+	db     *sql.DB
+}
+
+// querier is the part of the interface shared by *sql.DB and *sql.Tx
+// that is needed to run a query.
+type querier interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+var (
+	driver = flag.String("sqldb_driver", "sqlite3", "database/sql driver to use for SQLDB")
+	dsn    = flag.String("sqldb_dsn", "./netauth.sqlite", "Data source name for SQLDB, for SQLite this is the path to the file")
+)
+
+func init() {
+	db.Register("SQLDB", New)
+}
+
+// New returns a new SQLDB instance that is connected and has an up
+// to date schema.  Migrations are applied here, before any data is
+// read.
+func New() (db.DB, error) {
+	x := new(SQLDB)
+	x.driver = *driver
+
+	var err error
+	x.db, err = sql.Open(x.driver, *dsn)
+	if err != nil {
+		log.Printf("Could not open database (%s)", err)
+		return nil, db.ErrInternalError
+	}
+	if x.driver == "sqlite3" {
+		// SQLite only permits a single writer, and every
+		// connection to an in-memory database is a different
+		// database, so all work is funneled through one
+		// connection.
+		x.db.SetMaxOpenConns(1)
+	}
+	if err := x.db.Ping(); err != nil {
+		log.Printf("Could not connect to database (%s)", err)
+		x.db.Close()
+		return nil, db.ErrInternalError
+	}
+
+	if err := x.migrate(); err != nil {
+		x.db.Close()
+		return nil, err
+	}
+
+	health.RegisterCheck("SQLDB", x.healthCheck)
+
+	return x, nil
+}
+
+// Close closes the connection to the database.
+func (s *SQLDB) Close() error {
+	return s.db.Close()
+}
+
+// DiscoverEntityIDs returns a list of entity IDs that are stored in
+// the database.
+func (s *SQLDB) DiscoverEntityIDs() ([]string, error) {
+	return s.list("SELECT id FROM entities ORDER BY id")
+}
+
+// LoadEntity loads a single entity from the database given the ID
+// associated with the entity.
+func (s *SQLDB) LoadEntity(ID string) (*pb.Entity, error) {
+	var e *pb.Entity
+	err := s.txn("loading entity "+ID, func(tx *sql.Tx) error {
+		var err error
+		e, err = loadEntity(tx, ID)
+		return err
+	})
+	return e, err
+}
+
+// SaveEntity writes an entity to the database, replacing any existing
+// entity with the same ID.
+func (s *SQLDB) SaveEntity(e *pb.Entity) error {
+	return s.txn("saving entity "+e.GetID(), func(tx *sql.Tx) error {
+		return saveEntity(tx, e)
+	})
+}
+
+// DeleteEntity removes an entity and its memberships from the
+// database.
+func (s *SQLDB) DeleteEntity(ID string) error {
+	return s.txn("deleting entity "+ID, func(tx *sql.Tx) error {
+		return deleteEntity(tx, ID)
+	})
+}
+
+// DiscoverGroupNames returns a list of group names that are stored in
+// the database.
+func (s *SQLDB) DiscoverGroupNames() ([]string, error) {
+	return s.list("SELECT name FROM groups ORDER BY name")
+}
+
+// LoadGroup loads a single group from the database given its name.
+func (s *SQLDB) LoadGroup(name string) (*pb.Group, error) {
+	var g *pb.Group
+	err := s.txn("loading group "+name, func(tx *sql.Tx) error {
+		var err error
+		g, err = loadGroup(tx, name)
+		return err
+	})
+	return g, err
+}
+
+// SaveGroup writes a group to the database, replacing any existing
+// group with the same name.
+func (s *SQLDB) SaveGroup(g *pb.Group) error {
+	return s.txn("saving group "+g.GetName(), func(tx *sql.Tx) error {
+		return saveGroup(tx, g)
+	})
+}
+
+// DeleteGroup removes a group and its expansions from the database.
+// Memberships that name the group are left alone, as they are for
+// every other backend.
+func (s *SQLDB) DeleteGroup(name string) error {
+	return s.txn("deleting group "+name, func(tx *sql.Tx) error {
+		return deleteGroup(tx, name)
+	})
+}
+
+// Update runs fn as a transaction.  The changes fn makes are written
+// in a single SQL transaction once it returns.
+func (s *SQLDB) Update(fn func(db.DB) error) error {
+	b := db.NewBatch(s)
+	if err := fn(b); err != nil {
+		return err
+	}
+
+	return s.txn("committing transaction", func(tx *sql.Tx) error {
+		for _, op := range b.Ops() {
+			var err error
+			switch op.Kind {
+			case db.OpSaveEntity:
+				err = saveEntity(tx, op.Entity)
+			case db.OpDeleteEntity:
+				err = deleteEntity(tx, op.Name)
+			case db.OpSaveGroup:
+				err = saveGroup(tx, op.Group)
+			case db.OpDeleteGroup:
+				err = deleteGroup(tx, op.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func loadEntity(tx *sql.Tx, ID string) (*pb.Entity, error) {
+	c := &pb.Entity{Meta: &pb.EntityMeta{}}
+	var extra []byte
+	err := tx.QueryRow(`SELECT id, number, secret, secret_changed,
+		primary_group, gecos, legal_name, display_name, home, shell,
+		graphical_shell, badge_number, locked, extra
+		FROM entities WHERE id = ?`, ID).Scan(
+		&c.ID, &c.Number, &c.Secret, &c.SecretChanged,
+		&c.Meta.PrimaryGroup, &c.Meta.GECOS, &c.Meta.LegalName, &c.Meta.DisplayName, &c.Meta.Home, &c.Meta.Shell,
+		&c.Meta.GraphicalShell, &c.Meta.BadgeNumber, &c.Meta.Locked, &extra,
+	)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrUnknownEntity
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The extra column holds everything else, and records
+	// whether the entity had any metadata at all.
+	e := &pb.Entity{}
+	if err := proto.Unmarshal(extra, e); err != nil {
+		return nil, err
+	}
+	e.ID = c.ID
+	e.Number = c.Number
+	e.Secret = c.Secret
+	e.SecretChanged = c.SecretChanged
+	if e.Meta == nil {
+		return e, nil
+	}
+	e.Meta.PrimaryGroup = c.Meta.PrimaryGroup
+	e.Meta.GECOS = c.Meta.GECOS
+	e.Meta.LegalName = c.Meta.LegalName
+	e.Meta.DisplayName = c.Meta.DisplayName
+	e.Meta.Home = c.Meta.Home
+	e.Meta.Shell = c.Meta.Shell
+	e.Meta.GraphicalShell = c.Meta.GraphicalShell
+	e.Meta.BadgeNumber = c.Meta.BadgeNumber
+	e.Meta.Locked = c.Meta.Locked
+
+	groups, err := queryStrings(tx, "SELECT group_name FROM memberships WHERE entity_id = ? ORDER BY position", ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) > 0 {
+		e.Meta.Groups = groups
+	}
+
+	// Entities saved before these fields had tables of their own
+	// still have them in the extra column, so the rows are added
+	// to whatever was there.
+	caps, err := queryCapabilities(tx, "SELECT capability FROM entity_capabilities WHERE entity_id = ? ORDER BY position", ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Meta.Capabilities = append(e.Meta.Capabilities, caps...)
+	keys, err := queryStrings(tx, "SELECT public_key FROM entity_keys WHERE entity_id = ? ORDER BY position", ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Meta.Keys = append(e.Meta.Keys, keys...)
+	meta, err := queryMeta(tx, "SELECT name, value FROM entity_meta WHERE entity_id = ? ORDER BY position", ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Meta.UntypedMeta = append(e.Meta.UntypedMeta, meta...)
+	return e, nil
+}
+
+func saveEntity(tx *sql.Tx, e *pb.Entity) error {
+	// Everything that has a column or table of its own is
+	// cleared out of the copy that goes in the extra column.
+	x := proto.Clone(e).(*pb.Entity)
+	x.ID = nil
+	x.Number = nil
+	x.Secret = nil
+	x.SecretChanged = nil
+	m := e.GetMeta()
+	if m == nil {
+		m = &pb.EntityMeta{}
+	} else {
+		x.Meta.PrimaryGroup = nil
+		x.Meta.GECOS = nil
+		x.Meta.LegalName = nil
+		x.Meta.DisplayName = nil
+		x.Meta.Home = nil
+		x.Meta.Shell = nil
+		x.Meta.GraphicalShell = nil
+		x.Meta.BadgeNumber = nil
+		x.Meta.Locked = nil
+		x.Meta.Groups = nil
+		x.Meta.Capabilities = nil
+		x.Meta.Keys = nil
+		x.Meta.UntypedMeta = nil
+	}
+	extra, err := proto.Marshal(x)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteEntity(tx, e.GetID()); err != nil && err != db.ErrUnknownEntity {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO entities (id, number, secret, secret_changed,
+		primary_group, gecos, legal_name, display_name, home, shell,
+		graphical_shell, badge_number, locked, extra)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.Number, e.Secret, e.SecretChanged,
+		m.PrimaryGroup, m.GECOS, m.LegalName, m.DisplayName, m.Home, m.Shell,
+		m.GraphicalShell, m.BadgeNumber, m.Locked, extra,
+	)
+	if err != nil {
+		return err
+	}
+
+	ID := e.GetID()
+	if err := insertStrings(tx, "INSERT INTO memberships (entity_id, group_name, position) VALUES (?, ?, ?)", ID, m.GetGroups()); err != nil {
+		return err
+	}
+	if err := insertStrings(tx, "INSERT INTO entity_capabilities (entity_id, capability, position) VALUES (?, ?, ?)", ID, capabilityNames(m.GetCapabilities())); err != nil {
+		return err
+	}
+	if err := insertStrings(tx, "INSERT INTO entity_keys (entity_id, public_key, position) VALUES (?, ?, ?)", ID, m.GetKeys()); err != nil {
+		return err
+	}
+	return insertMeta(tx, "INSERT INTO entity_meta (entity_id, name, value, position) VALUES (?, ?, ?, ?)", ID, m.GetUntypedMeta())
+}
+
+func deleteEntity(tx *sql.Tx, ID string) error {
+	for _, stmt := range []string{
+		"DELETE FROM memberships WHERE entity_id = ?",
+		"DELETE FROM entity_capabilities WHERE entity_id = ?",
+		"DELETE FROM entity_keys WHERE entity_id = ?",
+		"DELETE FROM entity_meta WHERE entity_id = ?",
+	} {
+		if _, err := tx.Exec(stmt, ID); err != nil {
+			return err
+		}
+	}
+	return deleteRow(tx, "DELETE FROM entities WHERE id = ?", ID, db.ErrUnknownEntity)
+}
+
+func loadGroup(tx *sql.Tx, name string) (*pb.Group, error) {
+	c := &pb.Group{}
+	var extra []byte
+	err := tx.QueryRow(`SELECT name, display_name, number, managed_by, extra
+		FROM groups WHERE name = ?`, name).Scan(
+		&c.Name, &c.DisplayName, &c.Number, &c.ManagedBy, &extra,
+	)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrUnknownGroup
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g := &pb.Group{}
+	if err := proto.Unmarshal(extra, g); err != nil {
+		return nil, err
+	}
+	g.Name = c.Name
+	g.DisplayName = c.DisplayName
+	g.Number = c.Number
+	g.ManagedBy = c.ManagedBy
+
+	rows, err := tx.Query("SELECT mode, child FROM expansions WHERE parent = ? ORDER BY position", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mode, child string
+		if err := rows.Scan(&mode, &child); err != nil {
+			return nil, err
+		}
+		g.Expansions = append(g.Expansions, fmt.Sprintf("%s:%s", mode, child))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// As with entities, groups saved before these tables existed
+	// still have the fields in the extra column.
+	caps, err := queryCapabilities(tx, "SELECT capability FROM group_capabilities WHERE group_name = ? ORDER BY position", name)
+	if err != nil {
+		return nil, err
+	}
+	g.Capabilities = append(g.Capabilities, caps...)
+	meta, err := queryMeta(tx, "SELECT name, value FROM group_meta WHERE group_name = ? ORDER BY position", name)
+	if err != nil {
+		return nil, err
+	}
+	g.UntypedMeta = append(g.UntypedMeta, meta...)
+	return g, nil
+}
+
+func saveGroup(tx *sql.Tx, g *pb.Group) error {
+	x := proto.Clone(g).(*pb.Group)
+	x.Name = nil
+	x.DisplayName = nil
+	x.Number = nil
+	x.ManagedBy = nil
+	x.Expansions = nil
+	x.Capabilities = nil
+	x.UntypedMeta = nil
+	extra, err := proto.Marshal(x)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteGroup(tx, g.GetName()); err != nil && err != db.ErrUnknownGroup {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO groups (name, display_name, number, managed_by, extra)
+		VALUES (?, ?, ?, ?, ?)`,
+		g.Name, g.DisplayName, g.Number, g.ManagedBy, extra,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i, exp := range g.GetExpansions() {
+		parts := strings.SplitN(exp, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("malformed expansion '%s'", exp)
+		}
+		_, err := tx.Exec("INSERT INTO expansions (parent, mode, child, position) VALUES (?, ?, ?, ?)", g.GetName(), parts[0], parts[1], i)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertStrings(tx, "INSERT INTO group_capabilities (group_name, capability, position) VALUES (?, ?, ?)", g.GetName(), capabilityNames(g.GetCapabilities())); err != nil {
+		return err
+	}
+	return insertMeta(tx, "INSERT INTO group_meta (group_name, name, value, position) VALUES (?, ?, ?, ?)", g.GetName(), g.GetUntypedMeta())
+}
+
+func deleteGroup(tx *sql.Tx, name string) error {
+	for _, stmt := range []string{
+		"DELETE FROM expansions WHERE parent = ?",
+		"DELETE FROM group_capabilities WHERE group_name = ?",
+		"DELETE FROM group_meta WHERE group_name = ?",
+	} {
+		if _, err := tx.Exec(stmt, name); err != nil {
+			return err
+		}
+	}
+	return deleteRow(tx, "DELETE FROM groups WHERE name = ?", name, db.ErrUnknownGroup)
+}
+
+// insertStrings runs the insert statement once for each value, with
+// the owner, the value, and its position as the arguments.
+func insertStrings(tx *sql.Tx, stmt, owner string, values []string) error {
+	for i, v := range values {
+		if _, err := tx.Exec(stmt, owner, v, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertMeta runs the insert statement once for each untyped meta
+// entry, with the owner, the key, the value, and its position as the
+// arguments.  Entries are split at the first colon, an entry without
+// one is stored with a NULL value so that it comes back as it was.
+func insertMeta(tx *sql.Tx, stmt, owner string, entries []string) error {
+	for i, kv := range entries {
+		var value *string
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) == 2 {
+			value = &parts[1]
+		}
+		if _, err := tx.Exec(stmt, owner, parts[0], value, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryMeta returns the untyped meta entries stored by insertMeta.
+func queryMeta(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			key += ":" + value.String
+		}
+		out = append(out, key)
+	}
+	return out, rows.Err()
+}
+
+// capabilityNames returns the names of the capabilities, which are
+// what is stored so that the tables can be read without the
+// Protocol at hand.
+func capabilityNames(caps []pb.Capability) []string {
+	var names []string
+	for _, c := range caps {
+		names = append(names, c.String())
+	}
+	return names
+}
+
+// queryCapabilities returns the capabilities named by the single
+// column of every row matched by the query.  A name that isn't known
+// is an error rather than being read as the zero value, which would
+// be GLOBAL_ROOT.
+func queryCapabilities(tx *sql.Tx, query string, args ...interface{}) ([]pb.Capability, error) {
+	names, err := queryStrings(tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var caps []pb.Capability
+	for _, n := range names {
+		v, ok := pb.Capability_value[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability '%s'", n)
+		}
+		caps = append(caps, pb.Capability(v))
+	}
+	return caps, nil
+}
+
+// deleteRow runs a delete statement that should remove exactly one
+// row, returning notFound if there was nothing to remove.
+func deleteRow(tx *sql.Tx, stmt, key string, notFound error) error {
+	res, err := tx.Exec(stmt, key)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// list returns the single string column of every row matched by the
+// query.
+func (s *SQLDB) list(query string) ([]string, error) {
+	var out []string
+	err := s.txn("listing", func(tx *sql.Tx) error {
+		var err error
+		out, err = queryStrings(tx, query)
+		return err
+	})
+	return out, err
+}
+
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]string, 0)
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// txn runs fn in a transaction.  The errors that are part of the
+// db.DB interface are passed through, anything else is logged and
+// becomes db.ErrInternalError.
+func (s *SQLDB) txn(what string, fn func(*sql.Tx) error) error {
+	err := s.inTx(fn)
+	switch err {
+	case nil, db.ErrUnknownEntity, db.ErrUnknownGroup:
+		return err
+	}
+	log.Printf("Database error while %s (%s)", what, err)
+	return db.ErrInternalError
+}
+
+// inTx runs fn in a transaction that is committed if fn returns no
+// error and rolled back otherwise.
+func (s *SQLDB) inTx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// healthCheck verifies that the database can be reached and that the
+// schema is the version this server expects.
+func (s *SQLDB) healthCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{
+		OK:     true,
+		Name:   "SQLDB",
+		Status: "SQLDB is operating normally",
+	}
+
+	if err := s.db.Ping(); err != nil {
+		status.OK = false
+		status.Status = fmt.Sprintf("Database is unreachable: %s", err)
+		return status
+	}
+
+	v, err := schemaVersion(s.db)
+	if err != nil {
+		status.OK = false
+		status.Status = fmt.Sprintf("Schema version is unreadable: %s", err)
+		return status
+	}
+	if v != len(migrations) {
+		status.OK = false
+		status.Status = fmt.Sprintf("Schema is at version %d, want %d", v, len(migrations))
+		return status
+	}
+	return status
+}
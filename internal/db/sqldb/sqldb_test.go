@@ -0,0 +1,312 @@
+package sqldb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/dbtest"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func mkTmpTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("/tmp", "sqldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func cleanTmpTestDir(dir string, t *testing.T) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Log(err)
+	}
+}
+
+func newTestDB(t *testing.T, dir string) *SQLDB {
+	*dsn = filepath.Join(dir, "netauth.sqlite")
+	x, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x.(*SQLDB)
+}
+
+func TestContract(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+
+	var open []*SQLDB
+	defer func() {
+		for _, x := range open {
+			x.Close()
+		}
+	}()
+
+	dbtest.Run(t, func(t *testing.T) db.DB {
+		sub, err := ioutil.TempDir(dir, "contract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := newTestDB(t, sub)
+		open = append(open, x)
+		return x
+	})
+}
+
+func TestRoundTripAllFields(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+	x := newTestDB(t, dir)
+	defer x.Close()
+
+	e := &pb.Entity{
+		ID:            proto.String("foo"),
+		Number:        proto.Int32(42),
+		Secret:        proto.String("secret"),
+		SecretChanged: proto.Int64(1234),
+		SecretHistory: []string{"old"},
+		Meta: &pb.EntityMeta{
+			GECOS:        proto.String("Foo Bar"),
+			Shell:        proto.String("/bin/sh"),
+			Locked:       proto.Bool(true),
+			Capabilities: []pb.Capability{pb.Capability_GLOBAL_ROOT},
+			Groups:       []string{"b", "a"},
+			Keys:         []string{"SSH:key"},
+			UntypedMeta:  []string{"site:a:b", "flag"},
+		},
+	}
+	if err := x.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := x.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, e) {
+		t.Errorf("Entity did not round trip: got %v; want %v", got, e)
+	}
+
+	// An entity without metadata stays that way.
+	bare := &pb.Entity{ID: proto.String("bare")}
+	if err := x.SaveEntity(bare); err != nil {
+		t.Fatal(err)
+	}
+	got, err = x.LoadEntity("bare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, bare) {
+		t.Errorf("Entity did not round trip: got %v; want %v", got, bare)
+	}
+
+	g := &pb.Group{
+		Name:         proto.String("grp"),
+		DisplayName:  proto.String("Group"),
+		Number:       proto.Int32(7),
+		ManagedBy:    proto.String("grp"),
+		Expansions:   []string{"INCLUDE:a", "EXCLUDE:b"},
+		Capabilities: []pb.Capability{pb.Capability_CREATE_ENTITY},
+		UntypedMeta:  []string{"site:a"},
+		Revision:     proto.Uint64(3),
+	}
+	if err := x.SaveGroup(g); err != nil {
+		t.Fatal(err)
+	}
+	gotg, err := x.LoadGroup("grp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(gotg, g) {
+		t.Errorf("Group did not round trip: got %v; want %v", gotg, g)
+	}
+}
+
+func TestRelationalSchema(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+	x := newTestDB(t, dir)
+	defer x.Close()
+
+	e := &pb.Entity{
+		ID: proto.String("foo"),
+		Meta: &pb.EntityMeta{
+			Groups:       []string{"a", "b"},
+			Capabilities: []pb.Capability{pb.Capability_GLOBAL_ROOT},
+			Keys:         []string{"SSH:key"},
+			UntypedMeta:  []string{"site:a"},
+		},
+	}
+	if err := x.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+	g := &pb.Group{
+		Name:         proto.String("a"),
+		Expansions:   []string{"INCLUDE:b"},
+		Capabilities: []pb.Capability{pb.Capability_CREATE_ENTITY},
+		UntypedMeta:  []string{"site:b"},
+	}
+	if err := x.SaveGroup(g); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{
+		"SELECT COUNT(*) FROM entity_capabilities WHERE entity_id = 'foo' AND capability = 'GLOBAL_ROOT'",
+		"SELECT COUNT(*) FROM entity_keys WHERE entity_id = 'foo' AND public_key = 'SSH:key'",
+		"SELECT COUNT(*) FROM entity_meta WHERE entity_id = 'foo' AND name = 'site' AND value = 'a'",
+		"SELECT COUNT(*) FROM group_capabilities WHERE group_name = 'a' AND capability = 'CREATE_ENTITY'",
+		"SELECT COUNT(*) FROM group_meta WHERE group_name = 'a' AND name = 'site' AND value = 'b'",
+	} {
+		var n int
+		if err := x.db.QueryRow(q).Scan(&n); err != nil || n != 1 {
+			t.Errorf("%s: %d %v", q, n, err)
+		}
+	}
+
+	var n int
+	if err := x.db.QueryRow("SELECT COUNT(*) FROM memberships WHERE group_name = 'b'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Membership not stored relationally: %d %v", n, err)
+	}
+	var mode string
+	if err := x.db.QueryRow("SELECT mode FROM expansions WHERE parent = 'a' AND child = 'b'").Scan(&mode); err != nil || mode != "INCLUDE" {
+		t.Errorf("Expansion not stored relationally: %s %v", mode, err)
+	}
+
+	// Memberships and the like go away with the entity.
+	if err := x.DeleteEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"memberships", "entity_capabilities", "entity_keys", "entity_meta"} {
+		if err := x.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil || n != 0 {
+			t.Errorf("Rows left behind in %s: %d %v", table, n, err)
+		}
+	}
+
+	// Malformed expansions are refused, and leave nothing behind.
+	bad := &pb.Group{Name: proto.String("bad"), Expansions: []string{"nocolon"}}
+	if err := x.SaveGroup(bad); err != db.ErrInternalError {
+		t.Error(err)
+	}
+	if _, err := x.LoadGroup("bad"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+}
+
+func TestLegacyExtraColumn(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+	x := newTestDB(t, dir)
+	defer x.Close()
+
+	// Before version 2 capabilities, keys and untyped meta were
+	// only kept in the extra column.
+	e := &pb.Entity{
+		ID: proto.String("foo"),
+		Meta: &pb.EntityMeta{
+			Capabilities: []pb.Capability{pb.Capability_GLOBAL_ROOT},
+			Keys:         []string{"SSH:key"},
+			UntypedMeta:  []string{"site:a"},
+		},
+	}
+	extra, err := proto.Marshal(&pb.Entity{Meta: e.Meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.db.Exec("INSERT INTO entities (id, extra) VALUES ('foo', ?)", extra); err != nil {
+		t.Fatal(err)
+	}
+	g := &pb.Group{
+		Name:         proto.String("grp"),
+		Capabilities: []pb.Capability{pb.Capability_CREATE_ENTITY},
+		UntypedMeta:  []string{"site:b"},
+	}
+	extra, err = proto.Marshal(&pb.Group{Capabilities: g.Capabilities, UntypedMeta: g.UntypedMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.db.Exec("INSERT INTO groups (name, extra) VALUES ('grp', ?)", extra); err != nil {
+		t.Fatal(err)
+	}
+
+	// They are read from there, and move to the tables when the
+	// record is next saved.
+	for i := 0; i < 2; i++ {
+		got, err := x.LoadEntity("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(got, e) {
+			t.Errorf("%d: Wrong entity: got %v; want %v", i, got, e)
+		}
+		if err := x.SaveEntity(got); err != nil {
+			t.Fatal(err)
+		}
+
+		gotg, err := x.LoadGroup("grp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(gotg, g) {
+			t.Errorf("%d: Wrong group: got %v; want %v", i, gotg, g)
+		}
+		if err := x.SaveGroup(gotg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int
+	if err := x.db.QueryRow("SELECT COUNT(*) FROM entity_capabilities WHERE entity_id = 'foo'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Capabilities were not moved: %d %v", n, err)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+
+	x := newTestDB(t, dir)
+	if err := x.SaveEntity(&pb.Entity{ID: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := schemaVersion(x.db); err != nil || v != len(migrations) {
+		t.Errorf("Wrong schema version: %d %v", v, err)
+	}
+	x.Close()
+
+	// Reopening applies nothing and keeps the data.
+	x = newTestDB(t, dir)
+	if _, err := x.LoadEntity("foo"); err != nil {
+		t.Error(err)
+	}
+
+	// A schema from the future is refused.
+	if _, err := x.db.Exec("UPDATE schema_version SET version = ?", len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if r := x.healthCheck(); r.OK {
+		t.Error("Health check passed with wrong schema version")
+	}
+	x.Close()
+	if _, err := New(); err != db.ErrInternalError {
+		t.Error(err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	dir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(dir, t)
+	x := newTestDB(t, dir)
+
+	if r := x.healthCheck(); !r.OK {
+		t.Errorf("Health check failed: %s", r.Status)
+	}
+	x.Close()
+	if r := x.healthCheck(); r.OK {
+		t.Error("Health check passed on closed database")
+	}
+}
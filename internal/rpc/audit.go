@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// audit records a change made through the server.  before and after
+// are the record that was changed as it was on either side of the
+// change, either may be nil.  Changes that failed are recorded as
+// well, along with the reason.
+func (s *NetAuthServer) audit(action string, client *pb.ClientInfo, actor, target string, before, after proto.Message, err error) {
+	if s.Audit == nil {
+		return
+	}
+
+	r := &pb.AuditRecord{
+		Actor:    proto.String(actor),
+		ClientID: proto.String(client.GetID()),
+		Service:  proto.String(client.GetService()),
+		Action:   proto.String(action),
+		Target:   proto.String(target),
+		Changes:  audit.Diff(before, after),
+	}
+	if err != nil {
+		r.Error = proto.String(err.Error())
+	}
+	s.Audit.Record(r)
+}
+
+// entitySnapshot returns an entity as it is now so that changes to it
+// can be audited.  It is nil if the entity doesn't exist, or if
+// nothing is being audited.
+func (s *NetAuthServer) entitySnapshot(ID string) *pb.Entity {
+	if s.Audit == nil {
+		return nil
+	}
+	e, err := s.Tree.GetEntity(ID)
+	if err != nil {
+		return nil
+	}
+	return e
+}
+
+// groupSnapshot returns a group as it is now so that changes to it
+// can be audited.  It is nil if the group doesn't exist, or if
+// nothing is being audited.
+func (s *NetAuthServer) groupSnapshot(name string) *pb.Group {
+	if s.Audit == nil {
+		return nil
+	}
+	g, err := s.Tree.GetGroupByName(name)
+	if err != nil {
+		return nil
+	}
+	return g
+}
+
+// QueryAudit returns the audit records that match the request, oldest
+// first.  The records show the changes made to every entity and
+// group, so this action requires GLOBAL_ROOT.
+func (s *NetAuthServer) QueryAudit(ctx context.Context, r *pb.QueryAuditRequest) (*pb.AuditRecordList, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	if s.Audit == nil {
+		return nil, status.Errorf(codes.Unimplemented, "This server does not keep an audit log")
+	}
+
+	f := audit.Filter{
+		Actor:  r.GetActor(),
+		Target: r.GetTarget(),
+		Action: r.GetAction(),
+		Limit:  int(r.GetLimit()),
+	}
+	if r.GetSince() != 0 {
+		f.Since = time.Unix(r.GetSince(), 0)
+	}
+	if r.GetUntil() != 0 {
+		f.Until = time.Unix(r.GetUntil(), 0)
+	}
+
+	records, err := s.Audit.Query(f)
+	if err != nil {
+		return nil, toWireError(err)
+	}
+
+	log.Printf("Audit query returned %d records to %s (%s@%s)",
+		len(records),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.AuditRecordList{Records: records}, toWireError(nil)
+}
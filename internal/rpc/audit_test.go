@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// memoryAuditLog keeps the records it is given.
+type memoryAuditLog []*pb.AuditRecord
+
+func (l *memoryAuditLog) Record(r *pb.AuditRecord)                      { *l = append(*l, r) }
+func (l *memoryAuditLog) Query(audit.Filter) ([]*pb.AuditRecord, error) { return *l, nil }
+
+// change returns the change made to the named field, if there was one.
+func change(r *pb.AuditRecord, field string) *pb.AuditChange {
+	for _, c := range r.GetChanges() {
+		if c.GetField() == field {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRenameIsAudited(t *testing.T) {
+	s := newTestServer(t)
+	l := &memoryAuditLog{}
+	s.Audit = l
+	ctx := context.WithValue(context.Background(), claimsKey{}, token.Claims{EntityID: "root"})
+	info := &pb.ClientInfo{ID: proto.String("host"), Service: proto.String("test")}
+
+	if _, err := s.RenameEntity(ctx, &pb.RenameRequest{
+		Info:    info,
+		Name:    proto.String("alice"),
+		NewName: proto.String("alicia"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RenameGroup(ctx, &pb.RenameRequest{
+		Info:    info,
+		Name:    proto.String("team"),
+		NewName: proto.String("crew"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		action, target, field, after string
+	}{
+		{"RenameEntity", "alice", "ID", "alicia"},
+		{"RenameGroup", "team", "Name", "crew"},
+	}
+	if len(*l) != len(cases) {
+		t.Fatalf("Got %d audit records; Want %d", len(*l), len(cases))
+	}
+	for i, c := range cases {
+		r := (*l)[i]
+		if r.GetAction() != c.action || r.GetTarget() != c.target || r.GetActor() != "root" || r.GetClientID() != "host" {
+			t.Errorf("%d: Wrong record: %v", i, r)
+		}
+		if ch := change(r, c.field); ch.GetBefore() != c.target || ch.GetAfter() != c.after {
+			t.Errorf("%d: Rename not recorded: %v", i, r.GetChanges())
+		}
+	}
+}
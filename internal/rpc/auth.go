@@ -3,10 +3,12 @@ package rpc
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
 	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/NetAuth/NetAuth/internal/tree"
 
 	pb "github.com/NetAuth/Protocol"
 )
@@ -60,56 +62,167 @@ func (s *NetAuthServer) GetToken(ctx context.Context, r *pb.NetAuthRequest) (*pb
 		return nil, toWireError(ErrInternalError)
 	}
 
-	// First get the capabilities that are provided by the entity
-	// itself.
-	caps := make(map[string]int)
-	if e.GetMeta() != nil {
-		for _, c := range e.GetMeta().GetCapabilities() {
-			caps[pb.Capability_name[int32(c)]]++
-		}
+	// Successfully authenticated, now to construct a token
+	cfg := token.GetConfig()
+	claims := token.Claims{
+		EntityID:     e.GetID(),
+		Capabilities: s.getCapabilities(e),
+		RenewalsLeft: cfg.Renewals,
 	}
 
-	// Next get the capabilities that are provided by any groups
-	// the entity may be in; include indirects for authentication
-	// queries.
-	groupNames := s.Tree.GetMemberships(e, true)
-	for _, name := range groupNames {
-		g, err := s.Tree.GetGroupByName(name)
-		if err != nil {
-			log.Printf("Error loading group: %s", err)
-			continue
-		}
-		for _, c := range g.GetCapabilities() {
-			caps[pb.Capability_name[int32(c)]]++
-		}
+	// Generate the token with the specified claims
+	tkn, err := s.Token.Generate(claims, cfg)
+	if err != nil {
+		return nil, toWireError(err)
 	}
 
-	// Flatten the capabilities out into a list
-	var capabilities []string
-	for c := range caps {
-		capabilities = append(capabilities, c)
+	// Construct the reply containing the token
+	reply := pb.TokenResult{
+		Success: proto.Bool(true),
+		Msg:     proto.String("Token Granted"),
+		Token:   &tkn,
+	}
+
+	return &reply, toWireError(nil)
+}
+
+// RenewToken takes a valid token and issues a fresh one in its place
+// without the entity needing to present its secret again.  The
+// capabilities are worked out again from scratch so that the new
+// token reflects any changes since the old one was issued.  Each
+// renewal uses up one of the renewals the original token was issued
+// with.
+func (s *NetAuthServer) RenewToken(ctx context.Context, r *pb.NetAuthRequest) (*pb.TokenResult, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	log.Printf("Token renewal requested for %s (%s@%s)",
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	if c.RenewalsLeft <= 0 {
+		return nil, toWireError(token.ErrNoRenewalsLeft)
+	}
+
+	// The entity may have changed a great deal since the token
+	// was issued, so look at it again.
+	e, err := s.Tree.GetEntity(c.EntityID)
+	if err != nil {
+		return nil, toWireError(err)
+	}
+	if e.GetMeta().GetLocked() {
+		return nil, toWireError(tree.ErrEntityLocked)
+	}
+	if exp := e.GetSecretExpires(); exp != 0 && exp < time.Now().Unix() {
+		return nil, toWireError(tree.ErrSecretExpired)
 	}
 
-	// Successfully authenticated, now to construct a token
 	claims := token.Claims{
 		EntityID:     e.GetID(),
-		Capabilities: capabilities,
+		Capabilities: s.getCapabilities(e),
+		RenewalsLeft: c.RenewalsLeft - 1,
 	}
 
-	// Generate the token with the specified claims
 	tkn, err := s.Token.Generate(claims, token.GetConfig())
 	if err != nil {
 		return nil, toWireError(err)
 	}
 
-	// Construct the reply containing the token
-	reply := pb.TokenResult{
+	return &pb.TokenResult{
 		Success: proto.Bool(true),
-		Msg:     proto.String("Token Granted"),
+		Msg:     proto.String("Token Renewed"),
 		Token:   &tkn,
+	}, toWireError(nil)
+}
+
+// ImportSecret sets the secret of an entity to a hash that was
+// secured outside of NetAuth.  This is meant for migrating entities
+// from other systems without knowing their secrets, and requires the
+// same capability as administratively changing a secret.
+func (s *NetAuthServer) ImportSecret(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+	e := r.GetEntity()
+
+	c := claimsFrom(ctx)
+
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.ImportEntitySecretByID(e.GetID(), e.GetSecret())
+	s.audit("ImportSecret", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
+		return nil, toWireError(err)
 	}
 
-	return &reply, toWireError(nil)
+	log.Printf("Secret for %s imported by %s (%s@%s)",
+		e.GetID(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+	return &pb.SimpleResult{
+		Success: proto.Bool(true),
+		Msg:     proto.String("Secret Imported"),
+	}, toWireError(nil)
+}
+
+// RevokeToken revokes the token that is presented.  Anyone holding a
+// valid token may revoke it, which allows a token to be thrown away
+// when it is no longer needed.
+func (s *NetAuthServer) RevokeToken(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	before := s.entitySnapshot(c.EntityID)
+	err := s.Tree.RevokeToken(c.EntityID, c.ID, c.Expires)
+	s.audit("RevokeToken", client, c.EntityID, c.EntityID, before, s.entitySnapshot(c.EntityID), err)
+	if err != nil {
+		return &pb.SimpleResult{
+			Success: proto.Bool(false),
+			Msg:     proto.String("An error occured while revoking the token"),
+		}, toWireError(err)
+	}
+
+	log.Printf("Token for %s revoked (%s@%s)",
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.SimpleResult{
+		Success: proto.Bool(true),
+		Msg:     proto.String("Token revoked"),
+	}, toWireError(nil)
+}
+
+// RevokeAllTokens revokes every token issued to an entity so far.  An
+// entity may do this for itself, otherwise the same capability as
+// locking an entity is required.
+func (s *NetAuthServer) RevokeAllTokens(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+	e := r.GetEntity()
+
+	c := claimsFrom(ctx)
+
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.RevokeAllTokens(e.GetID())
+	s.audit("RevokeAllTokens", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
+		return &pb.SimpleResult{
+			Success: proto.Bool(false),
+			Msg:     proto.String("An error occured while revoking tokens"),
+		}, toWireError(err)
+	}
+
+	log.Printf("All tokens for %s revoked by %s (%s@%s)",
+		e.GetID(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.SimpleResult{
+		Success: proto.Bool(true),
+		Msg:     proto.String("All tokens revoked"),
+	}, toWireError(nil)
 }
 
 // ValidateToken will attempt to determine the validity of a token
@@ -144,62 +257,36 @@ func (s *NetAuthServer) ValidateToken(ctx context.Context, r *pb.NetAuthRequest)
 // must be in possession of the old secret, not a token, to authorize
 // the change.  In the event the request is administrative (the entity
 // is requesting the change of another entity's secret) then the
-// entity must posses a token with the right capability.
+// entity must posses a token with the right capability.  Both of
+// these are checked before the request gets here.
 func (s *NetAuthServer) ChangeSecret(ctx context.Context, r *pb.ModEntityRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	e := r.GetEntity()
 	me := r.GetModEntity()
-	t := r.GetAuthToken()
 
-	modself := false
+	c := claimsFrom(ctx)
 
-	// Determine if this is a self modifying request or not
-	if me != nil && e.GetID() == me.GetID() {
-		modself = true
+	// Change the secret per what was specified in the
+	// modification entity struct.
+	before := s.entitySnapshot(me.GetID())
+	err := s.Tree.SetEntitySecretByID(me.GetID(), me.GetSecret())
+	s.audit("ChangeSecret", client, c.EntityID, me.GetID(), before, s.entitySnapshot(me.GetID()), err)
+	if err != nil {
+		return nil, toWireError(err)
 	}
 
-	// Self modifying requests require the original password to
-	// proceed
-	err := s.Tree.ValidateSecret(e.GetID(), e.GetSecret())
-	if modself && err == nil {
-		err := s.Tree.SetEntitySecretByID(me.GetID(), me.GetSecret())
-		if err != nil {
-			return nil, toWireError(err)
-		}
+	if c.EntityID == me.GetID() {
 		log.Printf("Secret for %s changed (%s@%s)",
 			me.GetID(),
 			client.GetService(),
 			client.GetID())
-		return &pb.SimpleResult{
-			Msg:     proto.String("Secret Changed"),
-			Success: proto.Bool(true),
-		}, toWireError(nil)
-	}
-	if err != nil {
-		// Problem with the password in the self modifying
-		// request, bail out!
-		return nil, toWireError(err)
-	}
-
-	// This change is being done administratively since modself
-	// was false, so this needs a valid token to proceed.
-	c, err := s.Token.Validate(t)
-	if err != nil || !c.HasCapability("CHANGE_ENTITY_SECRET") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
-
-	// Change the secret per what was specified in the
-	// modification entity struct.
-	if err = s.Tree.SetEntitySecretByID(me.GetID(), me.GetSecret()); err != nil {
-		return nil, toWireError(err)
+	} else {
+		// Log this as an administrative change.
+		log.Printf("Secret for %s administratively changed by %s (%s@%s)",
+			me.GetID(),
+			c.EntityID,
+			client.GetService(),
+			client.GetID())
 	}
-
-	// Log this as an administrative change.
-	log.Printf("Secret for %s administratively changed by %s (%s@%s)",
-		me.GetID(),
-		c.EntityID,
-		client.GetService(),
-		client.GetID())
 	return &pb.SimpleResult{
 		Success: proto.Bool(true),
 		Msg:     proto.String("Secret Changed"),
@@ -211,68 +298,38 @@ func (s *NetAuthServer) ChangeSecret(ctx context.Context, r *pb.ModEntityRequest
 // both specified, then the group will be ignored and the modification
 // will be performed on the named entity.
 func (s *NetAuthServer) ManageCapabilities(ctx context.Context, r *pb.ModCapabilityRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
 	entity := r.GetEntity()
 	group := r.GetGroup()
-	t := r.GetAuthToken()
 	mode := r.GetMode()
 	cap := r.GetCapability().String()
 
-	// Validate the token and confirm the holder posses
-	// GLOBAL_ROOT.  You might wonder why there isn't a capability
-	// to assign other capabilities, but then you start going down
-	// the rabbit hole and its much more straightforward to just
-	// say that you need to be a global superuser to be able to
-	// add more capabilities.
-	c, err := s.Token.Validate(t)
-	if err != nil || !c.HasCapability("GLOBAL_ROOT") {
-		return nil, toWireError(ErrRequestorUnqualified)
+	c := claimsFrom(ctx)
+
+	if mode != "ADD" && mode != "REMOVE" {
+		return &pb.SimpleResult{
+			Success: proto.Bool(false),
+			Msg:     proto.String("Mode must be either ADD or REMOVE"),
+		}, toWireError(ErrMalformedRequest)
 	}
 
+	var err error
 	if entity != nil {
-		switch mode {
-		case "ADD":
-			if err := s.Tree.SetEntityCapabilityByID(entity.GetID(), cap); err != nil {
-				return &pb.SimpleResult{
-					Success: proto.Bool(false),
-					Msg:     proto.String("Error while adding capability"),
-				}, toWireError(err)
-			}
-		case "REMOVE":
-			if err := s.Tree.RemoveEntityCapabilityByID(entity.GetID(), cap); err != nil {
-				return &pb.SimpleResult{
-					Success: proto.Bool(false),
-					Msg:     proto.String("Error while removing capability"),
-				}, toWireError(err)
-			}
-		default:
-			return &pb.SimpleResult{
-				Success: proto.Bool(false),
-				Msg:     proto.String("Mode must be either ADD or REMOVE"),
-			}, toWireError(ErrMalformedRequest)
+		before := s.entitySnapshot(entity.GetID())
+		if mode == "ADD" {
+			err = s.Tree.SetEntityCapabilityByID(entity.GetID(), cap)
+		} else {
+			err = s.Tree.RemoveEntityCapabilityByID(entity.GetID(), cap)
 		}
+		s.audit("ManageCapabilities", client, c.EntityID, entity.GetID(), before, s.entitySnapshot(entity.GetID()), err)
 	} else if group != nil {
-		switch mode {
-		case "ADD":
-			if err := s.Tree.SetGroupCapabilityByName(group.GetName(), cap); err != nil {
-				return &pb.SimpleResult{
-					Success: proto.Bool(false),
-					Msg:     proto.String("Error while adding capability"),
-				}, toWireError(err)
-			}
-		case "REMOVE":
-			if err := s.Tree.RemoveGroupCapabilityByName(group.GetName(), cap); err != nil {
-				return &pb.SimpleResult{
-					Success: proto.Bool(false),
-					Msg:     proto.String("Error while removing capability"),
-				}, toWireError(err)
-			}
-		default:
-			return &pb.SimpleResult{
-				Success: proto.Bool(false),
-				Msg:     proto.String("Mode must be either ADD or REMOVE"),
-			}, toWireError(ErrMalformedRequest)
+		before := s.groupSnapshot(group.GetName())
+		if mode == "ADD" {
+			err = s.Tree.SetGroupCapabilityByName(group.GetName(), cap)
+		} else {
+			err = s.Tree.RemoveGroupCapabilityByName(group.GetName(), cap)
 		}
-
+		s.audit("ManageCapabilities", client, c.EntityID, group.GetName(), before, s.groupSnapshot(group.GetName()), err)
 	} else {
 		return &pb.SimpleResult{
 			Success: proto.Bool(false),
@@ -280,6 +337,17 @@ func (s *NetAuthServer) ManageCapabilities(ctx context.Context, r *pb.ModCapabil
 		}, toWireError(ErrMalformedRequest)
 	}
 
+	if err != nil {
+		msg := "Error while adding capability"
+		if mode == "REMOVE" {
+			msg = "Error while removing capability"
+		}
+		return &pb.SimpleResult{
+			Success: proto.Bool(false),
+			Msg:     proto.String(msg),
+		}, toWireError(err)
+	}
+
 	return &pb.SimpleResult{
 		Success: proto.Bool(true),
 		Msg:     proto.String("Capability Modified"),
@@ -291,18 +359,13 @@ func (s *NetAuthServer) ManageCapabilities(ctx context.Context, r *pb.ModCapabil
 func (s *NetAuthServer) LockEntity(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
-	// Verify the correct capability is present in the token.
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-	if !c.HasCapability("LOCK_ENTITY") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.LockEntity(e.GetID()); err != nil {
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.LockEntity(e.GetID())
+	s.audit("LockEntity", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		return &pb.SimpleResult{
 			Success: proto.Bool(false),
 			Msg: proto.String("An error occured while locking"),
@@ -326,18 +389,13 @@ func (s *NetAuthServer) LockEntity(ctx context.Context, r *pb.NetAuthRequest) (*
 func (s *NetAuthServer) UnlockEntity(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
-	// Verify the correct capability is present in the token.
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-	if !c.HasCapability("LOCK_ENTITY") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.UnlockEntity(e.GetID()); err != nil {
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.UnlockEntity(e.GetID())
+	s.audit("UnlockEntity", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		return &pb.SimpleResult{
 			Success: proto.Bool(false),
 			Msg: proto.String("An error occured while locking"),
@@ -355,3 +413,57 @@ func (s *NetAuthServer) UnlockEntity(ctx context.Context, r *pb.NetAuthRequest)
 		Msg: proto.String("Entity is now unlocked"),
 	}, toWireError(nil)
 }
+
+// GetAuthFailures returns the failed authentications that have been
+// recorded against an entity.  Since this is used to investigate
+// lockouts it requires the same capability as locking.
+func (s *NetAuthServer) GetAuthFailures(ctx context.Context, r *pb.NetAuthRequest) (*pb.AuthFailures, error) {
+	client := r.GetInfo()
+	e := r.GetEntity()
+
+	c := claimsFrom(ctx)
+
+	f, err := s.Tree.GetAuthFailures(e.GetID())
+	if err != nil {
+		return nil, toWireError(err)
+	}
+
+	log.Printf("Authentication failures for %s requested by %s (%s@%s)",
+		e.GetID(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return f, toWireError(nil)
+}
+
+// ClearAuthFailures forgets the failed authentications for an entity
+// and ends any temporary lockout.  This action must be authorized
+// with an appropriate token.
+func (s *NetAuthServer) ClearAuthFailures(ctx context.Context, r *pb.NetAuthRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+	e := r.GetEntity()
+
+	c := claimsFrom(ctx)
+
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.ClearAuthFailures(e.GetID())
+	s.audit("ClearAuthFailures", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
+		return &pb.SimpleResult{
+			Success: proto.Bool(false),
+			Msg:     proto.String("An error occured while clearing failures"),
+		}, toWireError(err)
+	}
+
+	log.Printf("Authentication failures for %s cleared by %s (%s@%s)",
+		e.GetID(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.SimpleResult{
+		Success: proto.Bool(true),
+		Msg:     proto.String("Authentication failures cleared"),
+	}, toWireError(nil)
+}
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/backup"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// backupChunkSize is the most archive that is sent in each message.
+const backupChunkSize = 64 * 1024
+
+// Backup streams an archive of every entity and group on the server
+// back to the client, encrypted to the passphrase if one is provided.
+// The archive contains secrets, so this action requires GLOBAL_ROOT.
+// Changes are held off while the archive is taken so that it is
+// consistent.
+func (s *NetAuthServer) Backup(r *pb.BackupRequest, stream pb.NetAuth_BackupServer) error {
+	client := r.GetInfo()
+
+	c := claimsFrom(stream.Context())
+
+	if s.Store == nil {
+		return status.Errorf(codes.Unimplemented, "This server does not provide backups")
+	}
+
+	var a *backup.Archive
+	revision, err := s.Store.View(func(d db.DB) error {
+		var err error
+		a, err = backup.Take(d)
+		return err
+	})
+	s.audit("Backup", client, c.EntityID, "", nil, nil, err)
+	if err != nil {
+		log.Printf("Backup failed: %s", err)
+		return toWireError(ErrInternalError)
+	}
+
+	var buf bytes.Buffer
+	if err := a.Write(&buf, r.GetPassphrase()); err != nil {
+		log.Printf("Backup could not be written: %s", err)
+		return toWireError(ErrInternalError)
+	}
+
+	log.Printf("Backup of %d entities and %d groups at revision %d taken by %s (%s@%s)",
+		len(a.Entities),
+		len(a.Groups),
+		revision,
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	for buf.Len() > 0 {
+		if err := stream.Send(&pb.BackupChunk{Data: buf.Next(backupChunkSize)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
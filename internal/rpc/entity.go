@@ -5,7 +5,6 @@ import (
 	"log"
 	"strings"
 
-	"github.com/NetAuth/NetAuth/internal/token"
 	"github.com/golang/protobuf/proto"
 
 	pb "github.com/NetAuth/Protocol"
@@ -17,19 +16,13 @@ import (
 func (s *NetAuthServer) NewEntity(ctx context.Context, r *pb.ModEntityRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-
-	// Verify the correct capability is present in the token.
-	if !c.HasCapability("CREATE_ENTITY") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.NewEntity(e.GetID(), e.GetNumber(), e.GetSecret()); err != nil {
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.NewEntity(e.GetID(), e.GetNumber(), e.GetSecret())
+	s.audit("NewEntity", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -51,30 +44,57 @@ func (s *NetAuthServer) NewEntity(ctx context.Context, r *pb.ModEntityRequest) (
 func (s *NetAuthServer) RemoveEntity(ctx context.Context, r *pb.ModEntityRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
-	c, err := s.Token.Validate(t)
+	c := claimsFrom(ctx)
+
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.DeleteEntityByID(e.GetID())
+	s.audit("RemoveEntity", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
 	if err != nil {
 		return nil, toWireError(err)
 	}
 
-	// Verify the correct capability is present in the token.
-	if !c.HasCapability("DESTROY_ENTITY") {
-		return nil, toWireError(ErrRequestorUnqualified)
+	log.Printf("Entity '%s' removed by '%s' (%s@%s)",
+		e.GetID(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.SimpleResult{
+		Msg:     proto.String("Entity removed successfully"),
+		Success: proto.Bool(true),
+	}, toWireError(nil)
+}
+
+// RenameEntity changes the ID of an entity while keeping its number,
+// secret, and everything else.  Since this is the same as removing
+// the entity and creating it again it must be authorized by a token
+// that could do both.
+func (s *NetAuthServer) RenameEntity(ctx context.Context, r *pb.RenameRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	if r.GetName() == "" || r.GetNewName() == "" {
+		return nil, toWireError(ErrMalformedRequest)
 	}
 
-	if err := s.Tree.DeleteEntityByID(e.GetID()); err != nil {
+	before := s.entitySnapshot(r.GetName())
+	err := s.Tree.RenameEntity(r.GetName(), r.GetNewName())
+	s.audit("RenameEntity", client, c.EntityID, r.GetName(), before, s.entitySnapshot(r.GetNewName()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
-	log.Printf("Entity '%s' removed by '%s' (%s@%s)",
-		e.GetID(),
+	log.Printf("Entity '%s' renamed to '%s' by '%s' (%s@%s)",
+		r.GetName(),
+		r.GetNewName(),
 		c.EntityID,
 		client.GetService(),
 		client.GetID())
 
 	return &pb.SimpleResult{
-		Msg:     proto.String("Entity removed successfully"),
+		Msg:     proto.String("Entity renamed successfully"),
 		Success: proto.Bool(true),
 	}, toWireError(nil)
 }
@@ -100,23 +120,19 @@ func (s *NetAuthServer) EntityInfo(ctx context.Context, r *pb.NetAuthRequest) (*
 // Entity.  This request must be authorized by a token that contains
 // the correct capabilities to modify others.  Some fields cannot be
 // changed by this mechanism and must be changed via other calls which
-// perform more authorization and validation checks.
+// perform more authorization and validation checks.  If the request
+// carries the revision of the entity it was based on, it is refused
+// when the entity has changed since then.
 func (s *NetAuthServer) ModifyEntityMeta(ctx context.Context, r *pb.ModEntityRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
-
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
 
-	// Verify the correct capability is present in the token.
-	if !c.HasCapability("MODIFY_ENTITY_META") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.UpdateEntityMeta(e.GetID(), e.GetMeta()); err != nil {
+	before := s.entitySnapshot(e.GetID())
+	err := s.Tree.UpdateEntityMeta(e.GetID(), e.GetRevision(), e.GetMeta())
+	s.audit("ModifyEntityMeta", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		log.Printf("Metadata update error: %s", err)
 		return nil, toWireError(err)
 	}
@@ -139,28 +155,19 @@ func (s *NetAuthServer) ModifyEntityMeta(ctx context.Context, r *pb.ModEntityReq
 func (s *NetAuthServer) ModifyEntityKeys(ctx context.Context, r *pb.ModEntityKeyRequest) (*pb.KeyList, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
 	mode := strings.ToUpper(r.GetMode())
 
-	// If we aren't doing a read only operation then we need a
-	// token for this
-	var c token.Claims
-	if mode != "LIST" {
-		c, err := s.Token.Validate(t)
-		if err != nil {
-			return nil, toWireError(err)
-		}
-
-		// Verify the correct capability is present in the token or
-		// that this is not a read only query.
-		if !c.HasCapability("MODIFY_ENTITY_KEYS") {
-			return nil, toWireError(ErrRequestorUnqualified)
-		}
-	}
+	// Read only requests have no claims, since they need no
+	// token.
+	c := claimsFrom(ctx)
 
+	before := s.entitySnapshot(e.GetID())
 	// Get run the transaction on the key database.
 	keys, err := s.Tree.UpdateEntityKeys(e.GetID(), r.GetMode(), r.GetType(), r.GetKey())
+	if mode != "LIST" {
+		s.audit("ModifyEntityKeys", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	}
 	if err != nil {
 		return nil, toWireError(err)
 	}
@@ -186,28 +193,18 @@ func (s *NetAuthServer) ModifyEntityKeys(ctx context.Context, r *pb.ModEntityKey
 func (s *NetAuthServer) ModifyUntypedEntityMeta(ctx context.Context, r *pb.ModEntityMetaRequest) (*pb.UntypedMetaResult, error) {
 	client := r.GetInfo()
 	e := r.GetEntity()
-	t := r.GetAuthToken()
 
 	mode := strings.ToUpper(r.GetMode())
 
-	// If we aren't doing a read only operation then we need a
-	// token for this
-	var c token.Claims
-	var err error
-	if mode != "READ" {
-		c, err = s.Token.Validate(t)
-		if err != nil {
-			return nil, toWireError(err)
-		}
-
-		// Verify the correct capability is present in the token or
-		// that this is not a read only query.
-		if !c.HasCapability("MODIFY_ENTITY_KEYS") {
-			return nil, toWireError(ErrRequestorUnqualified)
-		}
-	}
+	// Read only requests have no claims, since they need no
+	// token.
+	c := claimsFrom(ctx)
 
+	before := s.entitySnapshot(e.GetID())
 	meta, err := s.Tree.ManageUntypedEntityMeta(e.GetID(), r.GetMode(), r.GetKey(), r.GetValue())
+	if mode != "READ" {
+		s.audit("ModifyUntypedEntityMeta", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	}
 	if err != nil {
 		return nil, toWireError(err)
 	}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+	"log"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// Fsck checks the tree for references to groups that don't exist and
+// other inconsistencies, and optionally repairs the ones that can be
+// repaired safely.  As this can touch any record on the server it
+// requires GLOBAL_ROOT.
+func (s *NetAuthServer) Fsck(ctx context.Context, r *pb.FsckRequest) (*pb.FsckResult, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	problems, err := s.Tree.Fsck(r.GetRepair())
+	if err != nil {
+		if r.GetRepair() {
+			s.audit("Fsck", client, c.EntityID, "", nil, nil, err)
+		}
+		return nil, toWireError(err)
+	}
+
+	// Each record that was repaired is audited.
+	for _, p := range problems {
+		if p.GetRepaired() {
+			s.audit("Fsck", client, c.EntityID, p.GetRecord(), nil, nil, nil)
+		}
+	}
+
+	log.Printf("Consistency check (repair: %t) found %d problems, requested by %s (%s@%s)",
+		r.GetRepair(),
+		len(problems),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.FsckResult{Problems: problems}, toWireError(nil)
+}
@@ -7,8 +7,6 @@ import (
 
 	"github.com/golang/protobuf/proto"
 
-	"github.com/NetAuth/NetAuth/internal/token"
-
 	pb "github.com/NetAuth/Protocol"
 )
 
@@ -17,20 +15,14 @@ import (
 // appropriate capabilities.
 func (s *NetAuthServer) NewGroup(ctx context.Context, r *pb.ModGroupRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	g := r.GetGroup()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-
-	// Verify the correct capability is present in the token.
-	if !c.HasCapability("CREATE_GROUP") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.NewGroup(g.GetName(), g.GetDisplayName(), g.GetManagedBy(), g.GetNumber()); err != nil {
+	before := s.groupSnapshot(g.GetName())
+	err := s.Tree.NewGroup(g.GetName(), g.GetDisplayName(), g.GetManagedBy(), g.GetNumber())
+	s.audit("NewGroup", client, c.EntityID, g.GetName(), before, s.groupSnapshot(g.GetName()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -48,38 +40,66 @@ func (s *NetAuthServer) NewGroup(ctx context.Context, r *pb.ModGroupRequest) (*p
 
 // DeleteGroup removes a group from the NetAuth server.  This action
 // must be authorized by the presentation of a token containing
-// appropriate capabilities.  This call will not CASCADE deletes and
-// will not check if the group is empty before proceeding.  Other
-// methods *should* safely handle this and check that they aren't
-// pointing to a group that doesn't exist anymore, but its still good
-// form to clean up references before calling this action.
+// appropriate capabilities.  The group is not removed while entities
+// or other groups refer to it, and the references are returned in
+// the error.  If the request is marked as forced the references are
+// removed along with the group.
 func (s *NetAuthServer) DeleteGroup(ctx context.Context, r *pb.ModGroupRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	g := r.GetGroup()
 
-	c, err := s.Token.Validate(t)
+	c := claimsFrom(ctx)
+
+	before := s.groupSnapshot(g.GetName())
+	err := s.Tree.DeleteGroup(g.GetName(), r.GetForce())
+	s.audit("DeleteGroup", client, c.EntityID, g.GetName(), before, s.groupSnapshot(g.GetName()), err)
 	if err != nil {
 		return nil, toWireError(err)
 	}
 
-	// Verify the correct capability is present in the token.
-	if !c.HasCapability("DESTROY_GROUP") {
-		return nil, toWireError(ErrRequestorUnqualified)
+	log.Printf("Group '%s' removed (force: %t) by '%s' (%s@%s)",
+		g.GetName(),
+		r.GetForce(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	return &pb.SimpleResult{
+		Msg:     proto.String("Group removed successfully"),
+		Success: proto.Bool(true),
+	}, toWireError(nil)
+}
+
+// RenameGroup changes the name of a group while keeping its number
+// and everything else.  Memberships, expansions, and anything else
+// that refers to the group are changed to the new name as well.
+// Since this is the same as deleting the group and creating it again
+// it must be authorized by a token that could do both.
+func (s *NetAuthServer) RenameGroup(ctx context.Context, r *pb.RenameRequest) (*pb.SimpleResult, error) {
+	client := r.GetInfo()
+
+	c := claimsFrom(ctx)
+
+	if r.GetName() == "" || r.GetNewName() == "" {
+		return nil, toWireError(ErrMalformedRequest)
 	}
 
-	if err := s.Tree.DeleteGroup(g.GetName()); err != nil {
+	before := s.groupSnapshot(r.GetName())
+	err := s.Tree.RenameGroup(r.GetName(), r.GetNewName())
+	s.audit("RenameGroup", client, c.EntityID, r.GetName(), before, s.groupSnapshot(r.GetNewName()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
-	log.Printf("Group '%s' removed by '%s' (%s@%s)",
-		g.GetName(),
+	log.Printf("Group '%s' renamed to '%s' by '%s' (%s@%s)",
+		r.GetName(),
+		r.GetNewName(),
 		c.EntityID,
 		client.GetService(),
 		client.GetID())
 
 	return &pb.SimpleResult{
-		Msg:     proto.String("Group removed successfully"),
+		Msg:     proto.String("Group renamed successfully"),
 		Success: proto.Bool(true),
 	}, toWireError(nil)
 }
@@ -119,25 +139,18 @@ func (s *NetAuthServer) GroupInfo(ctx context.Context, r *pb.ModGroupRequest) (*
 // must use more specialized calls which perform additional
 // authorization and validation checks.  This action must be
 // authorized by the presentation of a token containing appropriate
-// capabilities.
+// capabilities.  If the request carries the revision of the group it
+// was based on, it is refused when the group has changed since then.
 func (s *NetAuthServer) ModifyGroupMeta(ctx context.Context, r *pb.ModGroupRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	g := r.GetGroup()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
+	c := claimsFrom(ctx)
 
-	// Either the entity must posses the right capability, or they
-	// must be in the a group that is permitted to manage this one
-	// based on membership.  Either is sufficient.
-	if !s.manageByMembership(c.EntityID, g.GetName()) && !c.HasCapability("MODIFY_GROUP_META") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
-
-	if err := s.Tree.UpdateGroupMeta(g.GetName(), g); err != nil {
+	before := s.groupSnapshot(g.GetName())
+	err := s.Tree.UpdateGroupMeta(g.GetName(), g.GetRevision(), g)
+	s.audit("ModifyGroupMeta", client, c.EntityID, g.GetName(), before, s.groupSnapshot(g.GetName()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -159,28 +172,18 @@ func (s *NetAuthServer) ModifyGroupMeta(ctx context.Context, r *pb.ModGroupReque
 func (s *NetAuthServer) ModifyUntypedGroupMeta(ctx context.Context, r *pb.ModGroupMetaRequest) (*pb.UntypedMetaResult, error) {
 	client := r.GetInfo()
 	g := r.GetGroup()
-	t := r.GetAuthToken()
 
 	mode := strings.ToUpper(r.GetMode())
 
-	// If we aren't doing a read only operation then we need a
-	// token for this
-	var c token.Claims
-	var err error
-	if mode != "READ" {
-		c, err = s.Token.Validate(t)
-		if err != nil {
-			return nil, toWireError(err)
-		}
-
-		// Verify the correct capability is present in the token or
-		// that this is not a read only query.
-		if !c.HasCapability("MODIFY_ENTITY_KEYS") {
-			return nil, toWireError(ErrRequestorUnqualified)
-		}
-	}
+	// Read only requests have no claims, since they need no
+	// token.
+	c := claimsFrom(ctx)
 
+	before := s.groupSnapshot(g.GetName())
 	meta, err := s.Tree.ManageUntypedGroupMeta(g.GetName(), r.GetMode(), r.GetKey(), r.GetValue())
+	if mode != "READ" {
+		s.audit("ModifyUntypedGroupMeta", client, c.EntityID, g.GetName(), before, s.groupSnapshot(g.GetName()), err)
+	}
 	if err != nil {
 		return nil, toWireError(err)
 	}
@@ -1,15 +1,23 @@
 package rpc
 
 import (
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/audit"
 	"github.com/NetAuth/NetAuth/internal/crypto"
 	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/changefeed"
 	"github.com/NetAuth/NetAuth/internal/token"
 	"github.com/NetAuth/NetAuth/internal/tree"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	pb "github.com/NetAuth/Protocol"
 )
 
+// manageByMembership reports whether an entity may manage a group by
+// virtue of being a member of the group that manages it.
 func (s *NetAuthServer) manageByMembership(entityID, groupName string) bool {
 	g, err := s.Tree.GetGroupByName(groupName)
 	if err != nil {
@@ -40,7 +48,7 @@ func (s *NetAuthServer) manageByMembership(entityID, groupName string) bool {
 	// Check if any of the groups are the one that grants this
 	// power
 	for _, name := range groups {
-		if name == groupName {
+		if name == g.GetManagedBy() {
 			return true
 		}
 	}
@@ -49,6 +57,41 @@ func (s *NetAuthServer) manageByMembership(entityID, groupName string) bool {
 	return false
 }
 
+// getCapabilities computes the capabilities an entity holds, either
+// directly or by way of any groups it is a member of.
+func (s *NetAuthServer) getCapabilities(e *pb.Entity) []string {
+	// First get the capabilities that are provided by the entity
+	// itself.
+	caps := make(map[string]int)
+	if e.GetMeta() != nil {
+		for _, c := range e.GetMeta().GetCapabilities() {
+			caps[pb.Capability_name[int32(c)]]++
+		}
+	}
+
+	// Next get the capabilities that are provided by any groups
+	// the entity may be in; include indirects for authentication
+	// queries.
+	groupNames := s.Tree.GetMemberships(e, true)
+	for _, name := range groupNames {
+		g, err := s.Tree.GetGroupByName(name)
+		if err != nil {
+			log.Printf("Error loading group: %s", err)
+			continue
+		}
+		for _, c := range g.GetCapabilities() {
+			caps[pb.Capability_name[int32(c)]]++
+		}
+	}
+
+	// Flatten the capabilities out into a list
+	var capabilities []string
+	for c := range caps {
+		capabilities = append(capabilities, c)
+	}
+	return capabilities
+}
+
 // toWireError maps from all of NetAuth's internal errors to canonical
 // error codes in gRPC.  This makes interfacing with NetAuth much
 // easier for other developers since there is a clear understanding of
@@ -60,6 +103,15 @@ func (s *NetAuthServer) manageByMembership(entityID, groupName string) bool {
 // implementation back to the client from things kicking up out of
 // lower levels.  We'll just use the prepared strings instead.
 func toWireError(err error) error {
+	// Policy refusals carry a reason that is meant for the user,
+	// so it is passed back as is.  A secret that the secret
+	// policy refuses is treated the same as any other change that
+	// a hook refuses.
+	switch err.(type) {
+	case *tree.PolicyError, *tree.SecretPolicyError, *tree.ReferenceError:
+		return status.Errorf(codes.FailedPrecondition, err.Error())
+	}
+
 	switch err {
 	case nil:
 		return status.Errorf(codes.OK, "Completed successfully")
@@ -71,10 +123,18 @@ func toWireError(err error) error {
 		return status.Errorf(codes.NotFound, err.Error())
 	case db.ErrUnknownGroup:
 		return status.Errorf(codes.NotFound, err.Error())
+	case audit.ErrNotQueryable:
+		return status.Errorf(codes.Unimplemented, err.Error())
+	case changefeed.ErrRevisionUnavailable:
+		return status.Errorf(codes.OutOfRange, err.Error())
+	case changefeed.ErrWatcherFellBehind:
+		return status.Errorf(codes.Aborted, err.Error())
 	case token.ErrKeyUnavailable:
 		return status.Errorf(codes.FailedPrecondition, err.Error())
 	case token.ErrTokenInvalid:
 		return status.Errorf(codes.Unauthenticated, err.Error())
+	case token.ErrNoRenewalsLeft:
+		return status.Errorf(codes.PermissionDenied, err.Error())
 	case tree.ErrDuplicateEntityID:
 		return status.Errorf(codes.AlreadyExists, err.Error())
 	case tree.ErrDuplicateGroupName:
@@ -87,6 +147,12 @@ func toWireError(err error) error {
 		return status.Errorf(codes.AlreadyExists, err.Error())
 	case tree.ErrEntityLocked:
 		return status.Errorf(codes.FailedPrecondition, err.Error())
+	case tree.ErrSecretExpired:
+		return status.Errorf(codes.FailedPrecondition, err.Error())
+	case tree.ErrStaleRevision:
+		return status.Errorf(codes.Aborted, err.Error())
+	case tree.ErrUnknownSecretFormat:
+		return status.Errorf(codes.InvalidArgument, err.Error())
 	case ErrMalformedRequest:
 		return status.Errorf(codes.InvalidArgument, err.Error())
 	case ErrRequestorUnqualified:
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/NetAuth/NetAuth/internal/tree"
+
+	"google.golang.org/grpc"
+)
+
+// claimsKey is the key that the claims of an authorized caller are
+// stored under in the context passed to a handler.
+type claimsKey struct{}
+
+// claimsFrom returns the claims of the caller that were established
+// while authorizing the request.  Public and read only requests have
+// no claims, in which case the zero value is returned.
+func claimsFrom(ctx context.Context) token.Claims {
+	c, _ := ctx.Value(claimsKey{}).(token.Claims)
+	return c
+}
+
+// authorize checks a request against the policy for its method.  If
+// the request is allowed the returned context carries the claims of
+// the caller for the handler to use.
+func (s *NetAuthServer) authorize(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	p, ok := policies[method[strings.LastIndex(method, "/")+1:]]
+	if !ok {
+		log.Printf("Refusing call to %s which has no policy", method)
+		return ctx, ErrRequestorUnqualified
+	}
+
+	if p.Public || (p.ReadOnly != nil && p.ReadOnly(req)) {
+		return ctx, nil
+	}
+
+	if p.SelfSecret != nil {
+		// An entity acting on itself may present its own
+		// secret instead of a token.  An expired secret is
+		// still good enough to replace itself.
+		id := requestEntity(req)
+		err := s.Tree.ValidateSecret(id, requestSecret(req))
+		if id != "" && id == p.SelfSecret(req) && (err == nil || err == tree.ErrSecretExpired) {
+			return context.WithValue(ctx, claimsKey{}, token.Claims{EntityID: id}), nil
+		}
+		if err != nil {
+			return ctx, err
+		}
+	}
+
+	c, err := s.Token.Validate(requestToken(req))
+	if err != nil {
+		return ctx, err
+	}
+
+	switch {
+	case p.Self != nil && p.Self(req) == c.EntityID:
+	case p.Delegated != nil && s.manageByMembership(c.EntityID, p.Delegated(req)):
+	default:
+		for _, capability := range p.Capabilities {
+			if !c.HasCapability(capability) {
+				return ctx, ErrRequestorUnqualified
+			}
+		}
+	}
+	return context.WithValue(ctx, claimsKey{}, c), nil
+}
+
+// UnaryInterceptor authorizes each request against the policy for
+// its method before it is passed to the handler.
+func (s *NetAuthServer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, toWireError(err)
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authorizes the request that opens a stream
+// before the stream handler gets to see it.
+func (s *NetAuthServer) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &authorizedStream{
+		ServerStream: ss,
+		s:            s,
+		method:       info.FullMethod,
+		ctx:          ss.Context(),
+	})
+}
+
+// An authorizedStream authorizes each message as it is received and
+// makes the claims of the caller available from its context.
+type authorizedStream struct {
+	grpc.ServerStream
+
+	s      *NetAuthServer
+	method string
+	ctx    context.Context
+}
+
+// Context returns the context of the stream, which carries the
+// claims of the caller once the request has been authorized.
+func (a *authorizedStream) Context() context.Context {
+	return a.ctx
+}
+
+// RecvMsg receives a message and refuses it if it is not authorized.
+func (a *authorizedStream) RecvMsg(m interface{}) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	ctx, err := a.s.authorize(a.ServerStream.Context(), a.method, m)
+	if err != nil {
+		return toWireError(err)
+	}
+	a.ctx = ctx
+	return nil
+}
@@ -15,24 +15,16 @@ import (
 // the presentation of a token containing the appropriate capability.
 func (s *NetAuthServer) AddEntityToGroup(ctx context.Context, r *pb.ModEntityMembershipRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	g := r.GetGroup()
 	e := r.GetEntity()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-
-	// Either the entity must posses the right capability, or they
-	// must be in the a group that is permitted to manage this one
-	// based on membership.  Either is sufficient.
-	if !s.manageByMembership(c.EntityID, g.GetName()) && !c.HasCapability("MODIFY_GROUP_MEMBERS") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
+	before := s.entitySnapshot(e.GetID())
 	// Add to the group
-	if err := s.Tree.AddEntityToGroup(e.GetID(), g.GetName()); err != nil {
+	err := s.Tree.AddEntityToGroup(e.GetID(), g.GetName())
+	s.audit("AddEntityToGroup", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -54,24 +46,16 @@ func (s *NetAuthServer) AddEntityToGroup(ctx context.Context, r *pb.ModEntityMem
 // of a token containing appropriate capabilities.
 func (s *NetAuthServer) RemoveEntityFromGroup(ctx context.Context, r *pb.ModEntityMembershipRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	g := r.GetGroup()
 	e := r.GetEntity()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-
-	// Either the entity must posses the right capability, or they
-	// must be in the a group that is permitted to manage this one
-	// based on membership.  Either is sufficient.
-	if !s.manageByMembership(c.EntityID, g.GetName()) && !c.HasCapability("MODIFY_GROUP_MEMBERS") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
+	before := s.entitySnapshot(e.GetID())
 	// Remove from the group
-	if err := s.Tree.RemoveEntityFromGroup(e.GetID(), g.GetName()); err != nil {
+	err := s.Tree.RemoveEntityFromGroup(e.GetID(), g.GetName())
+	s.audit("RemoveEntityFromGroup", client, c.EntityID, e.GetID(), before, s.entitySnapshot(e.GetID()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -151,24 +135,16 @@ func (s *NetAuthServer) ListGroupMembers(ctx context.Context, r *pb.GroupMemberR
 // expansion would not create a cycle in the membership graph.
 func (s *NetAuthServer) ModifyGroupNesting(ctx context.Context, r *pb.ModGroupNestingRequest) (*pb.SimpleResult, error) {
 	client := r.GetInfo()
-	t := r.GetAuthToken()
 	parent := r.GetParentGroup()
 	child := r.GetChildGroup()
 	mode := r.GetMode()
 
-	c, err := s.Token.Validate(t)
-	if err != nil {
-		return nil, toWireError(err)
-	}
-
-	// Either the entity must posses the right capability, or they
-	// must be in the a group that is permitted to manage this one
-	// based on membership.  Either is sufficient.
-	if !s.manageByMembership(c.EntityID, child.GetName()) && !c.HasCapability("MODIFY_GROUP_MEMBERS") {
-		return nil, toWireError(ErrRequestorUnqualified)
-	}
+	c := claimsFrom(ctx)
 
-	if err := s.Tree.ModifyGroupExpansions(parent.GetName(), child.GetName(), mode); err != nil {
+	before := s.groupSnapshot(parent.GetName())
+	err := s.Tree.ModifyGroupExpansions(parent.GetName(), child.GetName(), mode)
+	s.audit("ModifyGroupNesting", client, c.EntityID, parent.GetName(), before, s.groupSnapshot(parent.GetName()), err)
+	if err != nil {
 		return nil, toWireError(err)
 	}
 
@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"strings"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A policy describes what a caller has to present before a request
+// is passed on to its handler.  A request is allowed if it is
+// public, if it only reads, if the caller is acting on itself, if
+// the caller may manage the group involved by way of membership, or
+// if the caller's token contains every one of the capabilities.
+type policy struct {
+	// Public requests need no token at all.
+	Public bool
+
+	// ReadOnly reports whether a particular request only reads
+	// data, in which case it is handled as though it were public.
+	ReadOnly func(interface{}) bool
+
+	// Capabilities are the capabilities that a token must contain
+	// for the request to be allowed.  An empty list allows any
+	// valid token.
+	Capabilities []string
+
+	// Self names the entity a request acts on.  A token issued to
+	// that entity is sufficient even without the capabilities.
+	Self func(interface{}) string
+
+	// SelfSecret names the entity a request acts on when the
+	// request may instead be authorized by presenting that
+	// entity's own secret rather than a token.
+	SelfSecret func(interface{}) string
+
+	// Delegated names the group a request acts on.  Members of
+	// the group that manages it are allowed even without the
+	// capabilities.
+	Delegated func(interface{}) string
+}
+
+// policies maps each method in the NetAuth service to the policy
+// that guards it.  A method that is missing from this table cannot
+// be called at all.
+var policies = map[string]policy{
+	"Ping":             {Public: true},
+	"AuthEntity":       {Public: true},
+	"GetToken":         {Public: true},
+	"ValidateToken":    {Public: true},
+	"EntityInfo":       {Public: true},
+	"GroupInfo":        {Public: true},
+	"ListGroups":       {Public: true},
+	"ListGroupMembers": {Public: true},
+
+	"RenewToken":   {},
+	"RevokeToken":  {},
+	"WatchChanges": {},
+
+	"ChangeSecret": {
+		Capabilities: []string{"CHANGE_ENTITY_SECRET"},
+		SelfSecret:   modEntity,
+	},
+	"ImportSecret": {Capabilities: []string{"CHANGE_ENTITY_SECRET"}},
+	"RevokeAllTokens": {
+		Capabilities: []string{"LOCK_ENTITY"},
+		Self:         requestEntity,
+	},
+
+	// You might wonder why there isn't a capability to assign
+	// other capabilities, but then you start going down the
+	// rabbit hole and its much more straightforward to just say
+	// that you need to be a global superuser to be able to add
+	// more capabilities.
+	"ManageCapabilities": {Capabilities: []string{"GLOBAL_ROOT"}},
+	"Fsck":               {Capabilities: []string{"GLOBAL_ROOT"}},
+	"Backup":             {Capabilities: []string{"GLOBAL_ROOT"}},
+	"QueryAudit":         {Capabilities: []string{"GLOBAL_ROOT"}},
+
+	"LockEntity":        {Capabilities: []string{"LOCK_ENTITY"}},
+	"UnlockEntity":      {Capabilities: []string{"LOCK_ENTITY"}},
+	"GetAuthFailures":   {Capabilities: []string{"LOCK_ENTITY"}},
+	"ClearAuthFailures": {Capabilities: []string{"LOCK_ENTITY"}},
+
+	"NewEntity":        {Capabilities: []string{"CREATE_ENTITY"}},
+	"RemoveEntity":     {Capabilities: []string{"DESTROY_ENTITY"}},
+	"RenameEntity":     {Capabilities: []string{"CREATE_ENTITY", "DESTROY_ENTITY"}},
+	"ModifyEntityMeta": {Capabilities: []string{"MODIFY_ENTITY_META"}},
+	"ModifyEntityKeys": {
+		Capabilities: []string{"MODIFY_ENTITY_KEYS"},
+		ReadOnly:     modeIs("LIST"),
+	},
+	"ModifyUntypedEntityMeta": {
+		Capabilities: []string{"MODIFY_ENTITY_KEYS"},
+		ReadOnly:     modeIs("READ"),
+	},
+
+	"NewGroup":    {Capabilities: []string{"CREATE_GROUP"}},
+	"DeleteGroup": {Capabilities: []string{"DESTROY_GROUP"}},
+	"RenameGroup": {Capabilities: []string{"CREATE_GROUP", "DESTROY_GROUP"}},
+	"ModifyGroupMeta": {
+		Capabilities: []string{"MODIFY_GROUP_META"},
+		Delegated:    requestGroup,
+	},
+	"ModifyUntypedGroupMeta": {
+		Capabilities: []string{"MODIFY_ENTITY_KEYS"},
+		ReadOnly:     modeIs("READ"),
+	},
+
+	"AddEntityToGroup": {
+		Capabilities: []string{"MODIFY_GROUP_MEMBERS"},
+		Delegated:    requestGroup,
+	},
+	"RemoveEntityFromGroup": {
+		Capabilities: []string{"MODIFY_GROUP_MEMBERS"},
+		Delegated:    requestGroup,
+	},
+	"ModifyGroupNesting": {
+		Capabilities: []string{"MODIFY_GROUP_MEMBERS"},
+		Delegated:    parentGroup,
+	},
+}
+
+// The functions below pull the fields that policies care about out
+// of a request.  Requests are matched by the accessors they have
+// rather than by type since many methods share the same fields.
+
+func requestEntity(req interface{}) string {
+	if r, ok := req.(interface{ GetEntity() *pb.Entity }); ok {
+		return r.GetEntity().GetID()
+	}
+	return ""
+}
+
+func modEntity(req interface{}) string {
+	if r, ok := req.(interface{ GetModEntity() *pb.Entity }); ok {
+		return r.GetModEntity().GetID()
+	}
+	return ""
+}
+
+func requestGroup(req interface{}) string {
+	if r, ok := req.(interface{ GetGroup() *pb.Group }); ok {
+		return r.GetGroup().GetName()
+	}
+	return ""
+}
+
+// parentGroup names the group whose expansions a nesting request
+// changes.  The child group is not changed, so those who manage only
+// the child must not be able to add it to a group they don't manage.
+func parentGroup(req interface{}) string {
+	if r, ok := req.(interface{ GetParentGroup() *pb.Group }); ok {
+		return r.GetParentGroup().GetName()
+	}
+	return ""
+}
+
+func requestSecret(req interface{}) string {
+	if r, ok := req.(interface{ GetEntity() *pb.Entity }); ok {
+		return r.GetEntity().GetSecret()
+	}
+	return ""
+}
+
+func requestToken(req interface{}) string {
+	if r, ok := req.(interface{ GetAuthToken() string }); ok {
+		return r.GetAuthToken()
+	}
+	return ""
+}
+
+// modeIs returns a function that reports whether a request was made
+// in the named mode.
+func modeIs(mode string) func(interface{}) bool {
+	return func(req interface{}) bool {
+		if r, ok := req.(interface{ GetMode() string }); ok {
+			return strings.ToUpper(r.GetMode()) == mode
+		}
+		return false
+	}
+}
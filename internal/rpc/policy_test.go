@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+	"github.com/NetAuth/NetAuth/internal/crypto/nocrypto"
+	"github.com/NetAuth/NetAuth/internal/db/memdb"
+	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/NetAuth/NetAuth/internal/tree"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// dummyTokenService hands out the claims it has been loaded with
+// for each token.
+type dummyTokenService map[string]token.Claims
+
+func (dummyTokenService) Generate(token.Claims, token.Config) (string, error) { return "", nil }
+func (d dummyTokenService) Validate(t string) (token.Claims, error) {
+	c, ok := d[t]
+	if !ok {
+		return token.Claims{}, token.ErrTokenInvalid
+	}
+	return c, nil
+}
+
+func newTestServer(t *testing.T) *NetAuthServer {
+	db, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := nocrypto.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := tree.New(db, c)
+
+	for _, e := range []string{"alice", "bob", "carol"} {
+		if err := m.NewEntity(e, -1, e+"-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.NewGroup("admins", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewGroup("team", "", "admins", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddEntityToGroup("bob", "admins"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddEntityToGroup("carol", "team"); err != nil {
+		t.Fatal(err)
+	}
+
+	return &NetAuthServer{
+		Tree: m,
+		Token: dummyTokenService{
+			"alice": {EntityID: "alice"},
+			"bob":   {EntityID: "bob"},
+			"carol": {EntityID: "carol"},
+			"root":  {EntityID: "root", Capabilities: []string{"GLOBAL_ROOT"}},
+			"meta":  {EntityID: "meta", Capabilities: []string{"MODIFY_GROUP_META"}},
+			"half":  {EntityID: "half", Capabilities: []string{"CREATE_GROUP"}},
+		},
+	}
+}
+
+func TestPolicyCoversEveryMethod(t *testing.T) {
+	g := grpc.NewServer()
+	pb.RegisterNetAuthServer(g, &NetAuthServer{})
+
+	registered := make(map[string]bool)
+	for _, svc := range g.GetServiceInfo() {
+		for _, m := range svc.Methods {
+			registered[m.Name] = true
+			if _, ok := policies[m.Name]; !ok {
+				t.Errorf("Method %s has no policy", m.Name)
+			}
+		}
+	}
+	if len(registered) == 0 {
+		t.Fatal("No methods were registered")
+	}
+
+	for name := range policies {
+		if !registered[name] {
+			t.Errorf("Policy for %s does not match any method", name)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := newTestServer(t)
+
+	cases := []struct {
+		method string
+		req    interface{}
+		wantID string
+		err    error
+	}{
+		// Unknown methods are refused outright.
+		{"/netauth.NetAuth/NoSuchMethod", &pb.NetAuthRequest{}, "", ErrRequestorUnqualified},
+
+		// Public methods need no token.
+		{"/netauth.NetAuth/EntityInfo", &pb.NetAuthRequest{}, "", nil},
+
+		// Any valid token will do, but it must be valid.
+		{"/netauth.NetAuth/RenewToken", &pb.NetAuthRequest{AuthToken: proto.String("alice")}, "alice", nil},
+		{"/netauth.NetAuth/RenewToken", &pb.NetAuthRequest{AuthToken: proto.String("bogus")}, "", token.ErrTokenInvalid},
+
+		// Capabilities are required, and GLOBAL_ROOT stands
+		// in for all of them.
+		{"/netauth.NetAuth/Fsck", &pb.FsckRequest{AuthToken: proto.String("alice")}, "", ErrRequestorUnqualified},
+		{"/netauth.NetAuth/Fsck", &pb.FsckRequest{AuthToken: proto.String("root")}, "root", nil},
+		{"/netauth.NetAuth/RenameGroup", &pb.RenameRequest{AuthToken: proto.String("half")}, "", ErrRequestorUnqualified},
+		{"/netauth.NetAuth/RenameGroup", &pb.RenameRequest{AuthToken: proto.String("root")}, "root", nil},
+
+		// Read only requests are public.
+		{"/netauth.NetAuth/ModifyEntityKeys", &pb.ModEntityKeyRequest{Mode: proto.String("list")}, "", nil},
+		{"/netauth.NetAuth/ModifyEntityKeys", &pb.ModEntityKeyRequest{Mode: proto.String("ADD"), AuthToken: proto.String("alice")}, "", ErrRequestorUnqualified},
+
+		// Entities may act on themselves.
+		{"/netauth.NetAuth/RevokeAllTokens", &pb.NetAuthRequest{Entity: &pb.Entity{ID: proto.String("alice")}, AuthToken: proto.String("alice")}, "alice", nil},
+		{"/netauth.NetAuth/RevokeAllTokens", &pb.NetAuthRequest{Entity: &pb.Entity{ID: proto.String("bob")}, AuthToken: proto.String("alice")}, "", ErrRequestorUnqualified},
+
+		// Members of the managing group may manage a group,
+		// but members of the group itself may not.
+		{"/netauth.NetAuth/AddEntityToGroup", &pb.ModEntityMembershipRequest{Group: &pb.Group{Name: proto.String("team")}, AuthToken: proto.String("bob")}, "bob", nil},
+		{"/netauth.NetAuth/AddEntityToGroup", &pb.ModEntityMembershipRequest{Group: &pb.Group{Name: proto.String("team")}, AuthToken: proto.String("carol")}, "", ErrRequestorUnqualified},
+		{"/netauth.NetAuth/ModifyGroupMeta", &pb.ModGroupRequest{Group: &pb.Group{Name: proto.String("admins")}, AuthToken: proto.String("bob")}, "", ErrRequestorUnqualified},
+		{"/netauth.NetAuth/ModifyGroupMeta", &pb.ModGroupRequest{Group: &pb.Group{Name: proto.String("admins")}, AuthToken: proto.String("meta")}, "meta", nil},
+
+		// Nesting changes the parent group, so it is the
+		// parent that must be managed.
+		{"/netauth.NetAuth/ModifyGroupNesting", &pb.ModGroupNestingRequest{
+			ParentGroup: &pb.Group{Name: proto.String("team")},
+			ChildGroup:  &pb.Group{Name: proto.String("admins")},
+			AuthToken:   proto.String("bob"),
+		}, "bob", nil},
+		{"/netauth.NetAuth/ModifyGroupNesting", &pb.ModGroupNestingRequest{
+			ParentGroup: &pb.Group{Name: proto.String("admins")},
+			ChildGroup:  &pb.Group{Name: proto.String("team")},
+			AuthToken:   proto.String("bob"),
+		}, "", ErrRequestorUnqualified},
+
+		// Secrets can be changed with the old secret, or
+		// with a token that has the capability.
+		{"/netauth.NetAuth/ChangeSecret", &pb.ModEntityRequest{
+			Entity:    &pb.Entity{ID: proto.String("alice"), Secret: proto.String("alice-secret")},
+			ModEntity: &pb.Entity{ID: proto.String("alice")},
+		}, "alice", nil},
+		{"/netauth.NetAuth/ChangeSecret", &pb.ModEntityRequest{
+			Entity:    &pb.Entity{ID: proto.String("alice"), Secret: proto.String("wrong")},
+			ModEntity: &pb.Entity{ID: proto.String("alice")},
+			AuthToken: proto.String("root"),
+		}, "", crypto.ErrAuthorizationFailure},
+		{"/netauth.NetAuth/ChangeSecret", &pb.ModEntityRequest{
+			Entity:    &pb.Entity{ID: proto.String("alice"), Secret: proto.String("alice-secret")},
+			ModEntity: &pb.Entity{ID: proto.String("bob")},
+			AuthToken: proto.String("alice"),
+		}, "", ErrRequestorUnqualified},
+		{"/netauth.NetAuth/ChangeSecret", &pb.ModEntityRequest{
+			Entity:    &pb.Entity{ID: proto.String("alice"), Secret: proto.String("alice-secret")},
+			ModEntity: &pb.Entity{ID: proto.String("bob")},
+			AuthToken: proto.String("root"),
+		}, "root", nil},
+	}
+
+	for i, c := range cases {
+		ctx, err := s.authorize(context.Background(), c.method, c.req)
+		if err != c.err {
+			t.Errorf("%d (%s): Got %v; Want %v", i, c.method, err, c.err)
+			continue
+		}
+		if id := claimsFrom(ctx).EntityID; err == nil && id != c.wantID {
+			t.Errorf("%d (%s): Claims for %q; Want %q", i, c.method, id, c.wantID)
+		}
+	}
+}
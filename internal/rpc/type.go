@@ -2,7 +2,11 @@ package rpc
 
 import (
 	"errors"
+	"time"
 
+	"github.com/NetAuth/NetAuth/internal/audit"
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/db/changefeed"
 	"github.com/NetAuth/NetAuth/internal/token"
 
 	pb "github.com/NetAuth/Protocol"
@@ -35,21 +39,28 @@ type EntityTree interface {
 	MakeBootstrap(string, string)
 	DisableBootstrap()
 	SetEntitySecretByID(string, string) error
+	ImportEntitySecretByID(string, string) error
 
 	LockEntity(string) error
 	UnlockEntity(string) error
+	GetAuthFailures(string) (*pb.AuthFailures, error)
+	ClearAuthFailures(string) error
+	RevokeToken(string, string, time.Time) error
+	RevokeAllTokens(string) error
 
 	NewEntity(string, int32, string) error
 	DeleteEntityByID(string) error
-	UpdateEntityMeta(string, *pb.EntityMeta) error
+	RenameEntity(string, string) error
+	UpdateEntityMeta(string, uint64, *pb.EntityMeta) error
 	UpdateEntityKeys(string, string, string, string) ([]string, error)
 	ManageUntypedEntityMeta(string, string, string, string) ([]string, error)
 
 	NewGroup(string, string, string, int32) error
-	DeleteGroup(string) error
+	DeleteGroup(string, bool) error
+	RenameGroup(string, string) error
 	ListGroups() ([]*pb.Group, error)
 	GetGroupByName(string) (*pb.Group, error)
-	UpdateGroupMeta(string, *pb.Group) error
+	UpdateGroupMeta(string, uint64, *pb.Group) error
 	ManageUntypedGroupMeta(string, string, string, string) ([]string, error)
 	GetMemberships(*pb.Entity, bool) []string
 
@@ -63,11 +74,39 @@ type EntityTree interface {
 	RemoveEntityCapabilityByID(string, string) error
 	SetGroupCapabilityByName(string, string) error
 	RemoveGroupCapabilityByName(string, string) error
+
+	Fsck(bool) ([]*pb.FsckProblem, error)
+}
+
+// A ChangeFeed reports the changes made to the entities and groups
+// on the server.
+type ChangeFeed interface {
+	Watch(uint64) (<-chan changefeed.Event, func(), error)
+}
+
+// A DataStore provides a consistent view of the whole database.
+type DataStore interface {
+	View(func(db.DB) error) (uint64, error)
+}
+
+// An AuditLog keeps a record of the changes made through the server.
+type AuditLog interface {
+	Record(*pb.AuditRecord)
+	Query(audit.Filter) ([]*pb.AuditRecord, error)
 }
 
 // A NetAuthServer is a collection of methods that satisfy the
-// requirements of the NetAuthServer protocol buffer.
+// requirements of the NetAuthServer protocol buffer.  Changes may be
+// left nil, in which case changes cannot be watched, Store may be
+// left nil, in which case backups cannot be taken, and Audit may be
+// left nil, in which case changes are not audited.  The handlers
+// leave authorization to UnaryInterceptor and StreamInterceptor,
+// which must be installed on any gRPC server the NetAuthServer is
+// registered with.
 type NetAuthServer struct {
-	Tree  EntityTree
-	Token token.Service
+	Tree    EntityTree
+	Token   token.Service
+	Changes ChangeFeed
+	Store   DataStore
+	Audit   AuditLog
 }
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/db/changefeed"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// WatchChanges streams the changes made to entities and groups back
+// to the client as they happen.  Any valid token may be used to
+// watch.  If a revision is provided the stream starts with the
+// changes made since that revision, which allows a client that was
+// disconnected to resume without missing anything.
+func (s *NetAuthServer) WatchChanges(r *pb.WatchRequest, stream pb.NetAuth_WatchChangesServer) error {
+	client := r.GetInfo()
+
+	c := claimsFrom(stream.Context())
+
+	if s.Changes == nil {
+		return status.Errorf(codes.Unimplemented, "This server does not provide changes")
+	}
+
+	events, cancel, err := s.Changes.Watch(r.GetRevision())
+	if err != nil {
+		return toWireError(err)
+	}
+	defer cancel()
+
+	log.Printf("Changes since %d watched by %s (%s@%s)",
+		r.GetRevision(),
+		c.EntityID,
+		client.GetService(),
+		client.GetID())
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				log.Printf("Watcher %s (%s@%s) fell behind and was disconnected",
+					c.EntityID,
+					client.GetService(),
+					client.GetID())
+				return toWireError(changefeed.ErrWatcherFellBehind)
+			}
+			evType := ev.Type
+			if err := stream.Send(&pb.ChangeEvent{
+				Revision: proto.Uint64(ev.Revision),
+				Type:     &evType,
+				Name:     proto.String(ev.Name),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
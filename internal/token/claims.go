@@ -1,5 +1,9 @@
 package token
 
+import (
+	"time"
+)
+
 // Claims is a type that contains the claims that all tokens shall
 // have.  Implementations may embed additional messages, but these
 // cliams must exist here.
@@ -7,6 +11,13 @@ type Claims struct {
 	EntityID     string
 	Capabilities []string
 	RenewalsLeft int
+
+	// ID, IssuedAt, and Expires are filled in when a token is
+	// validated.  They are not part of the claims carried in the
+	// token itself.
+	ID       string    `json:"-"`
+	IssuedAt time.Time `json:"-"`
+	Expires  time.Time `json:"-"`
 }
 
 // HasCapability is a convenience function to determine if the
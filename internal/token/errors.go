@@ -28,4 +28,14 @@ var (
 	// ErrTokenInvalid is returned for generic cases where the
 	// token is invalid for some reason.
 	ErrTokenInvalid = errors.New("the provided token is invalid")
+
+	// ErrNoRenewalsLeft is returned when a token is presented for
+	// renewal but has already been renewed as many times as it
+	// may be.
+	ErrNoRenewalsLeft = errors.New("the provided token may not be renewed again")
+
+	// ErrRotationUnsupported is returned when key rotation is
+	// requested from a token service that is not configured to
+	// hold more than one key.
+	ErrRotationUnsupported = errors.New("the token service can't rotate its keys")
 )
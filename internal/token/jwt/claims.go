@@ -0,0 +1,62 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newClaims combines the token.Claims with the standard claims built
+// from the config.  Each token gets its own ID so that it can be
+// revoked on its own.
+func newClaims(claims token.Claims, config token.Config) (RSAToken, error) {
+	id, err := token.NewID()
+	if err != nil {
+		return RSAToken{}, err
+	}
+
+	return RSAToken{
+		claims,
+		jwt.StandardClaims{
+			IssuedAt:  config.IssuedAt.Unix(),
+			NotBefore: config.NotBefore.Unix(),
+			ExpiresAt: config.NotBefore.Add(config.Lifetime).Unix(),
+			Subject:   "NetAuth Standard Token",
+			Audience:  "Unrestricted",
+			Issuer:    config.Issuer,
+			Id:        id,
+		},
+		config.IssuedAt.UnixNano(),
+	}, nil
+}
+
+// claimsFromToken pulls the token.Claims out of a parsed token and
+// fills in the parts that come from the standard claims.
+func claimsFromToken(t *jwt.Token) token.Claims {
+	// We do a blind type change here to pull out the embedded
+	// RSAToken which includes a token.Claims.  We can be sure
+	// this is an RSAToken because if it wasn't, the
+	// ParseWithClaims call would have exploded before this.
+	claims, _ := t.Claims.(*RSAToken)
+	claims.Claims.ID = claims.Id
+	claims.Claims.IssuedAt = time.Unix(claims.StandardClaims.IssuedAt, 0)
+	if claims.IssuedAtNano != 0 {
+		claims.Claims.IssuedAt = time.Unix(0, claims.IssuedAtNano)
+	}
+	claims.Claims.Expires = time.Unix(claims.StandardClaims.ExpiresAt, 0)
+	return claims.Claims
+}
+
+// keyID derives the ID of a key from its public half.
+func keyID(k interface{}) string {
+	// This error is discarded as the key has always been loaded
+	// or generated as a valid public key by this point.
+	pubASN1, _ := x509.MarshalPKIXPublicKey(k)
+	sum := sha256.Sum256(pubASN1)
+	return hex.EncodeToString(sum[:8])
+}
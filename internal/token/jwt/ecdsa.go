@@ -0,0 +1,47 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"flag"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	es256PrivateKeyFile = flag.String("jwt_es256_privatekey", "token-es256.key", "Path to ES256 private key")
+	es256PublicKeyFile  = flag.String("jwt_es256_publickey", "/usr/share/netauth/token-es256.pem", "Path to ES256 public key")
+	es256Generate       = flag.Bool("jwt_es256_generate", false, "Generate ES256 keys if not available")
+)
+
+func init() {
+	token.Register("jwt-es256", NewES256)
+}
+
+// NewES256 returns a token service that signs tokens using ECDSA on
+// the P-256 curve.
+func NewES256() (token.Service, error) {
+	return newKeyPairService(&keyPairService{
+		name:           "JWT-ES256",
+		method:         jwt.SigningMethodES256,
+		privateKeyFile: es256PrivateKeyFile,
+		publicKeyFile:  es256PublicKeyFile,
+		generate:       es256Generate,
+		newKey: func() (crypto.Signer, error) {
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		},
+		validKey: func(k interface{}) bool {
+			switch key := k.(type) {
+			case *ecdsa.PublicKey:
+				return key.Curve == elliptic.P256()
+			case *ecdsa.PrivateKey:
+				return key.Curve == elliptic.P256()
+			}
+			return false
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"flag"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ed25519PrivateKeyFile = flag.String("jwt_ed25519_privatekey", "token-ed25519.key", "Path to Ed25519 private key")
+	ed25519PublicKeyFile  = flag.String("jwt_ed25519_publickey", "/usr/share/netauth/token-ed25519.pem", "Path to Ed25519 public key")
+	ed25519Generate       = flag.Bool("jwt_ed25519_generate", false, "Generate Ed25519 keys if not available")
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys.  The JWT library
+// doesn't provide this method, so it is registered here.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+	token.Register("jwt-ed25519", NewEd25519)
+}
+
+// Alg returns the name of the method as it appears in the token
+// header.
+func (m *signingMethodEdDSA) Alg() string { return "EdDSA" }
+
+// Verify checks the signature with an ed25519.PublicKey.
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	k, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(k, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign signs the string with an ed25519.PrivateKey.
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(k, []byte(signingString))), nil
+}
+
+// NewEd25519 returns a token service that signs tokens using EdDSA
+// with Ed25519 keys.
+func NewEd25519() (token.Service, error) {
+	return newKeyPairService(&keyPairService{
+		name:           "JWT-ED25519",
+		method:         SigningMethodEdDSA,
+		privateKeyFile: ed25519PrivateKeyFile,
+		publicKeyFile:  ed25519PublicKeyFile,
+		generate:       ed25519Generate,
+		newKey: func() (crypto.Signer, error) {
+			_, pri, err := ed25519.GenerateKey(rand.Reader)
+			return pri, err
+		},
+		validKey: func(k interface{}) bool {
+			switch k.(type) {
+			case ed25519.PublicKey, ed25519.PrivateKey:
+				return true
+			}
+			return false
+		},
+	})
+}
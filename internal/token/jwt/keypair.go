@@ -0,0 +1,258 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/NetAuth/NetAuth/internal/health"
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// A keyPairService signs tokens with a single key pair stored in
+// PKCS8 and PKIX form.  It provides the parts that are common to the
+// elliptic curve token services, which differ only in their signing
+// method and the keys they will accept.
+type keyPairService struct {
+	name   string
+	method jwt.SigningMethod
+
+	privateKeyFile *string
+	publicKeyFile  *string
+	generate       *bool
+
+	// newKey generates a fresh private key, validKey reports
+	// whether a parsed public or private key is of the type this
+	// service signs with.
+	newKey   func() (crypto.Signer, error)
+	validKey func(interface{}) bool
+
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+	kid        string
+}
+
+// newKeyPairService loads the keys for the service and registers its
+// health check.
+func newKeyPairService(s *keyPairService) (token.Service, error) {
+	if err := s.GetKeys(); err != nil {
+		return nil, err
+	}
+
+	health.RegisterCheck(s.name, s.healthCheck)
+
+	return s, nil
+}
+
+// Generate generates a token signed by the private key.
+func (s *keyPairService) Generate(claims token.Claims, config token.Config) (string, error) {
+	if s.privateKey == nil {
+		// Private key is unavailable, signing is not possible
+		return "", token.ErrKeyUnavailable
+	}
+
+	c, err := newClaims(claims, config)
+	if err != nil {
+		return "", err
+	}
+
+	tkn := jwt.NewWithClaims(s.method, c)
+	tkn.Header["kid"] = s.kid
+
+	ss, err := tkn.SignedString(s.privateKey)
+	if err != nil {
+		log.Println("Error signing token:", err)
+		return "", token.ErrInternalError
+	}
+	return ss, nil
+}
+
+// Validate validates a token signed by the private key.
+func (s *keyPairService) Validate(tkn string) (token.Claims, error) {
+	if s.publicKey == nil {
+		return token.Claims{}, token.ErrKeyUnavailable
+	}
+
+	t, err := jwt.ParseWithClaims(tkn, &RSAToken{}, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != s.method.Alg() {
+			log.Println("Token was signed with invalid algorithm:", t.Header["alg"])
+			return nil, token.ErrTokenInvalid
+		}
+		if kid, ok := t.Header["kid"].(string); ok && kid != s.kid {
+			log.Printf("Token was signed with unknown key '%s'", kid)
+			return nil, token.ErrTokenInvalid
+		}
+		return s.publicKey, nil
+	})
+	if err != nil {
+		// This case gets raised if the token wasn't parsable
+		// for some reason, or the signing key was wrong, or
+		// it was corrupt in some way.
+		if t != nil && !t.Valid {
+			return token.Claims{}, token.ErrTokenInvalid
+		}
+		return token.Claims{}, token.ErrInternalError
+	}
+
+	return claimsFromToken(t), nil
+}
+
+// GetKeys obtains the keys for the service.  If the keys are not
+// available and it is not disabled, then a keypair will be generated.
+// As with the RSA service a missing private key is not an error, the
+// service will be able to verify tokens but not sign them.
+func (s *keyPairService) GetKeys() error {
+	log.Printf("Loading public key from %s", *s.publicKeyFile)
+	f, err := ioutil.ReadFile(*s.publicKeyFile)
+	if os.IsNotExist(err) {
+		log.Printf("Blob at %s contains no key!", *s.publicKeyFile)
+
+		if !*s.generate {
+			log.Println("Generating keys is disabled!")
+			return token.ErrKeyGenerationDisabled
+		}
+		return s.generateKeys()
+	}
+	if err != nil {
+		log.Println("Error loading public key:", err)
+		return token.ErrKeyUnavailable
+	}
+
+	if !checkKeyModeOK("-rw-r--r--", *s.publicKeyFile) {
+		log.Println("Public Key has incorrect mode bits")
+		return token.ErrKeyUnavailable
+	}
+
+	block, _ := pem.Decode(f)
+	if block == nil {
+		log.Println("Error decoding PEM block")
+		return token.ErrKeyUnavailable
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		log.Println("Error parsing key:", err)
+		return token.ErrKeyUnavailable
+	}
+	if !s.validKey(pub) {
+		log.Printf("%s does not contain a %s public key", *s.publicKeyFile, s.method.Alg())
+		return token.ErrKeyUnavailable
+	}
+	s.publicKey = pub
+	s.kid = keyID(pub)
+
+	log.Printf("Loading private key from %s", *s.privateKeyFile)
+	pristr, err := ioutil.ReadFile(*s.privateKeyFile)
+	if err != nil {
+		log.Println("Token: No private key available, signing will be unavailable:", err)
+		return nil
+	}
+
+	if !checkKeyModeOK("-r--------", *s.privateKeyFile) {
+		log.Println("Private Key has incorrect mode bits")
+		log.Println("This may be fatal if this is a server")
+	}
+
+	block, _ = pem.Decode(pristr)
+	if block == nil {
+		log.Println("Error decoding PEM block (private key)")
+		return nil
+	}
+	pri, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		log.Println("Error parsing private key:", err)
+		return nil
+	}
+	signer, ok := pri.(crypto.Signer)
+	if !ok || !s.validKey(pri) {
+		log.Printf("%s does not contain a %s private key", *s.privateKeyFile, s.method.Alg())
+		return nil
+	}
+	if keyID(signer.Public()) != s.kid {
+		log.Println("Private key does not match the public key")
+		return nil
+	}
+	s.privateKey = signer
+
+	// Keys loaded and ready to sign with
+	return nil
+}
+
+func (s *keyPairService) generateKeys() error {
+	log.Println("Generating keys")
+
+	pri, err := s.newKey()
+	if err != nil {
+		log.Println(err)
+		return token.ErrInternalError
+	}
+
+	priASN1, err := x509.MarshalPKCS8PrivateKey(pri)
+	if err != nil {
+		log.Println(err)
+		return token.ErrInternalError
+	}
+	pridata := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priASN1})
+	if err := ioutil.WriteFile(*s.privateKeyFile, pridata, 0400); err != nil {
+		log.Println("Error writing private key file:", err)
+		return token.ErrInternalError
+	}
+
+	pubASN1, err := x509.MarshalPKIXPublicKey(pri.Public())
+	if err != nil {
+		log.Println(err)
+		return token.ErrInternalError
+	}
+	pubdata := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubASN1})
+	if err := ioutil.WriteFile(*s.publicKeyFile, pubdata, 0644); err != nil {
+		log.Println("Error writing public key file:", err)
+		return token.ErrInternalError
+	}
+
+	s.privateKey = pri
+	s.publicKey = pri.Public()
+	s.kid = keyID(s.publicKey)
+
+	// At this point the key is saved to disk and
+	// initialized
+	log.Println("Keys successfully generated")
+	return nil
+}
+
+// healthCheck provides a sanity check that keys are loaded and owned
+// correctly.
+func (s *keyPairService) healthCheck() health.SubsystemStatus {
+	status := health.SubsystemStatus{
+		OK:   false,
+		Name: "TKN_" + s.name,
+	}
+
+	if s.privateKey == nil {
+		status.Status = "No private key is loaded"
+		return status
+	}
+
+	if s.publicKey == nil {
+		status.Status = "No public key is loaded"
+		return status
+	}
+
+	if !checkKeyModeOK("-rw-r--r--", *s.publicKeyFile) {
+		status.Status = "Public key has incorrect mode"
+		return status
+	}
+
+	if !checkKeyModeOK("-r--------", *s.privateKeyFile) {
+		status.Status = "Private key has incorrect mode"
+		return status
+	}
+
+	status.OK = true
+	status.Status = s.name + " TokenService is ready to issue/verify tokens"
+
+	return status
+}
@@ -0,0 +1,210 @@
+package jwt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+)
+
+// keyPairCases provides the flags and constructor for each of the
+// services built on the keyPairService.
+var keyPairCases = []struct {
+	name     string
+	new      token.Factory
+	priFile  *string
+	pubFile  *string
+	generate *bool
+}{
+	{"ES256", NewES256, es256PrivateKeyFile, es256PublicKeyFile, es256Generate},
+	{"Ed25519", NewEd25519, ed25519PrivateKeyFile, ed25519PublicKeyFile, ed25519Generate},
+}
+
+func TestKeyPairGenerateAndValidate(t *testing.T) {
+	for _, c := range keyPairCases {
+		testDir := mkTmpTestDir(t)
+		*c.priFile = filepath.Join(testDir, "netauth.key")
+		*c.pubFile = filepath.Join(testDir, "netauth.pem")
+		*c.generate = true
+
+		x, err := c.new()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		tkn, err := x.Generate(token.Claims{EntityID: "foo"}, config)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if kid := tokenKID(t, tkn); kid != x.(*keyPairService).kid {
+			t.Errorf("%s: Wrong kid '%s'", c.name, kid)
+		}
+
+		// Load the keys that were just written and check the
+		// token with them.
+		*c.generate = false
+		x, err = c.new()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if _, err := x.Validate(tkn); err != token.ErrTokenInvalid {
+			// The config issues tokens in the past, so
+			// they have already expired.
+			t.Errorf("%s: %v", c.name, err)
+		}
+
+		cfg := token.Config{
+			Lifetime:  time.Minute * 5,
+			IssuedAt:  time.Now(),
+			NotBefore: time.Now(),
+			Issuer:    "NetAuth Test",
+		}
+		tkn, err = x.Generate(token.Claims{EntityID: "foo"}, cfg)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		claims, err := x.Validate(tkn)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if claims.EntityID != "foo" || claims.ID == "" {
+			t.Errorf("%s: Wrong claims: %v", c.name, claims)
+		}
+
+		cleanTmpTestDir(testDir, t)
+	}
+}
+
+func TestKeyPairMissingKeys(t *testing.T) {
+	for _, c := range keyPairCases {
+		testDir := mkTmpTestDir(t)
+		*c.priFile = filepath.Join(testDir, "netauth.key")
+		*c.pubFile = filepath.Join(testDir, "netauth.pem")
+		*c.generate = false
+
+		if _, err := c.new(); err != token.ErrKeyGenerationDisabled {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		cleanTmpTestDir(testDir, t)
+	}
+}
+
+func TestKeyPairBadPublicKeyMode(t *testing.T) {
+	for _, c := range keyPairCases {
+		testDir := mkTmpTestDir(t)
+		*c.priFile = filepath.Join(testDir, "netauth.key")
+		*c.pubFile = filepath.Join(testDir, "netauth.pem")
+		*c.generate = true
+
+		if _, err := c.new(); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if err := os.Chmod(*c.pubFile, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.new(); err != token.ErrKeyUnavailable {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		cleanTmpTestDir(testDir, t)
+	}
+}
+
+func TestKeyPairWrongKeyType(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+	for _, c := range keyPairCases {
+		*c.priFile = filepath.Join(testDir, c.name+".key")
+		*c.pubFile = filepath.Join(testDir, c.name+".pem")
+		*c.generate = true
+		if _, err := c.new(); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+	}
+
+	// Each service is given the public key of the other.
+	for i, c := range keyPairCases {
+		other := keyPairCases[(i+1)%len(keyPairCases)]
+		*c.pubFile = filepath.Join(testDir, other.name+".pem")
+		*c.generate = false
+		if _, err := c.new(); err != token.ErrKeyUnavailable {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestKeyPairNoPrivateKey(t *testing.T) {
+	for _, c := range keyPairCases {
+		testDir := mkTmpTestDir(t)
+		*c.priFile = filepath.Join(testDir, "netauth.key")
+		*c.pubFile = filepath.Join(testDir, "netauth.pem")
+		*c.generate = true
+
+		if _, err := c.new(); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if err := os.Remove(*c.priFile); err != nil {
+			t.Fatal(err)
+		}
+
+		x, err := c.new()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if _, err := x.Generate(token.Claims{}, config); err != token.ErrKeyUnavailable {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if status := x.(*keyPairService).healthCheck(); status.OK {
+			t.Errorf("%s: %v", c.name, status)
+		}
+		cleanTmpTestDir(testDir, t)
+	}
+}
+
+func TestKeyPairHealthCheck(t *testing.T) {
+	for _, c := range keyPairCases {
+		testDir := mkTmpTestDir(t)
+		*c.priFile = filepath.Join(testDir, "netauth.key")
+		*c.pubFile = filepath.Join(testDir, "netauth.pem")
+		*c.generate = true
+
+		x, err := c.new()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		kx := x.(*keyPairService)
+		if status := kx.healthCheck(); !status.OK {
+			t.Errorf("%s: %v", c.name, status)
+		}
+
+		if err := os.Chmod(*c.priFile, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if status := kx.healthCheck(); status.OK {
+			t.Errorf("%s: %v", c.name, status)
+		}
+		cleanTmpTestDir(testDir, t)
+	}
+}
+
+func TestKeyPairWrongAlgorithm(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+
+	var services []token.Service
+	for _, c := range keyPairCases {
+		*c.priFile = filepath.Join(testDir, c.name+".key")
+		*c.pubFile = filepath.Join(testDir, c.name+".pem")
+		*c.generate = true
+		x, err := c.new()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		services = append(services, x)
+	}
+
+	tkn := mkToken(t, services[0])
+	if _, err := services[1].Validate(tkn); err != token.ErrTokenInvalid {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,256 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+)
+
+var (
+	keyringDir   = flag.String("jwt_rsa_keyring", "", "Directory of RSA keys, overrides the single key files when set")
+	keyRetention = flag.Duration("jwt_rsa_key_retention", time.Hour*24, "How long a replaced key is kept for verification")
+)
+
+const (
+	currentKeyFile = "current"
+	retiredSuffix  = ".retired"
+)
+
+// The keyring is a directory holding <kid>.key and <kid>.pem for each
+// key pair.  The file 'current' names the key that tokens are signed
+// with.  Keys that have been replaced have their private half removed
+// and a <kid>.retired file recording when that happened, and are kept
+// for verification until the retention period has passed.
+
+func (s *RSATokenService) privateKeyPath() string {
+	if *keyringDir == "" {
+		return *privateKeyFile
+	}
+	return filepath.Join(*keyringDir, s.kid+".key")
+}
+
+func (s *RSATokenService) publicKeyPath() string {
+	if *keyringDir == "" {
+		return *publicKeyFile
+	}
+	return filepath.Join(*keyringDir, s.kid+".pem")
+}
+
+// loadKeyring loads the current key pair and every public key that
+// has not passed its retention period.  If the keyring is empty and
+// generation is enabled, the first key is generated.
+func (s *RSATokenService) loadKeyring() error {
+	log.Printf("Loading keyring from %s", *keyringDir)
+	c, err := ioutil.ReadFile(filepath.Join(*keyringDir, currentKeyFile))
+	if os.IsNotExist(err) {
+		log.Printf("Keyring at %s has no current key!", *keyringDir)
+
+		if !*generate {
+			log.Println("Generating keys is disabled!")
+			return token.ErrKeyGenerationDisabled
+		}
+		if err := os.MkdirAll(*keyringDir, 0755); err != nil {
+			log.Println("Error creating keyring:", err)
+			return token.ErrInternalError
+		}
+		if err := s.Rotate(); err != nil {
+			return err
+		}
+		s.generated = true
+		return nil
+	}
+	if err != nil {
+		log.Println("Error reading current key:", err)
+		return token.ErrKeyUnavailable
+	}
+	kid := strings.TrimSpace(string(c))
+
+	pems, err := filepath.Glob(filepath.Join(*keyringDir, "*.pem"))
+	if err != nil {
+		log.Println("Error listing keyring:", err)
+		return token.ErrKeyUnavailable
+	}
+	s.keys = make(map[string]*rsa.PublicKey)
+	for _, path := range pems {
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if id != kid && retiredTooLong(id) {
+			log.Printf("Key %s is past retention and will not be loaded", id)
+			continue
+		}
+		k, err := readPublicKey(path)
+		if err != nil {
+			log.Printf("Skipping key %s: %s", id, err)
+			continue
+		}
+		s.keys[id] = k
+	}
+
+	pub, ok := s.keys[kid]
+	if !ok {
+		log.Printf("Current key %s is not available", kid)
+		return token.ErrKeyUnavailable
+	}
+	log.Printf("Loaded %d verification keys, signing with %s", len(s.keys), kid)
+
+	// As with single keys, a missing private key leaves the
+	// service able to verify but not to sign.
+	s.setKeys(kid, nil, pub)
+	pri, err := readPrivateKey(s.privateKeyPath())
+	if err != nil {
+		log.Println("Token: No private key available, signing will be unavailable:", err)
+		return nil
+	}
+	if !checkKeyModeOK("-r--------", s.privateKeyPath()) {
+		log.Println("Private Key has incorrect mode bits")
+		log.Println("This may be fatal if this is a server")
+	}
+	s.privateKey = pri
+	return nil
+}
+
+// Generated returns true if the current key was generated when the
+// keyring was loaded because the keyring was empty.
+func (s *RSATokenService) Generated() bool {
+	return s.generated
+}
+
+// Rotate generates a new key pair in the keyring and promotes it to
+// be the signing key.  The key it replaces is retired and stays
+// available for verification until the retention period has passed,
+// after which it is removed.  A running server will pick up the new
+// key when it is next started.
+func (s *RSATokenService) Rotate() error {
+	if *keyringDir == "" {
+		return token.ErrRotationUnsupported
+	}
+
+	log.Println("Generating new key for the keyring")
+	pri, err := rsa.GenerateKey(rand.Reader, *rsaBits)
+	if err != nil {
+		log.Println(err)
+		return token.ErrInternalError
+	}
+	kid := keyID(&pri.PublicKey)
+	if err := marshalPrivateKey(pri, filepath.Join(*keyringDir, kid+".key")); err != nil {
+		return err
+	}
+	if err := marshalPublicKey(&pri.PublicKey, filepath.Join(*keyringDir, kid+".pem")); err != nil {
+		return err
+	}
+
+	// Promote the new key before retiring the old one so that
+	// the keyring always has a usable current key.
+	old := s.kid
+	tmp := filepath.Join(*keyringDir, currentKeyFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(kid+"\n"), 0644); err != nil {
+		log.Println("Error writing current key:", err)
+		return token.ErrInternalError
+	}
+	if err := os.Rename(tmp, filepath.Join(*keyringDir, currentKeyFile)); err != nil {
+		log.Println("Error promoting key:", err)
+		return token.ErrInternalError
+	}
+	s.setKeys(kid, pri, &pri.PublicKey)
+	log.Printf("Key %s is now the signing key", kid)
+
+	if old != "" && old != kid {
+		retired := filepath.Join(*keyringDir, old+retiredSuffix)
+		if err := ioutil.WriteFile(retired, []byte(fmt.Sprintf("%d\n", time.Now().Unix())), 0644); err != nil {
+			log.Println("Error retiring key:", err)
+			return token.ErrInternalError
+		}
+		if err := os.Remove(filepath.Join(*keyringDir, old+".key")); err != nil && !os.IsNotExist(err) {
+			log.Println("Error removing retired private key:", err)
+		}
+		log.Printf("Key %s has been retired", old)
+	}
+
+	s.pruneKeyring()
+	return nil
+}
+
+// pruneKeyring removes keys that have been retired for longer than
+// the retention period.
+func (s *RSATokenService) pruneKeyring() {
+	retired, err := filepath.Glob(filepath.Join(*keyringDir, "*"+retiredSuffix))
+	if err != nil {
+		log.Println("Error listing keyring:", err)
+		return
+	}
+	for _, path := range retired {
+		id := strings.TrimSuffix(filepath.Base(path), retiredSuffix)
+		if !retiredTooLong(id) {
+			continue
+		}
+		for _, ext := range []string{".pem", ".key", retiredSuffix} {
+			if err := os.Remove(filepath.Join(*keyringDir, id+ext)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing %s%s: %s", id, ext, err)
+			}
+		}
+		delete(s.keys, id)
+		log.Printf("Key %s has been removed from the keyring", id)
+	}
+}
+
+// retiredTooLong returns true if the key was retired longer ago than
+// the retention period.  A key with an unreadable retirement time is
+// kept.
+func retiredTooLong(id string) bool {
+	r, err := ioutil.ReadFile(filepath.Join(*keyringDir, id+retiredSuffix))
+	if err != nil {
+		return false
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(r)), 10, 64)
+	if err != nil {
+		log.Printf("Bad retirement time for key %s: %s", id, err)
+		return false
+	}
+	return time.Since(time.Unix(ts, 0)) > *keyRetention
+}
+
+func readPublicKey(path string) (*rsa.PublicKey, error) {
+	if !checkKeyModeOK("-rw-r--r--", path) {
+		return nil, token.ErrKeyUnavailable
+	}
+	f, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(f)
+	if block == nil {
+		return nil, token.ErrKeyUnavailable
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, token.ErrKeyUnavailable
+	}
+	return p, nil
+}
+
+func readPrivateKey(path string) (*rsa.PrivateKey, error) {
+	f, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(f)
+	if block == nil {
+		return nil, token.ErrKeyUnavailable
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
@@ -0,0 +1,192 @@
+package jwt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func setupKeyring(t *testing.T) string {
+	testDir := mkTmpTestDir(t)
+	*keyringDir = filepath.Join(testDir, "keyring")
+	*keyRetention = time.Hour
+	*generate = true
+	return testDir
+}
+
+func cleanKeyring(testDir string, t *testing.T) {
+	*keyringDir = ""
+	cleanTmpTestDir(testDir, t)
+}
+
+func newKeyringService(t *testing.T) *RSATokenService {
+	x, err := NewRSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rx, ok := x.(*RSATokenService)
+	if !ok {
+		t.Fatal("Type Error")
+	}
+	return rx
+}
+
+func mkToken(t *testing.T, s token.Service) string {
+	cfg := token.Config{
+		Lifetime:  time.Minute * 5,
+		IssuedAt:  time.Now(),
+		NotBefore: time.Now(),
+		Issuer:    "NetAuth Test",
+	}
+	tkn, err := s.Generate(token.Claims{EntityID: "foo"}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tkn
+}
+
+func tokenKID(t *testing.T, tkn string) string {
+	p, _, err := new(jwt.Parser).ParseUnverified(tkn, &RSAToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := p.Header["kid"].(string)
+	return kid
+}
+
+func TestKeyringGenerate(t *testing.T) {
+	testDir := setupKeyring(t)
+	defer cleanKeyring(testDir, t)
+
+	rx := newKeyringService(t)
+	if !rx.Generated() {
+		t.Error("Generated key was not reported")
+	}
+
+	c, err := ioutil.ReadFile(filepath.Join(*keyringDir, currentKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(c)) != rx.kid {
+		t.Errorf("Wrong current key; got %s want %s", c, rx.kid)
+	}
+
+	tkn := mkToken(t, rx)
+	if kid := tokenKID(t, tkn); kid != rx.kid {
+		t.Errorf("Wrong kid; got '%s' want '%s'", kid, rx.kid)
+	}
+	if _, err := rx.Validate(tkn); err != nil {
+		t.Error(err)
+	}
+	if status := rx.healthCheck(); !status.OK {
+		t.Error(status)
+	}
+}
+
+func TestKeyringNoGenerate(t *testing.T) {
+	testDir := setupKeyring(t)
+	defer cleanKeyring(testDir, t)
+	*generate = false
+
+	if _, err := NewRSA(); err != token.ErrKeyGenerationDisabled {
+		t.Error(err)
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	testDir := setupKeyring(t)
+	defer cleanKeyring(testDir, t)
+
+	rx := newKeyringService(t)
+	oldKID := rx.kid
+	oldToken := mkToken(t, rx)
+
+	if err := rx.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if rx.kid == oldKID {
+		t.Fatal("Key was not changed")
+	}
+	newToken := mkToken(t, rx)
+	if kid := tokenKID(t, newToken); kid != rx.kid {
+		t.Errorf("Wrong kid; got '%s' want '%s'", kid, rx.kid)
+	}
+
+	// The retired key can no longer sign.
+	if _, err := os.Stat(filepath.Join(*keyringDir, oldKID+".key")); !os.IsNotExist(err) {
+		t.Error("Retired private key was not removed")
+	}
+
+	// Both tokens are accepted, both by the running service and
+	// by one that loads the keyring fresh.
+	*generate = false
+	loaded := newKeyringService(t)
+	if loaded.Generated() {
+		t.Error("Loaded key was reported as generated")
+	}
+	for _, s := range []*RSATokenService{rx, loaded} {
+		for _, tkn := range []string{oldToken, newToken} {
+			if _, err := s.Validate(tkn); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestKeyringRetention(t *testing.T) {
+	testDir := setupKeyring(t)
+	defer cleanKeyring(testDir, t)
+
+	rx := newKeyringService(t)
+	oldKID := rx.kid
+	oldToken := mkToken(t, rx)
+
+	// With no retention the old key is removed as soon as it is
+	// replaced.
+	*keyRetention = -time.Second
+	if err := rx.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(*keyringDir, oldKID+".pem")); !os.IsNotExist(err) {
+		t.Error("Expired key was not removed")
+	}
+	if _, err := rx.Validate(oldToken); err != token.ErrTokenInvalid {
+		t.Error(err)
+	}
+}
+
+func TestKeyringUnknownKID(t *testing.T) {
+	testDir := setupKeyring(t)
+	defer cleanKeyring(testDir, t)
+
+	rx := newKeyringService(t)
+	tkn := mkToken(t, rx)
+	delete(rx.keys, rx.kid)
+
+	if _, err := rx.Validate(tkn); err != token.ErrTokenInvalid {
+		t.Error(err)
+	}
+}
+
+func TestRotateNoKeyring(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+	*privateKeyFile = filepath.Join(testDir, "netauth.key")
+	*publicKeyFile = filepath.Join(testDir, "netauth.pem")
+	*generate = true
+
+	x, err := NewRSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.(token.Rotator).Rotate(); err != token.ErrRotationUnsupported {
+		t.Error(err)
+	}
+}
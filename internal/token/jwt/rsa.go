@@ -24,10 +24,16 @@ var (
 )
 
 // An RSAToken is a token that provides both the token.Claims required
-// components and the jtw.StandardClaims.
+// components and the jtw.StandardClaims.  The standard claims only
+// record the time a token was issued to the second, which isn't
+// enough to tell a token issued just after its entity's tokens were
+// revoked from one issued just before, so the time is also carried to
+// the nanosecond.
 type RSAToken struct {
 	token.Claims
 	jwt.StandardClaims
+
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
 }
 
 // The RSATokenService provides RSA tokens and the means to verify
@@ -35,6 +41,16 @@ type RSAToken struct {
 type RSATokenService struct {
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
+
+	// kid identifies the key pair above, and keys holds every
+	// public key that tokens may be verified with, including the
+	// current one.
+	kid  string
+	keys map[string]*rsa.PublicKey
+
+	// generated is set when loading the keyring created its
+	// first key.
+	generated bool
 }
 
 func init() {
@@ -60,21 +76,13 @@ func (s *RSATokenService) Generate(claims token.Claims, config token.Config) (st
 		return "", token.ErrKeyUnavailable
 	}
 
-	claims.RenewalsLeft = config.Renewals
-	c := RSAToken{
-		claims,
-		jwt.StandardClaims{
-			IssuedAt:  config.IssuedAt.Unix(),
-			NotBefore: config.NotBefore.Unix(),
-			ExpiresAt: config.NotBefore.Add(config.Lifetime).Unix(),
-			Subject:   "NetAuth Standard Token",
-			Audience:  "Unrestricted",
-			Issuer:    config.Issuer,
-			Id:        claims.EntityID,
-		},
+	c, err := newClaims(claims, config)
+	if err != nil {
+		return "", err
 	}
 
 	tkn := jwt.NewWithClaims(jwt.SigningMethodRS512, c)
+	tkn.Header["kid"] = s.kid
 
 	// We discard this error as there is no meaningful error that
 	// can be returned from here.  Basically the FPU would need to
@@ -94,7 +102,7 @@ func (s *RSATokenService) Validate(tkn string) (token.Claims, error) {
 			log.Println("Token was signed with invalid algorithm:", t.Header["alg"])
 			return nil, token.ErrTokenInvalid
 		}
-		return s.publicKey, nil
+		return s.verificationKey(t)
 	})
 	if err != nil {
 		// This case gets raised if the token wasn't parsable
@@ -106,18 +114,46 @@ func (s *RSATokenService) Validate(tkn string) (token.Claims, error) {
 		return token.Claims{}, token.ErrInternalError
 	}
 
-	// We do a blind type change here to pull out the embedded
-	// RSAToken which includes a token.Claims.  We can be sure
-	// this is an RSAToken because if it wasn't, the
-	// ParseWithClaims call would have exploded just above.
-	claims, _ := t.Claims.(*RSAToken)
-	return claims.Claims, nil
+	return claimsFromToken(t), nil
+}
+
+// verificationKey returns the public key that a token claims to be
+// signed with.  Tokens that predate key IDs are checked against the
+// current key.
+func (s *RSATokenService) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return s.publicKey, nil
+	}
+	k, ok := s.keys[kid]
+	if !ok {
+		log.Printf("Token was signed with unknown key '%s'", kid)
+		return nil, token.ErrTokenInvalid
+	}
+	return k, nil
+}
+
+// setKeys installs the key pair that new tokens will be signed with
+// and adds the public key to the verification set.
+func (s *RSATokenService) setKeys(kid string, pri *rsa.PrivateKey, pub *rsa.PublicKey) {
+	if s.keys == nil {
+		s.keys = make(map[string]*rsa.PublicKey)
+	}
+	s.kid = kid
+	s.privateKey = pri
+	s.publicKey = pub
+	s.keys[kid] = pub
 }
 
 // GetKeys obtains the keys for an RSATokenService.  If the keys are
 // not available and it is not disabled, then a keypair will be
-// generated.
+// generated.  If a keyring is in use the keys are loaded from there
+// instead.
 func (s *RSATokenService) GetKeys() error {
+	if *keyringDir != "" {
+		return s.loadKeyring()
+	}
+
 	log.Printf("Loading public key from %s", *publicKeyFile)
 	f, err := ioutil.ReadFile(*publicKeyFile)
 	if os.IsNotExist(err) {
@@ -163,7 +199,7 @@ func (s *RSATokenService) GetKeys() error {
 		log.Printf("%s does not contain an RSA public key", *publicKeyFile)
 		return token.ErrKeyUnavailable
 	}
-	s.publicKey = p
+	s.setKeys(keyID(p), nil, p)
 
 	// Now we'll try and load the private key, this doesn't error
 	// out, because you can still do meaningful work with the
@@ -218,7 +254,7 @@ func (s *RSATokenService) generateKeys(bits int) error {
 		log.Println(err)
 		return token.ErrInternalError
 	}
-	s.publicKey = &s.privateKey.PublicKey
+	s.setKeys(keyID(&s.privateKey.PublicKey), s.privateKey, &s.privateKey.PublicKey)
 
 	if err := marshalPrivateKey(s.privateKey, *privateKeyFile); err != nil {
 		return err
@@ -252,12 +288,12 @@ func (s *RSATokenService) healthCheck() health.SubsystemStatus {
 		return status
 	}
 
-	if !checkKeyModeOK("-rw-r--r--", *publicKeyFile) {
+	if !checkKeyModeOK("-rw-r--r--", s.publicKeyPath()) {
 		status.Status = "Public key has incorrect mode"
 		return status
 	}
 
-	if !checkKeyModeOK("-r--------", *privateKeyFile) {
+	if !checkKeyModeOK("-r--------", s.privateKeyPath()) {
 		status.Status = "Private key has incorrect mode"
 		return status
 	}
@@ -195,6 +195,93 @@ func TestValidateToken(t *testing.T) {
 	}
 }
 
+func TestValidateTokenRenewals(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+	*privateKeyFile = filepath.Join(testDir, "netauth.key")
+	*publicKeyFile = filepath.Join(testDir, "netauth.pem")
+
+	genFixedKey(t)
+
+	x, err := NewRSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The renewals are set by the caller, not the config.
+	c := token.Claims{
+		EntityID:     "foo",
+		RenewalsLeft: 3,
+	}
+
+	now := time.Now()
+	cfg := token.Config{
+		Lifetime:  time.Minute * 5,
+		IssuedAt:  now,
+		NotBefore: now,
+		Renewals:  5,
+		Issuer:    "NetAuth Test",
+	}
+
+	tkn, err := x.Generate(c, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := x.Validate(tkn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.RenewalsLeft != 3 {
+		t.Errorf("Wrong renewals; got %d want 3", claims.RenewalsLeft)
+	}
+	if claims.Expires.Unix() != now.Add(cfg.Lifetime).Unix() {
+		t.Errorf("Wrong expiry; got %s want %s", claims.Expires, now.Add(cfg.Lifetime))
+	}
+}
+
+func TestValidateTokenID(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+	*privateKeyFile = filepath.Join(testDir, "netauth.key")
+	*publicKeyFile = filepath.Join(testDir, "netauth.pem")
+
+	genFixedKey(t)
+
+	x, err := NewRSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cfg := token.Config{
+		Lifetime:  time.Minute * 5,
+		IssuedAt:  now,
+		NotBefore: now,
+		Issuer:    "NetAuth Test",
+	}
+
+	// Each token gets its own ID, even with identical claims.
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		tkn, err := x.Generate(token.Claims{EntityID: "foo"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := x.Validate(tkn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.ID == "" || ids[claims.ID] {
+			t.Errorf("Bad token ID: '%s'", claims.ID)
+		}
+		ids[claims.ID] = true
+		if claims.IssuedAt.Unix() != now.Unix() {
+			t.Errorf("Wrong issue time; got %s want %s", claims.IssuedAt, now)
+		}
+	}
+}
+
 func TestValidateNoKey(t *testing.T) {
 	testDir := mkTmpTestDir(t)
 	defer cleanTmpTestDir(testDir, t)
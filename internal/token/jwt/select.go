@@ -0,0 +1,39 @@
+package jwt
+
+import (
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// algorithms maps the algorithm named in a token's header to the
+// service that is able to validate it.
+var algorithms = map[string]token.Factory{
+	jwt.SigningMethodRS512.Alg(): NewRSA,
+	jwt.SigningMethodES256.Alg(): NewES256,
+	SigningMethodEdDSA.Alg():     NewEd25519,
+}
+
+// Algorithm returns the signing algorithm named in the header of the
+// token.  The token is not verified.
+func Algorithm(tkn string) (string, error) {
+	t, _, err := new(jwt.Parser).ParseUnverified(tkn, &RSAToken{})
+	if err != nil {
+		log.Println("Token could not be parsed:", err)
+		return "", token.ErrTokenInvalid
+	}
+	return t.Method.Alg(), nil
+}
+
+// NewForAlgorithm returns a token service that validates tokens
+// signed with the named algorithm.  This allows clients to validate
+// tokens without knowing in advance which service the server uses.
+func NewForAlgorithm(alg string) (token.Service, error) {
+	f, ok := algorithms[alg]
+	if !ok {
+		return nil, token.ErrUnknownTokenService
+	}
+	return f()
+}
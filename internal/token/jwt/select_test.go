@@ -0,0 +1,51 @@
+package jwt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+)
+
+func TestNewForAlgorithm(t *testing.T) {
+	testDir := mkTmpTestDir(t)
+	defer cleanTmpTestDir(testDir, t)
+	*es256PrivateKeyFile = filepath.Join(testDir, "netauth.key")
+	*es256PublicKeyFile = filepath.Join(testDir, "netauth.pem")
+	*es256Generate = true
+
+	x, err := NewES256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn := mkToken(t, x)
+
+	alg, err := Algorithm(tkn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg != "ES256" {
+		t.Errorf("Wrong algorithm: %s", alg)
+	}
+
+	*es256Generate = false
+	v, err := NewForAlgorithm(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(tkn); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAlgorithmBadToken(t *testing.T) {
+	if _, err := Algorithm("not a token"); err != token.ErrTokenInvalid {
+		t.Error(err)
+	}
+}
+
+func TestNewForAlgorithmUnknown(t *testing.T) {
+	if _, err := NewForAlgorithm("HS256"); err != token.ErrUnknownTokenService {
+		t.Error(err)
+	}
+}
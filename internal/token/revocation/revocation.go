@@ -0,0 +1,59 @@
+package revocation
+
+import (
+	"log"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A Store provides the revocation state for the tokens of an entity.
+// An error from the store is taken to mean that the entity is gone
+// and its tokens are no longer valid.
+type Store interface {
+	GetTokenRevocation(string) (*pb.TokenRevocation, error)
+}
+
+// Service wraps any token.Service and refuses tokens that have been
+// revoked.  Tokens are generated by the wrapped service unchanged.
+type Service struct {
+	token.Service
+
+	store Store
+}
+
+// New returns a token.Service which checks the store for revocations
+// before accepting a token validated by the wrapped service.
+func New(s token.Service, store Store) token.Service {
+	return &Service{
+		Service: s,
+		store:   store,
+	}
+}
+
+// Validate validates the token with the wrapped service and then
+// checks that it has not been revoked.
+func (s *Service) Validate(tkn string) (token.Claims, error) {
+	c, err := s.Service.Validate(tkn)
+	if err != nil {
+		return token.Claims{}, err
+	}
+
+	r, err := s.store.GetTokenRevocation(c.EntityID)
+	if err != nil {
+		log.Printf("Refusing token for '%s': %s", c.EntityID, err)
+		return token.Claims{}, token.ErrTokenInvalid
+	}
+
+	if c.IssuedAt.UnixNano() < r.GetIssuedBefore() {
+		return token.Claims{}, token.ErrTokenInvalid
+	}
+	for _, t := range r.GetTokens() {
+		if t.GetID() == c.ID {
+			return token.Claims{}, token.ErrTokenInvalid
+		}
+	}
+
+	return c, nil
+}
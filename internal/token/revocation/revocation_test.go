@@ -0,0 +1,88 @@
+package revocation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// dummyService hands back the claims it was given, keyed by the token
+// string.
+type dummyService map[string]token.Claims
+
+func (d dummyService) Generate(c token.Claims, _ token.Config) (string, error) {
+	d[c.ID] = c
+	return c.ID, nil
+}
+
+func (d dummyService) Validate(t string) (token.Claims, error) {
+	c, ok := d[t]
+	if !ok {
+		return token.Claims{}, token.ErrTokenInvalid
+	}
+	return c, nil
+}
+
+type dummyStore map[string]*pb.TokenRevocation
+
+func (d dummyStore) GetTokenRevocation(ID string) (*pb.TokenRevocation, error) {
+	r, ok := d[ID]
+	if !ok {
+		return nil, errors.New("no such entity")
+	}
+	return r, nil
+}
+
+func TestValidate(t *testing.T) {
+	issued := time.Unix(1000, 0)
+	ds := dummyService{}
+	store := dummyStore{
+		"foo": &pb.TokenRevocation{},
+		"bar": &pb.TokenRevocation{IssuedBefore: proto.Int64(issued.UnixNano() + 1)},
+		"baz": &pb.TokenRevocation{
+			IssuedBefore: proto.Int64(issued.UnixNano()),
+			Tokens: []*pb.RevokedToken{
+				{ID: proto.String("baz2")},
+			},
+		},
+	}
+	s := New(ds, store)
+
+	cases := []struct {
+		entity  string
+		id      string
+		wantErr error
+	}{
+		{"foo", "foo1", nil},
+		{"bar", "bar1", token.ErrTokenInvalid},
+		{"baz", "baz1", nil},
+		{"baz", "baz2", token.ErrTokenInvalid},
+		{"qux", "qux1", token.ErrTokenInvalid},
+	}
+	for i, c := range cases {
+		tkn, err := s.Generate(token.Claims{EntityID: c.entity, ID: c.id, IssuedAt: issued}, token.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := s.Validate(tkn)
+		if err != c.wantErr {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.wantErr)
+		}
+		if err == nil && claims.ID != c.id {
+			t.Errorf("%d: Wrong claims returned: %v", i, claims)
+		}
+	}
+}
+
+func TestValidateBadToken(t *testing.T) {
+	s := New(dummyService{}, dummyStore{})
+
+	if _, err := s.Validate("foo"); err != token.ErrTokenInvalid {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,8 @@
 package token
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"log"
 	"time"
@@ -17,6 +19,16 @@ type Service interface {
 	Validate(string) (Claims, error)
 }
 
+// A Rotator is a Service that can replace its signing key while
+// continuing to accept tokens signed by the keys it replaced.
+// Generated reports whether the signing key was generated when the
+// service was loaded, in which case it has never been used and there
+// is no need to replace it.
+type Rotator interface {
+	Rotate() error
+	Generated() bool
+}
+
 // The Config struct contains information that should be used when
 // generating a token.
 type Config struct {
@@ -30,7 +42,7 @@ type Config struct {
 var (
 	services map[string]Factory
 
-	impl     = flag.String("token_impl", "", "Token implementation to use")
+	impl     = flag.String("token_impl", "jwt-rsa", "Token implementation to use")
 	lifetime = flag.Duration("token_lifetime", time.Hour*10, "Token lifetime")
 	renewals = flag.Int("token_renewals", 5, "Maximum number of times the token may be renewed")
 )
@@ -75,6 +87,17 @@ func GetBackendList() []string {
 	return l
 }
 
+// NewID returns a random identifier which can be used to tell one
+// token apart from another.
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Could not generate token ID: %s", err)
+		return "", ErrInternalError
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // GetConfig returns a struct containing the configuration for the
 // token service to use while issuing tokens.
 func GetConfig() Config {
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/NetAuth/NetAuth/internal/db"
 	"github.com/golang/protobuf/proto"
 
 	pb "github.com/NetAuth/Protocol"
@@ -34,29 +36,67 @@ func (m *Manager) NewEntity(ID string, number int32, secret string) error {
 		}
 	}
 
-	// Ok, they don't exist so we'll make them exist now
+	// Ok, they don't exist so we'll make them exist now.  The
+	// secret is set separately below so that it is never saved
+	// in the clear.
 	newEntity := &pb.Entity{
 		ID:     &ID,
 		Number: &number,
-		Secret: &secret,
+		Secret: proto.String(""),
 		Meta:   &pb.EntityMeta{},
 	}
 
-	// Save the entity
-	if err := m.db.SaveEntity(newEntity); err != nil {
+	// An entity with this ID may have existed before, and the
+	// tokens that were issued to it must not be accepted for
+	// this one.
+	revokeAllTokens(newEntity)
+
+	// Give the hooks a chance to object before anything is
+	// written.
+	hd := &HookData{Point: HookNewEntity, Entity: newEntity, Secret: secret}
+	if err := m.runPreHooks(hd); err != nil {
 		return err
 	}
 
-	// Now we set the entity secret, this could be inlined, but
-	// having it in the separate function makes resetting the
-	// secret trivial.
-	if err := m.SetEntitySecretByID(ID, secret); err != nil {
+	// Save the entity and then set the entity secret, this could
+	// be inlined, but having it in the separate function makes
+	// resetting the secret trivial.  If the secret is refused
+	// the entity must not be left behind so that it can be
+	// retried cleanly.
+	saved := false
+	err := m.update(func(tm *Manager) error {
+		if err := tm.db.SaveEntity(newEntity); err != nil {
+			return err
+		}
+		saved = true
+		return tm.SetEntitySecretByID(ID, secret)
+	})
+	if err == ErrStaleRevision && !saved {
+		// Someone else created the entity since it was
+		// checked for above.
+		log.Printf("Entity with ID '%s' already exists!", ID)
+		return ErrDuplicateEntityID
+	}
+	if err != nil {
+		// Without transactions the entity was saved on its
+		// own and has to be removed again.  With them there
+		// is nothing to remove, and an entity that is there
+		// now belongs to someone else.
+		if _, ok := m.db.(db.Transactor); !ok && saved {
+			if derr := m.db.DeleteEntity(ID); derr != nil {
+				log.Printf("Could not remove partially created entity '%s': %s", ID, derr)
+			}
+		}
 		return err
 	}
 
 	// Successfully created we now return no errors
 	log.Printf("Created entity '%s'", ID)
 
+	if e, err := m.db.LoadEntity(ID); err == nil {
+		hd.Entity = e
+	}
+	m.runPostHooks(hd)
 	return nil
 }
 
@@ -155,11 +195,65 @@ func (m *Manager) DisableBootstrap() {
 // ID does not exist the function will return errors.E_NO_ENTITY, in
 // all other cases nil is returned.
 func (m *Manager) DeleteEntityByID(ID string) error {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return err
+	}
+
+	hd := &HookData{Point: HookDeleteEntityByID, Entity: e}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
 	if err := m.db.DeleteEntity(ID); err != nil {
 		return err
 	}
 	log.Printf("Deleted entity '%s'", ID)
 
+	m.runPostHooks(hd)
+	return nil
+}
+
+// RenameEntity changes the ID of an entity.  The number, secret, and
+// everything else about the entity stay the same.  Nothing else
+// refers to an entity by its ID.  Tokens are issued to an ID, so those
+// issued before the rename are refused under both IDs: the old ID no
+// longer exists, and the entity starts afresh under the new one in
+// case an entity by that name existed before.
+func (m *Manager) RenameEntity(oldID, newID string) error {
+	e, err := m.db.LoadEntity(oldID)
+	if err != nil {
+		return err
+	}
+	if _, err := m.db.LoadEntity(newID); err == nil {
+		log.Printf("Entity with ID '%s' already exists!", newID)
+		return ErrDuplicateEntityID
+	}
+
+	ne := proto.Clone(e).(*pb.Entity)
+	ne.ID = &newID
+	revokeAllTokens(ne)
+
+	hd := &HookData{Point: HookRenameEntity, Entity: ne, OldName: oldID}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	err = m.update(func(tm *Manager) error {
+		if err := tm.db.SaveEntity(ne); err != nil {
+			return err
+		}
+		return tm.db.DeleteEntity(oldID)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Renamed entity '%s' to '%s'", oldID, newID)
+
+	if e, err := m.db.LoadEntity(newID); err == nil {
+		hd.Entity = e
+	}
+	m.runPostHooks(hd)
 	return nil
 }
 
@@ -248,17 +342,28 @@ func (m *Manager) SetEntitySecretByID(ID string, secret string) error {
 		return err
 	}
 
+	hd := &HookData{Point: HookSetEntitySecretByID, Entity: e, Secret: secret}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	if err := m.checkSecretHistory(e, secret); err != nil {
+		return err
+	}
+
 	ssecret, err := m.crypto.SecureSecret(secret)
 	if err != nil {
 		return err
 	}
-	e.Secret = &ssecret
+	m.rotateSecret(e, ssecret)
+	e.SecretChanged = proto.Int64(time.Now().Unix())
 
 	if err := m.db.SaveEntity(e); err != nil {
 		return err
 	}
 
 	log.Printf("Secret set for '%s'", e.GetID())
+	m.runPostHooks(hd)
 	return nil
 }
 
@@ -270,16 +375,43 @@ func (m *Manager) ValidateSecret(ID string, secret string) error {
 		return err
 	}
 
+	hd := &HookData{Point: HookValidateSecret, Entity: e, Secret: secret}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	// Post hooks for validation run on failure as well as on
+	// success, since its the outcome that is interesting.
+	hd.Err = m.validateSecret(e, secret)
+	m.runPostHooks(hd)
+	return hd.Err
+}
+
+// validateSecret performs the actual check of the secret against the
+// entity.
+func (m *Manager) validateSecret(e *pb.Entity, secret string) error {
 	// Locked entities can't validate.
 	if e.GetMeta().GetLocked() {
 		return ErrEntityLocked
 	}
 
-	err = m.crypto.VerifySecret(secret, *e.Secret)
-	if err != nil {
+	if err := m.crypto.VerifySecret(secret, e.GetSecret()); err != nil {
 		log.Printf("Failed to authenticate '%s'", e.GetID())
 		return err
 	}
+
+	// Secrets secured by an outdated algorithm or cost are
+	// secured again now that the plaintext is known to be good.
+	if err := m.rehashSecret(e, secret); err != nil {
+		log.Printf("Failed to rehash secret for '%s': %s", e.GetID(), err)
+	}
+
+	// The secret was correct, but may be too old to be used for
+	// anything other than changing it.
+	if m.secretExpired(e) {
+		log.Printf("Secret for '%s' has expired", e.GetID())
+		return ErrSecretExpired
+	}
 	log.Printf("Successfully authenticated '%s'", e.GetID())
 
 	return nil
@@ -298,7 +430,14 @@ func (m *Manager) GetEntity(ID string) (*pb.Entity, error) {
 	// The safeCopyEntity will return the entity without secrets
 	// in it, as well as an error if there were problems
 	// marshaling the proto back and forth.
-	return safeCopyEntity(e), nil
+	dup := safeCopyEntity(e)
+
+	// Let the caller know when the secret will need to be
+	// changed.
+	if exp := m.secretExpiry(e); !exp.IsZero() {
+		dup.SecretExpires = proto.Int64(exp.Unix())
+	}
+	return dup, nil
 }
 
 func (m *Manager) updateEntityMeta(e *pb.Entity, newMeta *pb.EntityMeta) error {
@@ -321,13 +460,19 @@ func (m *Manager) updateEntityMeta(e *pb.Entity, newMeta *pb.EntityMeta) error {
 }
 
 // UpdateEntityMeta drives the internal version by obtaining the
-// entity from the database based on the ID.
-func (m *Manager) UpdateEntityMeta(entityID string, newMeta *pb.EntityMeta) error {
+// entity from the database based on the ID.  If revision is not 0 the
+// update is refused with ErrStaleRevision unless the entity is still
+// at that revision.
+func (m *Manager) UpdateEntityMeta(entityID string, revision uint64, newMeta *pb.EntityMeta) error {
 	e, err := m.db.LoadEntity(entityID)
 	if err != nil {
 		return err
 	}
 
+	if err := checkRevision(e.GetRevision(), revision); err != nil {
+		return err
+	}
+
 	return m.updateEntityMeta(e, newMeta)
 }
 
@@ -403,6 +548,12 @@ func (m *Manager) setEntityLockState(entityID string, locked bool) error {
 	// update state
 	e.Meta.Locked = &locked
 
+	// Locked entities must not be able to keep using tokens they
+	// already hold.
+	if locked {
+		revokeAllTokens(e)
+	}
+
 	// Save changes
 	if err := m.db.SaveEntity(e); err != nil {
 		return err
@@ -413,13 +564,37 @@ func (m *Manager) setEntityLockState(entityID string, locked bool) error {
 // LockEntity allows external callers to lock entities directly.
 // Internal users can just set the value directly.
 func (m *Manager) LockEntity(entityID string) error {
-	return m.setEntityLockState(entityID, true)
+	return m.hookedLockState(HookLockEntity, entityID, true)
 }
 
 // UnlockEntity allows external callers to lock entities directly.
 // Internal users can just set the value directly.
 func (m *Manager) UnlockEntity(entityID string) error {
-	return m.setEntityLockState(entityID, false)
+	return m.hookedLockState(HookUnlockEntity, entityID, false)
+}
+
+// hookedLockState wraps setEntityLockState with the hooks for the
+// given point.
+func (m *Manager) hookedLockState(p HookPoint, entityID string, locked bool) error {
+	e, err := m.db.LoadEntity(entityID)
+	if err != nil {
+		return err
+	}
+
+	hd := &HookData{Point: p, Entity: e}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	if err := m.setEntityLockState(entityID, locked); err != nil {
+		return err
+	}
+
+	if e, err := m.db.LoadEntity(entityID); err == nil {
+		hd.Entity = e
+	}
+	m.runPostHooks(hd)
+	return nil
 }
 
 // safeCopyEntity makes a copy of the entity provided but removes
@@ -431,6 +606,9 @@ func safeCopyEntity(e *pb.Entity) *pb.Entity {
 
 	// Fields for security are nulled out before returning.
 	dup.Secret = proto.String("<REDACTED>")
+	dup.AuthFailures = nil
+	dup.SecretHistory = nil
+	dup.TokenRevocation = nil
 
 	return dup
 }
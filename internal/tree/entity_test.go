@@ -205,6 +205,56 @@ func TestDeleteEntityAgain(t *testing.T) {
 	}
 }
 
+func TestRenameEntity(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	for _, id := range []string{"foo", "bar"} {
+		if err := em.NewEntity(id, -1, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := em.NewGroup("grp", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("foo", "grp"); err != nil {
+		t.Fatal(err)
+	}
+	old, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.RenameEntity("foo", "bar"); err != ErrDuplicateEntityID {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := em.RenameEntity("missing", "baz"); err != db.ErrUnknownEntity {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	if err := em.RenameEntity("foo", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.db.LoadEntity("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	e, err := em.GetEntity("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetNumber() != old.GetNumber() {
+		t.Errorf("Number changed in the rename: %d != %d", e.GetNumber(), old.GetNumber())
+	}
+	if err := em.ValidateSecret("baz", "secret"); err != nil {
+		t.Errorf("Secret did not survive the rename: %v", err)
+	}
+	if got := em.GetMemberships(e, true); !slicesAreEqual(got, []string{"grp"}) {
+		t.Errorf("Wrong memberships: %v", got)
+	}
+	if members, err := em.listMembers("grp"); err != nil || len(members) != 1 || members[0].GetID() != "baz" {
+		t.Errorf("Wrong members: %v %v", members, err)
+	}
+}
+
 func TestSetSameCapabilityTwice(t *testing.T) {
 	em := getNewEntityManager(t)
 
@@ -385,11 +435,22 @@ func TestGetEntity(t *testing.T) {
 		t.Error(err)
 	}
 
+	// The time the secret was set isn't known ahead of time,
+	// but it must have been set.
+	if entity.GetSecretChanged() == 0 {
+		t.Error("Secret change time was not recorded")
+	}
+
 	entityTest := &pb.Entity{
-		ID:     proto.String("foo"),
-		Number: proto.Int32(1),
-		Secret: proto.String("<REDACTED>"),
-		Meta:   &pb.EntityMeta{},
+		ID:            proto.String("foo"),
+		Number:        proto.Int32(1),
+		Secret:        proto.String("<REDACTED>"),
+		Meta:          &pb.EntityMeta{},
+		SecretChanged: proto.Int64(entity.GetSecretChanged()),
+
+		// Creating the entity and setting its secret are
+		// separate saves.
+		Revision: proto.Uint64(2),
 	}
 
 	if !proto.Equal(entity, entityTest) {
@@ -415,7 +476,7 @@ func TestUpdateEntityMetaInternal(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	em.UpdateEntityMeta(e.GetID(), fullMeta)
+	em.UpdateEntityMeta(e.GetID(), 0, fullMeta)
 
 	// Verify that the update above took
 	if e.GetMeta().GetLegalName() != "Foobert McMillan" {
@@ -428,7 +489,7 @@ func TestUpdateEntityMetaInternal(t *testing.T) {
 	badMeta := &pb.EntityMeta{
 		Groups: groups,
 	}
-	em.UpdateEntityMeta(e.GetID(), badMeta)
+	em.UpdateEntityMeta(e.GetID(), 0, badMeta)
 
 	// The update from badMeta should not have gone through, and
 	// the old value should still be present.
@@ -443,7 +504,7 @@ func TestUpdateEntityMetaInternal(t *testing.T) {
 func TestUpdateEntityMetaExternalNoEntity(t *testing.T) {
 	em := getNewEntityManager(t)
 
-	if err := em.UpdateEntityMeta("non-existent", nil); err != db.ErrUnknownEntity {
+	if err := em.UpdateEntityMeta("non-existent", 0, nil); err != db.ErrUnknownEntity {
 		t.Fatal(err)
 	}
 }
@@ -564,9 +625,13 @@ func TestSafeCopyEntity(t *testing.T) {
 	if e.GetSecret() == ne.GetSecret() {
 		t.Error("Secret field not obscured!")
 	}
+	if ne.GetTokenRevocation() != nil {
+		t.Error("Token revocation not removed!")
+	}
 
 	e.Secret = proto.String("")
 	ne.Secret = proto.String("")
+	e.TokenRevocation = nil
 
 	if !proto.Equal(e, ne) {
 		t.Error("Entity values not otherwise equal!")
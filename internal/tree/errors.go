@@ -1,6 +1,10 @@
 package tree
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	// ErrDuplicateEntityID is returned when the entity ID
@@ -29,4 +33,65 @@ var (
 	// authenticate or change secrets.  They are effectively dead
 	// to the system.
 	ErrEntityLocked = errors.New("this entity is locked")
+
+	// ErrSecretExpired is returned when an entity presents the
+	// correct secret, but the secret is too old to be used.  The
+	// only thing an entity can do with an expired secret is
+	// change it.
+	ErrSecretExpired = errors.New("this secret has expired and must be changed")
+
+	// ErrUnknownHook is returned when a hook is requested that
+	// has not been registered, or that cannot be run.
+	ErrUnknownHook = errors.New("the hook specified is unknown")
+
+	// ErrUnknownHookPoint is returned when a hook is requested to
+	// be attached to a function that does not support hooks.
+	ErrUnknownHookPoint = errors.New("the hook point specified is unknown")
+
+	// ErrUnknownSecretFormat is returned when a secured secret is
+	// imported that no crypto engine is able to verify.
+	ErrUnknownSecretFormat = errors.New("the secured secret is not in a known format")
+
+	// ErrStaleRevision is returned when a change is made on the
+	// basis of a revision of a record that has since been
+	// replaced.  The record should be read again and the change
+	// reapplied.
+	ErrStaleRevision = errors.New("the record has been changed since the requested revision")
 )
+
+// A PolicyError is returned when a hook refuses to allow an
+// operation to proceed.  The reason is meant to be read by a human
+// and should explain what needs to change for the request to
+// succeed.
+type PolicyError struct {
+	Hook   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("refused by policy %s: %s", e.Hook, e.Reason)
+}
+
+// A ReferenceError is returned when a record can't be deleted because
+// other records still refer to it.  Each of the references is listed
+// so that they can be cleaned up, or the delete can be forced to
+// clean them up automatically.
+type ReferenceError struct {
+	Name       string
+	References []string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("%s is still referenced: %s", e.Name, strings.Join(e.References, "; "))
+}
+
+// A SecretPolicyError is returned when a proposed secret does not
+// meet the requirements of the secret policy.  The reason is meant to
+// be shown to the user so that they can choose a better secret.
+type SecretPolicyError struct {
+	Reason string
+}
+
+func (e *SecretPolicyError) Error() string {
+	return fmt.Sprintf("secret does not meet policy: %s", e.Reason)
+}
@@ -0,0 +1,235 @@
+package tree
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// These are the names of the checks that Fsck performs, which are
+// reported with each problem that is found.
+const (
+	FsckDanglingMembership   = "dangling-membership"
+	FsckDanglingPrimaryGroup = "dangling-primary-group"
+	FsckBrokenExpansion      = "broken-expansion"
+	FsckDanglingManager      = "dangling-manager"
+	FsckDuplicateNumber      = "duplicate-number"
+	FsckMissingSecret        = "missing-secret"
+	FsckExpansionCycle       = "expansion-cycle"
+)
+
+// Fsck walks every entity and group looking for references to groups
+// that don't exist, including primary groups, numbers that are used
+// more than once, entities that have no secret, and groups that
+// expand back to themselves.  If repair is set, references to groups
+// that don't exist are removed and the fixes are all saved together.  Other problems need a person
+// to decide what to do and are only reported.
+func (m *Manager) Fsck(repair bool) ([]*pb.FsckProblem, error) {
+	var problems []*pb.FsckProblem
+	err := m.update(func(tm *Manager) error {
+		var err error
+		problems, err = tm.fsck(repair)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+func (m *Manager) fsck(repair bool) ([]*pb.FsckProblem, error) {
+	var problems []*pb.FsckProblem
+	report := func(check, record, detail string, repaired bool) {
+		problems = append(problems, &pb.FsckProblem{
+			Check:    proto.String(check),
+			Record:   proto.String(record),
+			Detail:   proto.String(detail),
+			Repaired: proto.Bool(repaired),
+		})
+	}
+
+	groups, err := m.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GetName() < groups[j].GetName() })
+	exists := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		exists[g.GetName()] = true
+	}
+
+	entities, err := m.allEntities()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entities, func(i, j int) bool { return entities[i].GetID() < entities[j].GetID() })
+
+	entityNumbers := make(map[int32][]string)
+	for _, e := range entities {
+		entityNumbers[e.GetNumber()] = append(entityNumbers[e.GetNumber()], e.GetID())
+
+		if e.GetSecret() == "" {
+			report(FsckMissingSecret, e.GetID(), "entity has no secret and cannot authenticate", false)
+		}
+
+		changed := false
+		if pg := e.GetMeta().GetPrimaryGroup(); pg != "" && !exists[pg] {
+			report(FsckDanglingPrimaryGroup, e.GetID(), fmt.Sprintf("primary group %s is missing", pg), repair)
+			if repair {
+				e.Meta.PrimaryGroup = proto.String("")
+				changed = true
+			}
+		}
+
+		var keep []string
+		for _, name := range e.GetMeta().GetGroups() {
+			if exists[name] {
+				keep = append(keep, name)
+				continue
+			}
+			report(FsckDanglingMembership, e.GetID(), fmt.Sprintf("member of missing group %s", name), repair)
+		}
+		if repair && len(keep) != len(e.GetMeta().GetGroups()) {
+			e.Meta.Groups = keep
+			changed = true
+		}
+
+		if changed {
+			if err := m.db.SaveEntity(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	groupNumbers := make(map[int32][]string)
+	for _, g := range groups {
+		groupNumbers[g.GetNumber()] = append(groupNumbers[g.GetNumber()], g.GetName())
+		changed := false
+
+		if g.GetManagedBy() != "" && !exists[g.GetManagedBy()] {
+			report(FsckDanglingManager, g.GetName(), fmt.Sprintf("managed by missing group %s", g.GetManagedBy()), repair)
+			if repair {
+				g.ManagedBy = proto.String("")
+				changed = true
+			}
+		}
+
+		var keep []string
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			switch {
+			case len(parts) != 2:
+				report(FsckBrokenExpansion, g.GetName(), fmt.Sprintf("malformed expansion %s", exp), repair)
+			case !exists[parts[1]]:
+				report(FsckBrokenExpansion, g.GetName(), fmt.Sprintf("expansion %s of missing group", exp), repair)
+			default:
+				keep = append(keep, exp)
+			}
+		}
+		if len(keep) != len(g.GetExpansions()) && repair {
+			g.Expansions = keep
+			changed = true
+		}
+
+		if changed {
+			if err := m.db.SaveGroup(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, n := range sortedDuplicates(entityNumbers) {
+		report(FsckDuplicateNumber, strings.Join(entityNumbers[n], ","), fmt.Sprintf("entities share number %d", n), false)
+	}
+	for _, n := range sortedDuplicates(groupNumbers) {
+		report(FsckDuplicateNumber, strings.Join(groupNumbers[n], ","), fmt.Sprintf("groups share number %d", n), false)
+	}
+
+	for _, cycle := range findExpansionCycles(groups) {
+		report(FsckExpansionCycle, cycle[0], strings.Join(cycle, " -> "), false)
+	}
+
+	for _, p := range problems {
+		log.Printf("fsck: %s %s: %s (repaired: %t)", p.GetCheck(), p.GetRecord(), p.GetDetail(), p.GetRepaired())
+	}
+	return problems, nil
+}
+
+// sortedDuplicates returns the numbers that are used by more than one
+// record, in order.
+func sortedDuplicates(numbers map[int32][]string) []int32 {
+	var dups []int32
+	for n, names := range numbers {
+		if len(names) > 1 {
+			dups = append(dups, n)
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i] < dups[j] })
+	return dups
+}
+
+// findExpansionCycles returns each loop of expansions among the
+// groups, as the path from a group back round to itself.  Expansions
+// of groups that don't exist are ignored.  The groups must be sorted
+// so that the same cycles are reported the same way every time.
+func findExpansionCycles(groups []*pb.Group) [][]string {
+	children := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		children[g.GetName()] = nil
+	}
+	for _, g := range groups {
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if _, ok := children[parts[1]]; ok {
+				children[g.GetName()] = append(children[g.GetName()], parts[1])
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(groups))
+	var path []string
+	var cycles [][]string
+
+	var visit func(string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		for _, child := range children[name] {
+			switch state[child] {
+			case unvisited:
+				visit(child)
+			case visiting:
+				// Everything on the path since child is
+				// part of the loop.
+				for i := range path {
+					if path[i] == child {
+						cycle := append([]string{}, path[i:]...)
+						cycles = append(cycles, append(cycle, child))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+	}
+	for _, g := range groups {
+		if state[g.GetName()] == unvisited {
+			visit(g.GetName())
+		}
+	}
+	return cycles
+}
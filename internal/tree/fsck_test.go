@@ -0,0 +1,152 @@
+package tree
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// newBrokenTree writes records straight to the database, since the
+// Manager won't create most of these problems itself.
+func newBrokenTree(t *testing.T) *Manager {
+	em := getNewEntityManager(t)
+
+	if err := em.NewEntity("ok", 10, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	records := []interface{}{
+		&pb.Entity{
+			ID:     proto.String("dangling"),
+			Number: proto.Int32(11),
+			Secret: proto.String("secret"),
+			Meta:   &pb.EntityMeta{PrimaryGroup: proto.String("gone"), Groups: []string{"a", "gone"}},
+		},
+		&pb.Entity{ID: proto.String("nosecret"), Number: proto.Int32(11)},
+		&pb.Group{
+			Name:       proto.String("a"),
+			Number:     proto.Int32(1),
+			ManagedBy:  proto.String("gone"),
+			Expansions: []string{"INCLUDE:b", "EXCLUDE:gone", "bogus"},
+		},
+		&pb.Group{Name: proto.String("b"), Number: proto.Int32(1), Expansions: []string{"INCLUDE:a"}},
+	}
+	for _, r := range records {
+		var err error
+		switch r := r.(type) {
+		case *pb.Entity:
+			err = em.db.SaveEntity(r)
+		case *pb.Group:
+			err = em.db.SaveGroup(r)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return em
+}
+
+func checkProblems(t *testing.T, got []*pb.FsckProblem, want []*pb.FsckProblem) {
+	if len(got) != len(want) {
+		t.Fatalf("Got %d problems, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("Problem %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func problem(check, record, detail string, repaired bool) *pb.FsckProblem {
+	return &pb.FsckProblem{
+		Check:    proto.String(check),
+		Record:   proto.String(record),
+		Detail:   proto.String(detail),
+		Repaired: proto.Bool(repaired),
+	}
+}
+
+func TestFsck(t *testing.T) {
+	em := newBrokenTree(t)
+
+	want := func(repaired bool) []*pb.FsckProblem {
+		return []*pb.FsckProblem{
+			problem(FsckDanglingPrimaryGroup, "dangling", "primary group gone is missing", repaired),
+			problem(FsckDanglingMembership, "dangling", "member of missing group gone", repaired),
+			problem(FsckMissingSecret, "nosecret", "entity has no secret and cannot authenticate", false),
+			problem(FsckDanglingManager, "a", "managed by missing group gone", repaired),
+			problem(FsckBrokenExpansion, "a", "expansion EXCLUDE:gone of missing group", repaired),
+			problem(FsckBrokenExpansion, "a", "malformed expansion bogus", repaired),
+			problem(FsckDuplicateNumber, "dangling,nosecret", "entities share number 11", false),
+			problem(FsckDuplicateNumber, "a,b", "groups share number 1", false),
+			problem(FsckExpansionCycle, "a", "a -> b -> a", false),
+		}
+	}
+
+	// Checking without repairing changes nothing.
+	problems, err := em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, want(false))
+	problems, err = em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, want(false))
+
+	problems, err = em.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, want(true))
+
+	// Only the problems that can't be repaired are left.
+	problems, err = em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, []*pb.FsckProblem{
+		problem(FsckMissingSecret, "nosecret", "entity has no secret and cannot authenticate", false),
+		problem(FsckDuplicateNumber, "dangling,nosecret", "entities share number 11", false),
+		problem(FsckDuplicateNumber, "a,b", "groups share number 1", false),
+		problem(FsckExpansionCycle, "a", "a -> b -> a", false),
+	})
+
+	e, err := em.GetEntity("dangling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slicesAreEqual(e.GetMeta().GetGroups(), []string{"a"}) {
+		t.Errorf("Wrong groups after repair: %v", e.GetMeta().GetGroups())
+	}
+	if e.GetMeta().GetPrimaryGroup() != "" {
+		t.Errorf("Wrong primary group after repair: %s", e.GetMeta().GetPrimaryGroup())
+	}
+	g, err := em.GetGroupByName("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetManagedBy() != "" || !slicesAreEqual(g.GetExpansions(), []string{"INCLUDE:b"}) {
+		t.Errorf("Wrong group after repair: %v", g)
+	}
+}
+
+func TestFsckNoTxn(t *testing.T) {
+	em := getNewNoTxnEntityManager(t)
+	em.db.SaveEntity(&pb.Entity{
+		ID:     proto.String("foo"),
+		Secret: proto.String("secret"),
+		Meta:   &pb.EntityMeta{Groups: []string{"gone"}},
+	})
+
+	if _, err := em.Fsck(true); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, problems, nil)
+}
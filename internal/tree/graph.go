@@ -0,0 +1,305 @@
+package tree
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// The membership graph is an in-memory copy of the arrows between
+// entities and groups.  The entities only record the groups they are
+// directly in, so without it finding the members of a group means
+// loading every entity.  The graph is built from the database the
+// first time it is needed, and then kept up to date by indexedDB,
+// which sees every write.
+type membershipGraph struct {
+	sync.Mutex
+
+	loaded bool
+
+	// entityGroups holds the direct groups of each entity, and
+	// directMembers is the same thing pointing the other way.
+	entityGroups  map[string][]string
+	directMembers map[string]map[string]struct{}
+
+	// expansions holds the expansions of each group that exists.
+	expansions map[string][]string
+
+	// The effective members of every group, and the effective
+	// groups of every entity, are computed when first asked for
+	// and thrown away on any change.
+	effective       map[string]map[string]struct{}
+	effectiveGroups map[string][]string
+}
+
+func newMembershipGraph() *membershipGraph {
+	return &membershipGraph{}
+}
+
+// load builds the graph from the database if it has not been built
+// yet.  The caller must hold the lock.
+func (mg *membershipGraph) load(d db.DB) error {
+	if mg.loaded {
+		return nil
+	}
+
+	mg.entityGroups = make(map[string][]string)
+	mg.directMembers = make(map[string]map[string]struct{})
+	mg.expansions = make(map[string][]string)
+	mg.invalidate()
+
+	IDs, err := d.DiscoverEntityIDs()
+	if err != nil {
+		return err
+	}
+	for _, ID := range IDs {
+		e, err := d.LoadEntity(ID)
+		if err != nil {
+			return err
+		}
+		mg.setEntity(ID, e.GetMeta().GetGroups())
+	}
+
+	names, err := d.DiscoverGroupNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		g, err := d.LoadGroup(name)
+		if err != nil {
+			return err
+		}
+		mg.expansions[name] = g.GetExpansions()
+	}
+
+	mg.loaded = true
+	return nil
+}
+
+func (mg *membershipGraph) invalidate() {
+	mg.effective = make(map[string]map[string]struct{})
+	mg.effectiveGroups = nil
+}
+
+// setEntity replaces the direct groups of an entity.  The caller
+// must hold the lock.
+func (mg *membershipGraph) setEntity(ID string, groups []string) {
+	mg.removeEntity(ID)
+	mg.entityGroups[ID] = append([]string{}, groups...)
+	for _, g := range groups {
+		if mg.directMembers[g] == nil {
+			mg.directMembers[g] = make(map[string]struct{})
+		}
+		mg.directMembers[g][ID] = struct{}{}
+	}
+	mg.invalidate()
+}
+
+// removeEntity drops an entity from the graph.  The caller must hold
+// the lock.
+func (mg *membershipGraph) removeEntity(ID string) {
+	for _, g := range mg.entityGroups[ID] {
+		delete(mg.directMembers[g], ID)
+		if len(mg.directMembers[g]) == 0 {
+			delete(mg.directMembers, g)
+		}
+	}
+	delete(mg.entityGroups, ID)
+	mg.invalidate()
+}
+
+// apply updates the graph with a change that was made to the
+// database.  Changes made before the graph is loaded are ignored
+// since they'll be read when it is.
+func (mg *membershipGraph) apply(op db.Op) {
+	mg.Lock()
+	defer mg.Unlock()
+	if !mg.loaded {
+		return
+	}
+
+	switch op.Kind {
+	case db.OpSaveEntity:
+		mg.setEntity(op.Name, op.Entity.GetMeta().GetGroups())
+	case db.OpDeleteEntity:
+		mg.removeEntity(op.Name)
+	case db.OpSaveGroup:
+		mg.expansions[op.Name] = append([]string{}, op.Group.GetExpansions()...)
+		mg.invalidate()
+	case db.OpDeleteGroup:
+		delete(mg.expansions, op.Name)
+		mg.invalidate()
+	}
+}
+
+// members returns the IDs of the effective members of a group, that
+// is the direct members and those brought in by expansions, less any
+// that are excluded.
+func (mg *membershipGraph) members(d db.DB, group string) ([]string, error) {
+	mg.Lock()
+	defer mg.Unlock()
+	if err := mg.load(d); err != nil {
+		return nil, err
+	}
+	if _, ok := mg.expansions[group]; !ok {
+		return nil, db.ErrUnknownGroup
+	}
+
+	set, _ := mg.effectiveMembers(group, make(map[string]bool))
+	out := make([]string, 0, len(set))
+	for ID := range set {
+		out = append(out, ID)
+	}
+	return out, nil
+}
+
+// groups returns the names of the groups that an entity is an
+// effective member of.
+func (mg *membershipGraph) groups(d db.DB, ID string) ([]string, error) {
+	mg.Lock()
+	defer mg.Unlock()
+	if err := mg.load(d); err != nil {
+		return nil, err
+	}
+
+	if mg.effectiveGroups == nil {
+		mg.effectiveGroups = make(map[string][]string)
+		for g := range mg.expansions {
+			set, _ := mg.effectiveMembers(g, make(map[string]bool))
+			for member := range set {
+				mg.effectiveGroups[member] = append(mg.effectiveGroups[member], g)
+			}
+		}
+		for _, l := range mg.effectiveGroups {
+			sort.Strings(l)
+		}
+	}
+	return append([]string{}, mg.effectiveGroups[ID]...), nil
+}
+
+// effectiveMembers computes the members of a group, remembering the
+// result until the graph changes.  Groups that are being computed are
+// tracked in visiting, and a group that expands back to itself sees
+// itself as empty rather than recursing forever.  Groups that don't
+// exist have no members.  The caller must hold the lock.
+//
+// A group inside a cycle that is reached part way round it is missing
+// the members that come from further round, so partial reports
+// whether the result was cut short by a group still being computed.
+// Such results are not remembered, as they are only correct from the
+// point of view of the group the computation started at.
+func (mg *membershipGraph) effectiveMembers(group string, visiting map[string]bool) (set map[string]struct{}, partial bool) {
+	if set, ok := mg.effective[group]; ok {
+		return set, false
+	}
+	if _, ok := mg.expansions[group]; !ok {
+		return nil, false
+	}
+	if visiting[group] {
+		return nil, true
+	}
+	visiting[group] = true
+	defer delete(visiting, group)
+
+	set = make(map[string]struct{}, len(mg.directMembers[group]))
+	for ID := range mg.directMembers[group] {
+		set[ID] = struct{}{}
+	}
+
+	var exclude []map[string]struct{}
+	for _, exp := range mg.expansions[group] {
+		parts := strings.SplitN(exp, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		child, cut := mg.effectiveMembers(parts[1], visiting)
+		partial = partial || cut
+		switch parts[0] {
+		case pb.ExpansionMode_INCLUDE.String():
+			for ID := range child {
+				set[ID] = struct{}{}
+			}
+		case pb.ExpansionMode_EXCLUDE.String():
+			exclude = append(exclude, child)
+		}
+	}
+	for _, x := range exclude {
+		for ID := range x {
+			delete(set, ID)
+		}
+	}
+
+	// The group the computation started at has everything, since
+	// any cycle it is in leads back to it.
+	if !partial || len(visiting) == 1 {
+		mg.effective[group] = set
+		partial = false
+	}
+	return set, partial
+}
+
+// indexedDB passes everything through to the database it wraps, and
+// tells the membership graph about every change that is made.
+type indexedDB struct {
+	db.DB
+	graph *membershipGraph
+}
+
+// SaveEntity saves the entity and updates its memberships.
+func (d indexedDB) SaveEntity(e *pb.Entity) error {
+	if err := d.DB.SaveEntity(e); err != nil {
+		return err
+	}
+	d.graph.apply(db.Op{Kind: db.OpSaveEntity, Name: e.GetID(), Entity: e})
+	return nil
+}
+
+// DeleteEntity deletes the entity and its memberships.
+func (d indexedDB) DeleteEntity(ID string) error {
+	if err := d.DB.DeleteEntity(ID); err != nil {
+		return err
+	}
+	d.graph.apply(db.Op{Kind: db.OpDeleteEntity, Name: ID})
+	return nil
+}
+
+// SaveGroup saves the group and updates its expansions.
+func (d indexedDB) SaveGroup(g *pb.Group) error {
+	if err := d.DB.SaveGroup(g); err != nil {
+		return err
+	}
+	d.graph.apply(db.Op{Kind: db.OpSaveGroup, Name: g.GetName(), Group: g})
+	return nil
+}
+
+// DeleteGroup deletes the group and its expansions.
+func (d indexedDB) DeleteGroup(name string) error {
+	if err := d.DB.DeleteGroup(name); err != nil {
+		return err
+	}
+	d.graph.apply(db.Op{Kind: db.OpDeleteGroup, Name: name})
+	return nil
+}
+
+// Update runs a transaction on the wrapped database if it supports
+// them.  The graph is only told about the changes once they have been
+// committed.
+func (d indexedDB) Update(fn func(db.DB) error) error {
+	t, ok := d.DB.(db.Transactor)
+	if !ok {
+		return fn(d)
+	}
+
+	ops, err := db.UpdateAndRecord(t, fn)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		d.graph.apply(op)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func TestGraphKeptInSync(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	for _, g := range []string{"grp1", "grp2", "grp3"} {
+		if err := em.NewGroup(g, "", "", -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.NewEntity("bar", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The graph is built on first use.
+	if g := em.GetMemberships(e, true); len(g) != 0 {
+		t.Errorf("Memberships in an empty tree: %v", g)
+	}
+
+	s := []struct {
+		change func() error
+		want   []string
+	}{
+		{func() error { return em.AddEntityToGroup("foo", "grp2") }, []string{"grp2"}},
+		{func() error { return em.ModifyGroupExpansions("grp1", "grp2", pb.ExpansionMode_INCLUDE) }, []string{"grp1", "grp2"}},
+		{func() error { return em.ModifyGroupExpansions("grp3", "grp1", pb.ExpansionMode_INCLUDE) }, []string{"grp1", "grp2", "grp3"}},
+		{func() error { return em.ModifyGroupExpansions("grp1", "grp2", pb.ExpansionMode_DROP) }, []string{"grp2"}},
+		{func() error { return em.AddEntityToGroup("foo", "grp3") }, []string{"grp2", "grp3"}},
+		{func() error { return em.RemoveEntityFromGroup("foo", "grp2") }, []string{"grp3"}},
+		{func() error { return em.DeleteGroup("grp3", true) }, []string{}},
+	}
+
+	for i, c := range s {
+		if err := c.change(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got := em.GetMemberships(e, true); !slicesAreEqual(got, c.want) {
+			t.Errorf("%d: Got %v; Want %v", i, got, c.want)
+		}
+	}
+
+	// Deleted entities leave the graph as well.
+	if err := em.AddEntityToGroup("bar", "grp1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.DeleteEntityByID("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := em.listMembers("grp1"); err != nil || len(members) != 0 {
+		t.Errorf("Deleted entity is still a member: %v %v", members, err)
+	}
+}
+
+func TestGraphExclude(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	for _, g := range []string{"all", "some", "banned"} {
+		if err := em.NewGroup(g, "", "", -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []string{"foo", "bar"} {
+		if err := em.NewEntity(e, -1, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := em.AddEntityToGroup(e, "some"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := em.AddEntityToGroup("bar", "banned"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ModifyGroupExpansions("all", "some", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ModifyGroupExpansions("all", "banned", pb.ExpansionMode_EXCLUDE); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := em.graph.members(em.db, "all")
+	if err != nil || !slicesAreEqual(members, []string{"foo"}) {
+		t.Errorf("Wrong members: %v %v", members, err)
+	}
+
+	// An excluded entity that is also a direct member is only a
+	// member directly.
+	if err := em.AddEntityToGroup("bar", "all"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := em.GetMemberships(e, false); !slicesAreEqual(got, []string{"some", "banned"}) {
+		t.Errorf("Wrong direct memberships: %v", got)
+	}
+}
+
+func TestGraphMissingAndCyclicGroups(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	// Write the groups directly, since the Manager refuses to
+	// create cycles or expand to missing groups.
+	groups := []*pb.Group{
+		{Name: proto.String("a"), Expansions: []string{"INCLUDE:b", "INCLUDE:missing"}},
+		{Name: proto.String("b"), Expansions: []string{"INCLUDE:a"}},
+	}
+	for _, g := range groups {
+		if err := em.db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := &pb.Entity{
+		ID:   proto.String("foo"),
+		Meta: &pb.EntityMeta{Groups: []string{"b", "missing"}},
+	}
+	if err := em.db.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// Working out b first reaches a part way round the cycle,
+	// which must not leave a short of the members it gets from b.
+	if got, err := em.graph.members(em.db, "b"); err != nil || !slicesAreEqual(got, []string{"foo"}) {
+		t.Errorf("Wrong members of b: %v %v", got, err)
+	}
+	if got, err := em.graph.members(em.db, "a"); err != nil || !slicesAreEqual(got, []string{"foo"}) {
+		t.Errorf("Wrong members of a: %v %v", got, err)
+	}
+
+	if got := em.GetMemberships(e, true); !slicesAreEqual(got, []string{"a", "b"}) {
+		t.Errorf("Wrong memberships: %v", got)
+	}
+	if _, err := em.graph.members(em.db, "missing"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+}
+
+func TestGraphTransactionRollback(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewGroup("grp1", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := em.GetMemberships(e, true); len(g) != 0 {
+		t.Fatalf("Unexpected memberships: %v", g)
+	}
+
+	errAbort := errors.New("abort")
+	err = em.update(func(tm *Manager) error {
+		if err := tm.AddEntityToGroup("foo", "grp1"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+	if g := em.GetMemberships(e, true); len(g) != 0 {
+		t.Errorf("Rolled back membership is in the graph: %v", g)
+	}
+
+	err = em.update(func(tm *Manager) error {
+		return tm.AddEntityToGroup("foo", "grp1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := em.GetMemberships(e, true); !slicesAreEqual(g, []string{"grp1"}) {
+		t.Errorf("Committed membership is not in the graph: %v", g)
+	}
+}
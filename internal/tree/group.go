@@ -1,7 +1,9 @@
 package tree
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 
 	"github.com/golang/protobuf/proto"
@@ -38,12 +40,22 @@ func (m *Manager) NewGroup(name, displayName, managedBy string, number int32) er
 		ManagedBy:   &managedBy,
 	}
 
+	hd := &HookData{Point: HookNewGroup, Group: newGroup}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
 	// Save the group
 	if err := m.db.SaveGroup(newGroup); err != nil {
 		return err
 	}
 
 	log.Printf("Allocated new group '%s'", name)
+
+	if g, err := m.db.LoadGroup(name); err == nil {
+		hd.Group = g
+	}
+	m.runPostHooks(hd)
 	return nil
 }
 
@@ -55,27 +67,189 @@ func (m *Manager) GetGroupByName(name string) (*pb.Group, error) {
 	return m.db.LoadGroup(name)
 }
 
-// DeleteGroup unsurprisingly deletes a group.  There's no real logic
-// here, it just passes the delete call through to the storage layer.
-func (m *Manager) DeleteGroup(name string) error {
-	return m.db.DeleteGroup(name)
+// DeleteGroup deletes a group.  Entities may be members of the group
+// or have it as their primary group, and other groups may expand it
+// or be managed by it.  If any of these references exist the group is
+// not deleted and a ReferenceError listing them is returned, unless
+// force is set, in which case the references are removed along with
+// the group.
+func (m *Manager) DeleteGroup(name string, force bool) error {
+	g, err := m.db.LoadGroup(name)
+	if err != nil {
+		return err
+	}
+
+	hd := &HookData{Point: HookDeleteGroup, Group: g}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	err = m.update(func(tm *Manager) error {
+		refs, err := tm.groupReferences(name, "", force)
+		if err != nil {
+			return err
+		}
+		if len(refs) != 0 && !force {
+			return &ReferenceError{Name: name, References: refs}
+		}
+		return tm.db.DeleteGroup(name)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted group '%s'", name)
+
+	m.runPostHooks(hd)
+	return nil
+}
+
+// RenameGroup changes the name of a group.  The number and everything
+// else about the group stay the same, and every reference to the
+// group by its old name is changed to the new name along with it.
+func (m *Manager) RenameGroup(oldName, newName string) error {
+	g, err := m.db.LoadGroup(oldName)
+	if err != nil {
+		return err
+	}
+	if _, err := m.db.LoadGroup(newName); err == nil {
+		log.Printf("Group '%s' already exists!", newName)
+		return ErrDuplicateGroupName
+	}
+
+	// A group that refers to itself will refer to itself by its
+	// new name.
+	ng := proto.Clone(g).(*pb.Group)
+	ng.Name = &newName
+	if ng.GetManagedBy() == oldName {
+		ng.ManagedBy = &newName
+	}
+
+	hd := &HookData{Point: HookRenameGroup, Group: ng, OldName: oldName}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
+	err = m.update(func(tm *Manager) error {
+		if err := tm.db.SaveGroup(ng); err != nil {
+			return err
+		}
+		if _, err := tm.groupReferences(oldName, newName, true); err != nil {
+			return err
+		}
+		return tm.db.DeleteGroup(oldName)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Renamed group '%s' to '%s'", oldName, newName)
+
+	if g, err := m.db.LoadGroup(newName); err == nil {
+		hd.Group = g
+	}
+	m.runPostHooks(hd)
+	return nil
+}
+
+// groupReferences finds everything that refers to the named group.
+// If rewrite is set the references are changed to refer to
+// replacement instead, or are removed if replacement is empty.  A
+// group that refers to itself doesn't count, since it is going away
+// too.
+func (m *Manager) groupReferences(name, replacement string, rewrite bool) ([]string, error) {
+	var refs []string
+
+	entities, err := m.allEntities()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entities {
+		changed := false
+		if e.GetMeta().GetPrimaryGroup() == name {
+			refs = append(refs, fmt.Sprintf("primary group of entity %s", e.GetID()))
+			e.Meta.PrimaryGroup = proto.String(replacement)
+			changed = true
+		}
+		var keep []string
+		for _, g := range e.GetMeta().GetGroups() {
+			if g == name {
+				refs = append(refs, fmt.Sprintf("entity %s is a member", e.GetID()))
+				changed = true
+				if replacement == "" {
+					continue
+				}
+				g = replacement
+			}
+			keep = append(keep, g)
+		}
+		if changed && rewrite {
+			e.Meta.Groups = keep
+			if err := m.db.SaveEntity(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	groups, err := m.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if g.GetName() == name {
+			continue
+		}
+		changed := false
+		if g.GetManagedBy() == name {
+			refs = append(refs, fmt.Sprintf("group %s is managed by it", g.GetName()))
+			g.ManagedBy = proto.String(replacement)
+			changed = true
+		}
+		var keep []string
+		for _, exp := range g.GetExpansions() {
+			parts := strings.SplitN(exp, ":", 2)
+			if len(parts) == 2 && parts[1] == name {
+				refs = append(refs, fmt.Sprintf("group %s has expansion %s", g.GetName(), exp))
+				changed = true
+				if replacement == "" {
+					continue
+				}
+				exp = parts[0] + ":" + replacement
+			}
+			keep = append(keep, exp)
+		}
+		if changed && rewrite {
+			g.Expansions = keep
+			if err := m.db.SaveGroup(g); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(refs)
+	return refs, nil
 }
 
 // UpdateGroupMeta updates metadata within the group.  Certain
 // information is not mutable and so that information is not merged
-// in.
-func (m *Manager) UpdateGroupMeta(name string, update *pb.Group) error {
+// in.  If revision is not 0 the update is refused with
+// ErrStaleRevision unless the group is still at that revision.
+func (m *Manager) UpdateGroupMeta(name string, revision uint64, update *pb.Group) error {
 	g, err := m.GetGroupByName(name)
 	if err != nil {
 		return err
 	}
 
+	if err := checkRevision(g.GetRevision(), revision); err != nil {
+		return err
+	}
+
 	// Stash and clear some choice values
 	gName := update.GetName()
 	number := update.GetNumber()
+	rev := update.Revision
 
 	update.Name = nil
 	update.Number = nil
+	update.Revision = nil
 
 	proto.Merge(g, update)
 
@@ -86,6 +260,7 @@ func (m *Manager) UpdateGroupMeta(name string, update *pb.Group) error {
 	// Put the values back, since this was accessed by pointer
 	update.Name = &gName
 	update.Number = &number
+	update.Revision = rev
 
 	return nil
 }
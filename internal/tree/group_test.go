@@ -60,7 +60,7 @@ func TestDeleteGroup(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := em.DeleteGroup("foo"); err != nil {
+	if err := em.DeleteGroup("foo", false); err != nil {
 		t.Error(err)
 	}
 
@@ -78,7 +78,7 @@ func TestUpdateGroupMetaInternal(t *testing.T) {
 
 	update := &pb.Group{DisplayName: proto.String("Foo Group")}
 
-	if err := em.UpdateGroupMeta("foo", update); err != nil {
+	if err := em.UpdateGroupMeta("foo", 0, update); err != nil {
 		t.Error(err)
 	}
 
@@ -221,3 +221,187 @@ func TestRemoveGroupCapabilityNoCap(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDeleteGroupReferences(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	for _, g := range []string{"foo", "parent", "managed"} {
+		if err := em.NewGroup(g, "", "", -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := em.NewEntity("bar", -1, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("bar", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("bar", "parent"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.UpdateEntityMeta("bar", 0, &pb.EntityMeta{PrimaryGroup: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ModifyGroupExpansions("parent", "foo", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.UpdateGroupMeta("managed", 0, &pb.Group{ManagedBy: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := em.DeleteGroup("foo", false)
+	re, ok := err.(*ReferenceError)
+	if !ok {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	want := []string{
+		"entity bar is a member",
+		"group managed is managed by it",
+		"group parent has expansion INCLUDE:foo",
+		"primary group of entity bar",
+	}
+	if !slicesAreEqual(re.References, want) {
+		t.Errorf("Got references %v", re.References)
+	}
+	if _, err := em.GetGroupByName("foo"); err != nil {
+		t.Error("Group was deleted while referenced")
+	}
+
+	if err := em.DeleteGroup("foo", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.GetGroupByName("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+
+	e, err := em.GetEntity("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetPrimaryGroup() != "" || !slicesAreEqual(e.GetMeta().GetGroups(), []string{"parent"}) {
+		t.Errorf("Entity still refers to the group: %v", e.GetMeta())
+	}
+	p, err := em.GetGroupByName("parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.GetExpansions()) != 0 {
+		t.Errorf("Expansion left behind: %v", p.GetExpansions())
+	}
+	m, err := em.GetGroupByName("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetManagedBy() != "" {
+		t.Errorf("Group still managed by deleted group: %v", m)
+	}
+
+	// Nothing is left for a check to find.
+	problems, err := em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Problems after delete: %v", problems)
+	}
+}
+
+func TestDeleteGroupSelfManaged(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewGroup("foo", "", "foo", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.DeleteGroup("foo", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewGroup("foo", "", "foo", -1); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []string{"parent", "managed"} {
+		if err := em.NewGroup(g, "", "", -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := em.NewEntity("bar", -1, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("bar", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("bar", "managed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.UpdateEntityMeta("bar", 0, &pb.EntityMeta{PrimaryGroup: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ModifyGroupExpansions("parent", "foo", pb.ExpansionMode_INCLUDE); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.UpdateGroupMeta("managed", 0, &pb.Group{ManagedBy: proto.String("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	old, err := em.GetGroupByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.RenameGroup("foo", "managed"); err != ErrDuplicateGroupName {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := em.RenameGroup("missing", "baz"); err != db.ErrUnknownGroup {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	if err := em.RenameGroup("foo", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.GetGroupByName("foo"); err != db.ErrUnknownGroup {
+		t.Error(err)
+	}
+	g, err := em.GetGroupByName("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetNumber() != old.GetNumber() || g.GetManagedBy() != "baz" {
+		t.Errorf("Group changed in the rename: %v", g)
+	}
+
+	e, err := em.GetEntity("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetPrimaryGroup() != "baz" || !slicesAreEqual(e.GetMeta().GetGroups(), []string{"baz", "managed"}) {
+		t.Errorf("Entity still refers to the old name: %v", e.GetMeta())
+	}
+	if got := em.GetMemberships(e, true); !slicesAreEqual(got, []string{"baz", "managed", "parent"}) {
+		t.Errorf("Wrong memberships: %v", got)
+	}
+	p, err := em.GetGroupByName("parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slicesAreEqual(p.GetExpansions(), []string{"INCLUDE:baz"}) {
+		t.Errorf("Expansion not renamed: %v", p.GetExpansions())
+	}
+	m, err := em.GetGroupByName("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetManagedBy() != "baz" {
+		t.Errorf("Managing group not renamed: %v", m)
+	}
+
+	problems, err := em.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Problems after rename: %v", problems)
+	}
+}
@@ -0,0 +1,238 @@
+package tree
+
+import (
+	"log"
+	"strings"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// A HookPoint names a function on the Manager around which hooks can
+// be run.  The names match the functions that they are attached to.
+type HookPoint string
+
+// These are the points that hooks may be attached to.
+const (
+	HookNewEntity             HookPoint = "NewEntity"
+	HookDeleteEntityByID      HookPoint = "DeleteEntityByID"
+	HookRenameEntity          HookPoint = "RenameEntity"
+	HookSetEntitySecretByID   HookPoint = "SetEntitySecretByID"
+	HookValidateSecret        HookPoint = "ValidateSecret"
+	HookLockEntity            HookPoint = "LockEntity"
+	HookUnlockEntity          HookPoint = "UnlockEntity"
+	HookNewGroup              HookPoint = "NewGroup"
+	HookDeleteGroup           HookPoint = "DeleteGroup"
+	HookRenameGroup           HookPoint = "RenameGroup"
+	HookAddEntityToGroup      HookPoint = "AddEntityToGroup"
+	HookRemoveEntityFromGroup HookPoint = "RemoveEntityFromGroup"
+	HookModifyGroupExpansions HookPoint = "ModifyGroupExpansions"
+)
+
+var hookPoints = []HookPoint{
+	HookNewEntity,
+	HookDeleteEntityByID,
+	HookRenameEntity,
+	HookSetEntitySecretByID,
+	HookValidateSecret,
+	HookLockEntity,
+	HookUnlockEntity,
+	HookNewGroup,
+	HookDeleteGroup,
+	HookRenameGroup,
+	HookAddEntityToGroup,
+	HookRemoveEntityFromGroup,
+	HookModifyGroupExpansions,
+}
+
+// HookData is handed to each hook as it runs.  Only the fields that
+// make sense for a given HookPoint will be filled in.  Hooks are run
+// inside the server and are handed the real records, not copies, so
+// they must not modify them.
+type HookData struct {
+	// Point is the function that the hook is being run for.
+	Point HookPoint
+
+	// Entity and Group are the records being acted on.  Pre
+	// hooks on NewEntity, NewGroup, RenameEntity, and RenameGroup
+	// see the record as it will be saved if no hook objects.  Pre
+	// hooks on every other point see the record as it is before
+	// the change, which is described by Point and the fields
+	// below.  Post hooks see the record that was saved.
+	Entity *pb.Entity
+	Group  *pb.Group
+
+	// OldName is the ID or name that Entity or Group had before
+	// it was renamed.
+	OldName string
+
+	// ChildGroup and Mode are set when the expansions of Group
+	// are being changed.
+	ChildGroup *pb.Group
+	Mode       pb.ExpansionMode
+
+	// Secret is the plaintext secret for functions that handle
+	// one.  It must never be logged or stored.
+	Secret string
+
+	// Err is the result of the operation.  This is only set for
+	// post hooks on ValidateSecret, which run on failure as well
+	// as on success.
+	Err error
+}
+
+// A Hook is a named piece of policy that can be attached to one or
+// more HookPoints.  To be useful a Hook must also implement PreHook,
+// PostHook, or both.
+type Hook interface {
+	Name() string
+}
+
+// A PreHook runs before a change is made and can veto the change by
+// returning an error.  Hooks that refuse a change on policy grounds
+// should return a *PolicyError so that the reason can be shown to the
+// user.
+type PreHook interface {
+	Hook
+	RunPre(*HookData) error
+}
+
+// A PostHook runs after a change has been saved.  Post hooks cannot
+// undo the change they are observing.
+type PostHook interface {
+	Hook
+	RunPost(*HookData)
+}
+
+// HookFactory returns a new instance of a Hook.  The Manager that the
+// hook will be attached to is provided so that hooks which need to
+// inspect the tree may do so.
+type HookFactory func(*Manager) (Hook, error)
+
+var (
+	hookFactories map[string]HookFactory
+)
+
+func init() {
+	hookFactories = make(map[string]HookFactory)
+}
+
+// RegisterHook takes in a name for the hook and a function signature
+// to bind to that name.
+func RegisterHook(name string, newFunc HookFactory) {
+	if _, ok := hookFactories[name]; ok {
+		// Return if the hook was already registered.
+		return
+	}
+	hookFactories[name] = newFunc
+}
+
+// GetHookList returns a string list of the hooks that are available.
+func GetHookList() []string {
+	var l []string
+
+	for h := range hookFactories {
+		l = append(l, h)
+	}
+
+	return l
+}
+
+// GetHookPointList returns a string list of the points that hooks may
+// be attached to.
+func GetHookPointList() []string {
+	var l []string
+
+	for _, p := range hookPoints {
+		l = append(l, string(p))
+	}
+
+	return l
+}
+
+// SetHooks configures the hooks that will be run by the Manager.
+// Each entry is of the form "HookPoint:hook" and hooks will run at
+// each point in the order they are given.  A hook that is named more
+// than once shares a single instance.  Calling SetHooks replaces any
+// hooks that were configured previously.
+func (m *Manager) SetHooks(spec []string) error {
+	instances := make(map[string]Hook)
+	pre := make(map[HookPoint][]PreHook)
+	post := make(map[HookPoint][]PostHook)
+
+	for _, s := range spec {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 || !isHookPoint(parts[0]) {
+			return ErrUnknownHookPoint
+		}
+		point, name := HookPoint(parts[0]), parts[1]
+
+		h, ok := instances[name]
+		if !ok {
+			f, ok := hookFactories[name]
+			if !ok {
+				return ErrUnknownHook
+			}
+			var err error
+			h, err = f(m)
+			if err != nil {
+				return err
+			}
+			instances[name] = h
+		}
+
+		// A hook may run on either side of the point, or on
+		// both.
+		hp, isPre := h.(PreHook)
+		if isPre {
+			pre[point] = append(pre[point], hp)
+		}
+		hq, isPost := h.(PostHook)
+		if isPost {
+			post[point] = append(post[point], hq)
+		}
+		if !isPre && !isPost {
+			return ErrUnknownHook
+		}
+		log.Printf("Hook '%s' attached to %s", name, point)
+	}
+
+	m.preHooks = pre
+	m.postHooks = post
+	return nil
+}
+
+// runPreHooks runs the pre hooks for the named point in order.  The
+// first hook to return an error stops the chain and the error is
+// returned to the caller.
+func (m *Manager) runPreHooks(d *HookData) error {
+	for _, h := range m.preHooks[d.Point] {
+		if err := h.RunPre(d); err != nil {
+			log.Printf("Hook '%s' refused %s: %s", h.Name(), d.Point, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostHooks runs the post hooks for the named point in order.
+func (m *Manager) runPostHooks(d *HookData) {
+	for _, h := range m.postHooks[d.Point] {
+		h.RunPost(d)
+	}
+}
+
+// isHookPoint returns true if the provided string names a valid
+// HookPoint.
+func isHookPoint(s string) bool {
+	for _, p := range hookPoints {
+		if string(p) == s {
+			return true
+		}
+	}
+	return false
+}
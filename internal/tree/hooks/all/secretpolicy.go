@@ -0,0 +1,7 @@
+package all
+
+import (
+	// The blank import here permits the init() within the
+	// secretpolicy module to register its hook with the tree.
+	_ "github.com/NetAuth/NetAuth/internal/tree/hooks/secretpolicy"
+)
@@ -0,0 +1,11 @@
+package secretpolicy
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnknownClass is returned when the policy names a
+	// character class that is not known.
+	ErrUnknownClass = errors.New("The specified character class does not exist")
+)
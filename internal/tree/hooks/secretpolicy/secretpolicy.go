@@ -0,0 +1,187 @@
+package secretpolicy
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/NetAuth/NetAuth/internal/tree"
+)
+
+var (
+	minLength      = flag.Int("secret_min_length", 8, "Minimum length of a secret")
+	requireClasses = flag.String("secret_require_classes", "", "Comma separated character classes a secret must contain (lower, upper, digit, symbol)")
+	rejectIdentity = flag.Bool("secret_reject_identity", true, "Reject secrets that contain the entity's ID or name")
+	wordlist       = flag.String("secret_wordlist", "", "File of words, one per line, which may not be used as secrets")
+)
+
+// identityMinLength is the shortest part of a name that will be
+// checked for.  Shorter fragments would reject too many reasonable
+// secrets.
+const identityMinLength = 3
+
+// classes maps the names of character classes to functions which
+// can find them.
+var classes = map[string]func(rune) bool{
+	"lower":  unicode.IsLower,
+	"upper":  unicode.IsUpper,
+	"digit":  unicode.IsDigit,
+	"symbol": isSymbol,
+}
+
+func init() {
+	tree.RegisterHook("secret-policy", New)
+}
+
+// Policy checks secrets before they are set.  It should be attached
+// to SetEntitySecretByID, and optionally to NewEntity to check
+// secrets before the entity is saved.
+type Policy struct {
+	minLength      int
+	classes        []string
+	rejectIdentity bool
+	words          map[string]bool
+}
+
+// New returns a Policy configured from the command line.
+func New(_ *tree.Manager) (tree.Hook, error) {
+	x := new(Policy)
+	x.minLength = *minLength
+	x.rejectIdentity = *rejectIdentity
+
+	for _, c := range strings.Split(*requireClasses, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if _, ok := classes[c]; !ok {
+			log.Printf("Unknown character class '%s'", c)
+			return nil, ErrUnknownClass
+		}
+		x.classes = append(x.classes, c)
+	}
+
+	words, err := loadWordlist(*wordlist)
+	if err != nil {
+		return nil, err
+	}
+	x.words = words
+
+	return x, nil
+}
+
+// Name returns the name of the hook.
+func (p *Policy) Name() string { return "secret-policy" }
+
+// RunPre checks the secret that is about to be set against the
+// policy.
+func (p *Policy) RunPre(d *tree.HookData) error {
+	switch d.Point {
+	case tree.HookNewEntity, tree.HookSetEntitySecretByID:
+	default:
+		// Only functions that set a secret are checked,
+		// anything else would prevent entities with old
+		// secrets from using them.
+		return nil
+	}
+
+	if reason := p.check(d); reason != "" {
+		return &tree.SecretPolicyError{Reason: reason}
+	}
+	return nil
+}
+
+// check returns a human readable reason for why the secret is
+// unacceptable, or an empty string if the secret is fine.
+func (p *Policy) check(d *tree.HookData) string {
+	secret := d.Secret
+
+	if len([]rune(secret)) < p.minLength {
+		return fmt.Sprintf("must be at least %d characters long", p.minLength)
+	}
+
+	for _, c := range p.classes {
+		if strings.IndexFunc(secret, classes[c]) < 0 {
+			return fmt.Sprintf("must contain at least one %s character", c)
+		}
+	}
+
+	lower := strings.ToLower(secret)
+	if p.rejectIdentity {
+		for _, s := range identityStrings(d) {
+			if strings.Contains(lower, s) {
+				return "must not contain the entity's ID or name"
+			}
+		}
+	}
+
+	if p.words[lower] {
+		return "must not be a dictionary word"
+	}
+
+	return ""
+}
+
+// identityStrings returns the lowercased parts of the entity's ID and
+// names that may not appear in the secret.
+func identityStrings(d *tree.HookData) []string {
+	e := d.Entity
+	candidates := []string{e.GetID()}
+	candidates = append(candidates, strings.FieldsFunc(e.GetMeta().GetGECOS(), isSeparator)...)
+	candidates = append(candidates, strings.FieldsFunc(e.GetMeta().GetLegalName(), isSeparator)...)
+
+	var out []string
+	for _, c := range candidates {
+		if len([]rune(c)) < identityMinLength {
+			continue
+		}
+		out = append(out, strings.ToLower(c))
+	}
+	return out
+}
+
+// loadWordlist reads in a file of words with one per line.  If no
+// file is named an empty list is returned.
+func loadWordlist(path string) (map[string]bool, error) {
+	words := make(map[string]bool)
+	if path == "" {
+		return words, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Could not open wordlist: %s", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		w := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if w == "" {
+			continue
+		}
+		words[w] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Could not read wordlist: %s", err)
+		return nil, err
+	}
+	log.Printf("Loaded %d words from %s", len(words), path)
+	return words, nil
+}
+
+// isSymbol is true for any printable rune which is not a letter,
+// digit, or space.
+func isSymbol(r rune) bool {
+	return unicode.IsPrint(r) && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+// isSeparator splits names into their parts.
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == ','
+}
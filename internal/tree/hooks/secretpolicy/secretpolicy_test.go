@@ -0,0 +1,122 @@
+package secretpolicy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/tree"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func writeWordlist(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "secretpolicy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "words")
+	if err := ioutil.WriteFile(path, []byte("Password1!\n\ncorrecthorse\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewUnknownClass(t *testing.T) {
+	*requireClasses = "lower,vowel"
+	defer func() { *requireClasses = "" }()
+
+	if _, err := New(nil); err != ErrUnknownClass {
+		t.Error(err)
+	}
+}
+
+func TestNewMissingWordlist(t *testing.T) {
+	*wordlist = "/does/not/exist"
+	defer func() { *wordlist = "" }()
+
+	if _, err := New(nil); err == nil {
+		t.Error("Missing wordlist was accepted")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	path := writeWordlist(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	*minLength = 8
+	*requireClasses = "lower, upper,digit,symbol"
+	*rejectIdentity = true
+	*wordlist = path
+	defer func() {
+		*requireClasses = ""
+		*wordlist = ""
+	}()
+
+	h, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := h.(tree.PreHook)
+
+	e := &pb.Entity{
+		ID: proto.String("jdoe"),
+		Meta: &pb.EntityMeta{
+			GECOS:     proto.String("Jane Doe,Room 1"),
+			LegalName: proto.String("Janet Q Doe"),
+		},
+	}
+
+	s := []struct {
+		point  tree.HookPoint
+		secret string
+		wantOK bool
+	}{
+		{tree.HookSetEntitySecretByID, "aB3$efgh", true},
+		{tree.HookNewEntity, "aB3$efgh", true},
+		{tree.HookSetEntitySecretByID, "aB3$efg", false},  // Too short
+		{tree.HookSetEntitySecretByID, "ab3$efgh", false}, // No upper
+		{tree.HookSetEntitySecretByID, "AB3$EFGH", false}, // No lower
+		{tree.HookSetEntitySecretByID, "aBc$efgh", false}, // No digit
+		{tree.HookSetEntitySecretByID, "aB3defgh", false}, // No symbol
+		{tree.HookSetEntitySecretByID, "xJDOE3$a", false}, // Contains ID
+		{tree.HookSetEntitySecretByID, "1$Janexx", false}, // Contains GECOS
+		{tree.HookSetEntitySecretByID, "1$Janetx", false}, // Contains legal name
+		{tree.HookSetEntitySecretByID, "xx1$QQxx", true},  // Short names are ignored
+		{tree.HookSetEntitySecretByID, "PASSWORD1!", false},
+		{tree.HookValidateSecret, "", true}, // Only secret setting is checked
+	}
+
+	for i, c := range s {
+		err := p.RunPre(&tree.HookData{Point: c.point, Entity: e, Secret: c.secret})
+		if c.wantOK && err != nil {
+			t.Errorf("%d: Unexpected error: %s", i, err)
+		}
+		if !c.wantOK {
+			if _, ok := err.(*tree.SecretPolicyError); !ok {
+				t.Errorf("%d: Wrong error: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestIdentityDisabled(t *testing.T) {
+	*rejectIdentity = false
+	defer func() { *rejectIdentity = true }()
+
+	h, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &tree.HookData{
+		Point:  tree.HookSetEntitySecretByID,
+		Entity: &pb.Entity{ID: proto.String("jdoe")},
+		Secret: "jdoe1234",
+	}
+	if err := h.(tree.PreHook).RunPre(d); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,290 @@
+package tree
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+	"github.com/NetAuth/NetAuth/internal/db"
+)
+
+type dummyHook struct {
+	name   string
+	veto   bool
+	log    *[]string
+	result error
+}
+
+func (h *dummyHook) Name() string { return h.name }
+
+func (h *dummyHook) RunPre(d *HookData) error {
+	*h.log = append(*h.log, "pre:"+h.name+":"+string(d.Point))
+	if h.veto {
+		return &PolicyError{Hook: h.name, Reason: "vetoed for testing"}
+	}
+	return nil
+}
+
+func (h *dummyHook) RunPost(d *HookData) {
+	*h.log = append(*h.log, "post:"+h.name+":"+string(d.Point))
+	h.result = d.Err
+}
+
+type dummyNameOnlyHook struct{}
+
+func (*dummyNameOnlyHook) Name() string { return "name-only" }
+
+func registerDummyHooks(log *[]string) map[string]*dummyHook {
+	hookFactories = make(map[string]HookFactory)
+	hooks := map[string]*dummyHook{
+		"first":  {name: "first", log: log},
+		"second": {name: "second", log: log},
+		"veto":   {name: "veto", veto: true, log: log},
+	}
+	for n, h := range hooks {
+		h := h
+		RegisterHook(n, func(*Manager) (Hook, error) { return h, nil })
+	}
+	RegisterHook("name-only", func(*Manager) (Hook, error) { return &dummyNameOnlyHook{}, nil })
+	return hooks
+}
+
+func TestRegisterHook(t *testing.T) {
+	hookFactories = make(map[string]HookFactory)
+
+	f := func(*Manager) (Hook, error) { return &dummyNameOnlyHook{}, nil }
+	RegisterHook("dummy", f)
+	if l := GetHookList(); len(l) != 1 || l[0] != "dummy" {
+		t.Error("Hook failed to register")
+	}
+
+	RegisterHook("dummy", f)
+	if l := GetHookList(); len(l) != 1 {
+		t.Error("A duplicate hook was registered")
+	}
+}
+
+func TestGetHookPointList(t *testing.T) {
+	l := GetHookPointList()
+	if len(l) != len(hookPoints) {
+		t.Fatal("Wrong number of hook points")
+	}
+	for _, p := range l {
+		if !isHookPoint(p) {
+			t.Errorf("Unknown hook point '%s' in list", p)
+		}
+	}
+}
+
+func TestSetHooksBadSpec(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	s := []struct {
+		spec []string
+		err  error
+	}{
+		{[]string{""}, nil},
+		{[]string{"NewEntity:first", " NewEntity:second "}, nil},
+		{[]string{"first"}, ErrUnknownHookPoint},
+		{[]string{"NotAFunction:first"}, ErrUnknownHookPoint},
+		{[]string{"NewEntity:unknown"}, ErrUnknownHook},
+		{[]string{"NewEntity:name-only"}, ErrUnknownHook},
+	}
+
+	for i, c := range s {
+		if err := em.SetHooks(c.spec); err != c.err {
+			t.Errorf("%d: Got %v; Want %v", i, err, c.err)
+		}
+	}
+}
+
+func TestHooksRunInOrder(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	if err := em.SetHooks([]string{"NewGroup:second", "NewGroup:first", "DeleteGroup:first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.NewGroup("foo", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"pre:second:NewGroup",
+		"pre:first:NewGroup",
+		"post:second:NewGroup",
+		"post:first:NewGroup",
+	}
+	if strings.Join(log, ",") != strings.Join(want, ",") {
+		t.Errorf("Hooks ran wrong; got %v want %v", log, want)
+	}
+}
+
+func TestPreHookVeto(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	if err := em.SetHooks([]string{"NewEntity:veto", "NewGroup:veto"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.NewEntity("foo", -1, ""); err == nil {
+		t.Fatal("Vetoed entity was created")
+	} else if _, ok := err.(*PolicyError); !ok {
+		t.Errorf("Wrong error type: %T", err)
+	}
+	if _, err := em.GetEntity("foo"); err == nil {
+		t.Error("Vetoed entity was saved")
+	}
+
+	if err := em.NewGroup("foo", "", "", -1); err == nil {
+		t.Fatal("Vetoed group was created")
+	}
+	if _, err := em.GetGroupByName("foo"); err == nil {
+		t.Error("Vetoed group was saved")
+	}
+}
+
+func TestMembershipHooks(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.NewGroup("bar", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.SetHooks([]string{"AddEntityToGroup:veto", "RemoveEntityFromGroup:first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("foo", "bar"); err == nil {
+		t.Error("Vetoed membership was added")
+	}
+
+	if err := em.SetHooks([]string{"RemoveEntityFromGroup:first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.AddEntityToGroup("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.RemoveEntityFromGroup("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"pre:veto:AddEntityToGroup",
+		"pre:first:RemoveEntityFromGroup",
+		"post:first:RemoveEntityFromGroup",
+	}
+	if strings.Join(log, ",") != strings.Join(want, ",") {
+		t.Errorf("Hooks ran wrong; got %v want %v", log, want)
+	}
+}
+
+func TestValidateSecretPostHookSeesFailure(t *testing.T) {
+	var log []string
+	hooks := registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.SetHooks([]string{"ValidateSecret:first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+		t.Fatal(err)
+	}
+	if hooks["first"].result != crypto.ErrAuthorizationFailure {
+		t.Errorf("Post hook saw %v", hooks["first"].result)
+	}
+
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if hooks["first"].result != nil {
+		t.Errorf("Post hook saw %v", hooks["first"].result)
+	}
+}
+
+func TestNewEntityRefusedSecret(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewEntityManager(t)
+
+	if err := em.SetHooks([]string{"SetEntitySecretByID:veto"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.NewEntity("foo", -1, "foo"); err == nil {
+		t.Fatal("Entity was created with a refused secret")
+	}
+	if _, err := em.GetEntity("foo"); err != db.ErrUnknownEntity {
+		t.Errorf("Partially created entity remains: %v", err)
+	}
+}
+
+func TestNewEntityRefusedSecretNoTxn(t *testing.T) {
+	var log []string
+	registerDummyHooks(&log)
+	em := getNewNoTxnEntityManager(t)
+
+	if err := em.SetHooks([]string{"SetEntitySecretByID:veto"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.NewEntity("foo", -1, "foo"); err == nil {
+		t.Fatal("Entity was created with a refused secret")
+	}
+	if _, err := em.GetEntity("foo"); err != db.ErrUnknownEntity {
+		t.Errorf("Partially created entity remains: %v", err)
+	}
+}
+
+// racingHook creates the entity itself the first time it is asked
+// about it, as another request would if it got in first.
+type racingHook struct {
+	m    *Manager
+	done bool
+}
+
+func (h *racingHook) Name() string { return "racing" }
+
+func (h *racingHook) RunPre(d *HookData) error {
+	if h.done {
+		return nil
+	}
+	h.done = true
+	return h.m.NewEntity(d.Entity.GetID(), 99, "winner")
+}
+
+func TestNewEntityRace(t *testing.T) {
+	for _, em := range []*Manager{getNewEntityManager(t), getNewNoTxnEntityManager(t)} {
+		hookFactories = make(map[string]HookFactory)
+		RegisterHook("racing", func(m *Manager) (Hook, error) { return &racingHook{m: m}, nil })
+		if err := em.SetHooks([]string{"NewEntity:racing"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := em.NewEntity("foo", -1, "loser"); err != ErrDuplicateEntityID {
+			t.Errorf("Lost race returned %v", err)
+		}
+		e, err := em.GetEntity("foo")
+		if err != nil {
+			t.Fatalf("Winning entity was removed: %v", err)
+		}
+		if e.GetNumber() != 99 {
+			t.Errorf("Wrong entity kept: %v", e)
+		}
+	}
+}
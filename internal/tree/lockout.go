@@ -0,0 +1,198 @@
+package tree
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	lockoutThreshold = flag.Int("lockout_threshold", 5, "Failed authentications within the interval which will lock an entity")
+	lockoutInterval  = flag.Duration("lockout_interval", 15*time.Minute, "Interval over which failed authentications are counted")
+	lockoutDuration  = flag.Duration("lockout_duration", 15*time.Minute, "Time to lock an entity for, 0 locks until an administrator unlocks it")
+)
+
+func init() {
+	RegisterHook("auto-lock", newAutoLock)
+}
+
+// autoLock is a hook that counts failed authentications and locks
+// entities that fail too often.  It should be attached to
+// ValidateSecret.  The failures are stored on the entity so that
+// they survive a restart of the server.
+type autoLock struct {
+	m *Manager
+
+	threshold int
+	interval  time.Duration
+	duration  time.Duration
+
+	// now is swapped out during tests.
+	now func() time.Time
+}
+
+func newAutoLock(m *Manager) (Hook, error) {
+	x := new(autoLock)
+	x.m = m
+	x.threshold = *lockoutThreshold
+	x.interval = *lockoutInterval
+	x.duration = *lockoutDuration
+	x.now = time.Now
+	return x, nil
+}
+
+// Name returns the name of the hook.
+func (a *autoLock) Name() string { return "auto-lock" }
+
+// RunPre refuses to validate the secret of an entity that is
+// currently serving a temporary lockout.
+func (a *autoLock) RunPre(d *HookData) error {
+	if d.Point != HookValidateSecret {
+		return nil
+	}
+
+	if a.now().Unix() < d.Entity.GetAuthFailures().GetLockedUntil() {
+		return ErrEntityLocked
+	}
+	return nil
+}
+
+// RunPost records the outcome of the validation.  Failures are
+// counted and the entity is locked if there are too many, success
+// resets the count.  The entity is loaded again to record the
+// outcome since the copy that was validated may be out of date, and
+// again whenever the save is refused because someone else changed
+// the entity first, so that failures made in parallel are all
+// counted.
+func (a *autoLock) RunPost(d *HookData) {
+	if d.Point != HookValidateSecret {
+		return
+	}
+
+	switch d.Err {
+	case nil, ErrSecretExpired, crypto.ErrAuthorizationFailure:
+	default:
+		return
+	}
+
+	ID := d.Entity.GetID()
+	lock := false
+	update := func(m *Manager) error {
+		e, err := m.db.LoadEntity(ID)
+		if err != nil {
+			return err
+		}
+
+		if d.Err == crypto.ErrAuthorizationFailure {
+			lock = a.recordFailure(e)
+			if lock {
+				// Locked entities must not be able to
+				// keep using tokens they already hold.
+				e.Meta.Locked = proto.Bool(true)
+				revokeAllTokens(e)
+			}
+		} else {
+			if e.GetAuthFailures() == nil {
+				return nil
+			}
+			// A successful validation clears the slate.
+			e.AuthFailures = nil
+		}
+		return m.db.SaveEntity(e)
+	}
+
+	// The entity may be changed by someone else between loading
+	// and saving it, in which case the outcome is recorded again
+	// against the new copy.
+	err := a.m.update(update)
+	for err == ErrStaleRevision {
+		err = a.m.update(update)
+	}
+	if err != nil {
+		log.Printf("Could not save authentication failures for '%s': %s", ID, err)
+		return
+	}
+
+	if lock {
+		log.Printf("Locked '%s' after %d failed authentications", ID, a.threshold)
+	}
+}
+
+// recordFailure adds a failure to the entity and drops any that have
+// fallen out of the interval.  If the threshold is reached and
+// lockouts are temporary the lockout is set here, otherwise true is
+// returned to signal that the entity must be locked.  Either way the
+// count starts over so that an unlocked entity gets a fresh set of
+// attempts.
+func (a *autoLock) recordFailure(e *pb.Entity) bool {
+	now := a.now()
+	if e.AuthFailures == nil {
+		e.AuthFailures = &pb.AuthFailures{}
+	}
+
+	// A lockout that has expired no longer counts.
+	if e.AuthFailures.GetLockedUntil() <= now.Unix() {
+		e.AuthFailures.LockedUntil = nil
+	}
+
+	cutoff := now.Add(-a.interval).Unix()
+	var times []int64
+	for _, t := range e.AuthFailures.GetTimes() {
+		if t > cutoff {
+			times = append(times, t)
+		}
+	}
+	times = append(times, now.Unix())
+	e.AuthFailures.Times = times
+
+	if len(times) < a.threshold {
+		return false
+	}
+	e.AuthFailures.Times = nil
+
+	if a.duration == 0 {
+		return true
+	}
+	log.Printf("Locking '%s' for %s after %d failed authentications", e.GetID(), a.duration, a.threshold)
+	e.AuthFailures.LockedUntil = proto.Int64(now.Add(a.duration).Unix())
+	return false
+}
+
+// GetAuthFailures returns the record of failed authentications for
+// the named entity.
+func (m *Manager) GetAuthFailures(ID string) (*pb.AuthFailures, error) {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &pb.AuthFailures{}
+	if e.GetAuthFailures() != nil {
+		proto.Merge(f, e.GetAuthFailures())
+	}
+	return f, nil
+}
+
+// ClearAuthFailures forgets all failed authentications for the named
+// entity and ends any temporary lockout.  Entities that have been
+// locked outright still need to be unlocked.
+func (m *Manager) ClearAuthFailures(ID string) error {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return err
+	}
+
+	e.AuthFailures = nil
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	log.Printf("Cleared authentication failures for '%s'", ID)
+	return nil
+}
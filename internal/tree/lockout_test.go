@@ -0,0 +1,230 @@
+package tree
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+	"github.com/NetAuth/NetAuth/internal/db"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func getLockoutManager(t *testing.T, duration time.Duration) (*Manager, *time.Time) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	*lockoutThreshold = 3
+	*lockoutInterval = time.Minute
+	*lockoutDuration = duration
+	hookFactories = make(map[string]HookFactory)
+	RegisterHook("auto-lock", newAutoLock)
+	if err := em.SetHooks([]string{"ValidateSecret:auto-lock"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Take control of the clock.
+	now := time.Unix(1000000, 0)
+	em.preHooks[HookValidateSecret][0].(*autoLock).now = func() time.Time { return now }
+	return em, &now
+}
+
+func TestAutoLockPermanent(t *testing.T) {
+	em, _ := getLockoutManager(t, 0)
+
+	for i := 0; i < 3; i++ {
+		if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+			t.Fatal(err)
+		}
+	}
+
+	// Now locked, even the right secret doesn't work.
+	if err := em.ValidateSecret("foo", "foo"); err != ErrEntityLocked {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.GetMeta().GetLocked() {
+		t.Error("Entity is not locked")
+	}
+
+	// Unlocking gives a fresh set of attempts.
+	if err := em.UnlockEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+		t.Fatal(err)
+	}
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoLockInterval(t *testing.T) {
+	em, now := getLockoutManager(t, 0)
+
+	// Failures spaced out beyond the interval never lock.
+	for i := 0; i < 5; i++ {
+		if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+			t.Fatal(err)
+		}
+		*now = now.Add(31 * time.Second)
+	}
+	f, err := em.GetAuthFailures("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.GetTimes()) != 2 {
+		t.Errorf("Wrong number of failures recorded: %v", f.GetTimes())
+	}
+
+	// Success clears the count.
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	f, err = em.GetAuthFailures("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.GetTimes()) != 0 {
+		t.Errorf("Failures were not cleared: %v", f.GetTimes())
+	}
+}
+
+func TestAutoLockTemporary(t *testing.T) {
+	em, now := getLockoutManager(t, 10*time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+			t.Fatal(err)
+		}
+	}
+	if err := em.ValidateSecret("foo", "foo"); err != ErrEntityLocked {
+		t.Fatal(err)
+	}
+
+	// Temporary lockouts don't touch the lock state.
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetLocked() {
+		t.Error("Entity was locked permanently")
+	}
+
+	// The lockout ends on its own.
+	*now = now.Add(11 * time.Minute)
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearAuthFailures(t *testing.T) {
+	em, _ := getLockoutManager(t, 10*time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+			t.Fatal(err)
+		}
+	}
+	f, err := em.GetAuthFailures("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.GetLockedUntil() == 0 {
+		t.Fatal("Entity was not locked out")
+	}
+
+	if err := em.ClearAuthFailures("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthFailuresUnknownEntity(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if _, err := em.GetAuthFailures("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	if err := em.ClearAuthFailures("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
+
+func TestAuthFailuresRedacted(t *testing.T) {
+	em, _ := getLockoutManager(t, 0)
+
+	if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetAuthFailures() != nil {
+		t.Error("Authentication failures were not removed")
+	}
+}
+
+// lockedDB lets a database be used from many goroutines at once and
+// hands out copies of entities as a real backend would.  Loads yield
+// to other goroutines to make races between a load and the save that
+// follows it likely.
+type lockedDB struct {
+	sync.Mutex
+	db.DB
+}
+
+func (l *lockedDB) LoadEntity(ID string) (*pb.Entity, error) {
+	defer runtime.Gosched()
+	l.Lock()
+	defer l.Unlock()
+	e, err := l.DB.LoadEntity(ID)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(e).(*pb.Entity), nil
+}
+
+func (l *lockedDB) SaveEntity(e *pb.Entity) error {
+	l.Lock()
+	defer l.Unlock()
+	return l.DB.SaveEntity(proto.Clone(e).(*pb.Entity))
+}
+
+func TestAutoLockConcurrentFailures(t *testing.T) {
+	em, _ := getLockoutManager(t, 0)
+	em.db = &lockedDB{DB: em.db}
+
+	threshold := 20
+	em.preHooks[HookValidateSecret][0].(*autoLock).threshold = threshold
+
+	var wg sync.WaitGroup
+	for i := 0; i < threshold; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			em.ValidateSecret("foo", "bar")
+		}()
+	}
+	wg.Wait()
+
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.GetMeta().GetLocked() {
+		t.Error("Entity is not locked after reaching the threshold")
+	}
+}
@@ -2,6 +2,8 @@ package tree
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/NetAuth/NetAuth/internal/crypto"
 	"github.com/NetAuth/NetAuth/internal/db"
@@ -23,6 +25,21 @@ type Manager struct {
 	// The Crypto layer allows us to plug in different crypto
 	// engines
 	crypto crypto.EMCrypto
+
+	// Hooks are run before and after the functions that modify
+	// the tree.  They are keyed by the function they belong to
+	// and are run in order.
+	preHooks  map[HookPoint][]PreHook
+	postHooks map[HookPoint][]PostHook
+
+	// Secrets may be kept in a history to prevent reuse, and may
+	// expire after a set age.
+	secretHistory int
+	secretMaxAge  time.Duration
+
+	// The membership graph answers questions about who is in
+	// which group without loading every entity.
+	graph *membershipGraph
 }
 
 // New returns an initialized tree.Manager on to which all other
@@ -30,9 +47,29 @@ type Manager struct {
 func New(db db.DB, crypto crypto.EMCrypto) *Manager {
 	x := Manager{}
 	x.bootstrapDone = false
-	x.db = db
+	x.graph = newMembershipGraph()
+	x.db = revisionedDB{indexedDB{db, x.graph}, new(sync.Mutex)}
 	x.crypto = crypto
+	x.secretHistory = *secretHistory
+	x.secretMaxAge = *secretMaxAge
 	log.Println("Initialized new Entity Manager")
 
 	return &x
 }
+
+// update runs fn so that the changes it makes are applied together.
+// If the database supports transactions fn is given a copy of the
+// Manager that works inside one, otherwise fn runs against the
+// Manager itself and changes are made one at a time as they always
+// have been.
+func (m *Manager) update(fn func(*Manager) error) error {
+	t, ok := m.db.(db.Transactor)
+	if !ok {
+		return fn(m)
+	}
+	return t.Update(func(tx db.DB) error {
+		tm := *m
+		tm.db = tx
+		return fn(&tm)
+	})
+}
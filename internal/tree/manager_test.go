@@ -0,0 +1,49 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+)
+
+func TestUpdate(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	s := []struct {
+		name     string
+		m        *Manager
+		wantKept bool
+	}{
+		{"transactional", getNewEntityManager(t), false},
+		{"fallback", getNewNoTxnEntityManager(t), true},
+	}
+
+	for _, c := range s {
+		if err := c.m.NewGroup("bar", "", "", -1); err != nil {
+			t.Fatal(err)
+		}
+
+		err := c.m.update(func(tm *Manager) error {
+			if err := tm.NewGroup("foo", "", "", -1); err != nil {
+				return err
+			}
+			if err := tm.DeleteGroup("bar", false); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if err != errAbort {
+			t.Errorf("%s: Wrong error %v", c.name, err)
+		}
+
+		_, ferr := c.m.GetGroupByName("foo")
+		_, berr := c.m.GetGroupByName("bar")
+		if c.wantKept && (ferr != nil || berr != db.ErrUnknownGroup) {
+			t.Errorf("%s: Changes were not made one at a time: %v %v", c.name, ferr, berr)
+		}
+		if !c.wantKept && (ferr != db.ErrUnknownGroup || berr != nil) {
+			t.Errorf("%s: Changes were not rolled back: %v %v", c.name, ferr, berr)
+		}
+	}
+}
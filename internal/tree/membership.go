@@ -21,7 +21,8 @@ func (m *Manager) AddEntityToGroup(entityID, groupName string) error {
 // addEntityToGroup adds an entity to a group by name, if the entity
 // was already in the group the function will return with a nil error.
 func (m *Manager) addEntityToGroup(e *pb.Entity, groupName string) error {
-	if _, err := m.db.LoadGroup(groupName); err != nil {
+	g, err := m.db.LoadGroup(groupName)
+	if err != nil {
 		return err
 	}
 
@@ -38,40 +39,31 @@ func (m *Manager) addEntityToGroup(e *pb.Entity, groupName string) error {
 		}
 	}
 
+	hd := &HookData{Point: HookAddEntityToGroup, Entity: e, Group: g}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
 	// At this point we can be reasonably certain that the entity
 	// is not in the named group via direct membership.
 	e.Meta.Groups = append(e.Meta.Groups, groupName)
 
-	return m.db.SaveEntity(e)
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	m.runPostHooks(hd)
+	return nil
 }
 
 // GetMemberships returns all groups the entity is a member of,
 // optionally including indirect memberships
 func (m *Manager) GetMemberships(e *pb.Entity, includeIndirects bool) []string {
-	directs := m.getDirectGroups(e)
-	var allGroups []string
-
-	// Though inneficient, its easier to understand.  We get the
-	// membership of all groups, and evaluate if this entity is a
-	// member of those groups.
-	grps, err := m.ListGroups()
+	allGroups, err := m.graph.groups(m.db, e.GetID())
 	if err != nil {
-		log.Printf("Error getting group list: %s", grps)
+		log.Printf("Error expanding groups: %s", err)
 		return []string{}
 	}
-	for _, g := range grps {
-		members, err := m.listMembers(g.GetName())
-		if err != nil {
-			log.Printf("Error expanding groups: %s", err)
-			continue
-		}
-		for _, m := range members {
-			if m.GetID() == e.GetID() {
-				allGroups = append(allGroups, g.GetName())
-				break
-			}
-		}
-	}
 
 	// If we're including indirects, then we can return allGroups
 	// here
@@ -82,23 +74,14 @@ func (m *Manager) GetMemberships(e *pb.Entity, includeIndirects bool) []string {
 	// This far?  Only returning directs as filtered by allGroups.
 	// This is because there could be things that filter entities
 	// out of groups they would otherwise be directly in.
-	gm := make(map[string]int)
-	for _, g := range directs {
-		gm[g]++
-	}
+	effective := make(map[string]bool, len(allGroups))
 	for _, g := range allGroups {
-		gm[g]++
+		effective[g] = true
 	}
-
-	// gm now contains a map where groups that are both in
-	// allGroups (membership is valid) and in directs (groups we
-	// want to return here) will have a value of 2, so all we do
-	// at this stage is get the groups that are equal to 2 and
-	// return those.
 	var retGroups []string
-	for name, value := range gm {
-		if value == 2 {
-			retGroups = append(retGroups, name)
+	for _, g := range m.getDirectGroups(e) {
+		if effective[g] {
+			retGroups = append(retGroups, g)
 		}
 	}
 	return retGroups
@@ -120,8 +103,7 @@ func (m *Manager) RemoveEntityFromGroup(entityID, groupName string) error {
 	if err != nil {
 		return err
 	}
-	m.removeEntityFromGroup(e, groupName)
-	return nil
+	return m.removeEntityFromGroup(e, groupName)
 }
 
 // removeEntityFromGroup removes an entity from the named group.  If
@@ -132,6 +114,18 @@ func (m *Manager) removeEntityFromGroup(e *pb.Entity, groupName string) error {
 		return nil
 	}
 
+	// The group may already be gone, in which case the hooks
+	// only get to see its name.
+	g, err := m.db.LoadGroup(groupName)
+	if err != nil {
+		g = &pb.Group{Name: &groupName}
+	}
+
+	hd := &HookData{Point: HookRemoveEntityFromGroup, Entity: e, Group: g}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
 	newGroups := []string{}
 	for _, g := range e.GetMeta().GetGroups() {
 		if g == groupName {
@@ -141,7 +135,12 @@ func (m *Manager) removeEntityFromGroup(e *pb.Entity, groupName string) error {
 	}
 	e.Meta.Groups = newGroups
 
-	return m.db.SaveEntity(e)
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	m.runPostHooks(hd)
+	return nil
 }
 
 // allEntities is a convenient way to return all the entities
@@ -162,7 +161,9 @@ func (m *Manager) allEntities() ([]*pb.Entity, error) {
 }
 
 // listMembers takes a group ID in and returns a slice of entities
-// that are in that group.
+// that are in that group.  This will be the entities that are in the
+// group and all of its expansions, but not any that would be excluded
+// from this group or the subexpansions.
 func (m *Manager) listMembers(groupID string) ([]*pb.Entity, error) {
 	// 'ALL' is a special groupID which returns everything, this
 	// isn't a group that exists in a real sense, it just serves
@@ -171,59 +172,19 @@ func (m *Manager) listMembers(groupID string) ([]*pb.Entity, error) {
 		return m.allEntities()
 	}
 
-	// If its not the all group then we check to make sure the
-	// group exists at all
-	g, err := m.db.LoadGroup(groupID)
+	IDs, err := m.graph.members(m.db, groupID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Now we can be reasonably sure the group exists, this next
-	// bit is stupidly inefficient, but is the only way to extract
-	// the members since the membership graph has the arrows going
-	// the other way.
 	var entities []*pb.Entity
-	el, err := m.allEntities()
-	if err != nil {
-		return nil, err
-	}
-	for _, e := range el {
-		for _, g := range m.getDirectGroups(e) {
-			if g == groupID {
-				entities = append(entities, e)
-			}
-		}
-	}
-
-	// Now we parse the expansions.
-	var exclude []*pb.Entity
-	for _, exp := range g.GetExpansions() {
-		parts := strings.Split(exp, ":")
-		ents, err := m.listMembers(parts[1])
+	for _, ID := range IDs {
+		e, err := m.db.LoadEntity(ID)
 		if err != nil {
-			log.Printf("Expansion parsing error! %s", err)
-		}
-		switch parts[0] {
-		case "INCLUDE":
-			entities = append(entities, ents...)
-		case "EXCLUDE":
-			exclude = append(exclude, ents...)
+			return nil, err
 		}
+		entities = append(entities, e)
 	}
-
-	// Its likely that we've got duplicates in the lists now, so
-	// dedup things to get back down to one copy of everything.
-	entities = dedupEntityList(entities)
-	exclude = dedupEntityList(exclude)
-
-	// Actually exclude the excluded entities
-	if len(exclude) > 0 {
-		entities = entityListDifference(entities, exclude)
-	}
-
-	// This will be the entities that are in this group and all of
-	// its expansions, but not any that would be excluded from
-	// this group or the subexpansions.
 	return entities, nil
 }
 
@@ -309,6 +270,11 @@ func (m *Manager) ModifyGroupExpansions(parent, child string, mode pb.ExpansionM
 		return ErrExistingExpansion
 	}
 
+	hd := &HookData{Point: HookModifyGroupExpansions, Group: p, ChildGroup: c, Mode: mode}
+	if err := m.runPreHooks(hd); err != nil {
+		return err
+	}
+
 	// Either add the include, add the exclude, or drop the old
 	// record.
 	switch mode {
@@ -328,7 +294,12 @@ func (m *Manager) ModifyGroupExpansions(parent, child string, mode pb.ExpansionM
 		p.Expansions = new
 	}
 
-	return m.db.SaveGroup(p)
+	if err := m.db.SaveGroup(p); err != nil {
+		return err
+	}
+
+	m.runPostHooks(hd)
+	return nil
 }
 
 // dedupEntityList takes in a list of entities and deduplicates them
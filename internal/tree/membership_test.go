@@ -1,9 +1,12 @@
 package tree
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
 
 	pb "github.com/NetAuth/Protocol"
 )
@@ -511,8 +514,9 @@ func TestCheckGroupCyclesCorruptDB(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Make the directory inconsistent by deleting grp2
-	if err := em.DeleteGroup("grp2"); err != nil {
+	// Make the directory inconsistent by deleting grp2 behind
+	// the Manager's back.
+	if err := em.db.DeleteGroup("grp2"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -614,3 +618,228 @@ func TestEntityListDifference(t *testing.T) {
 		t.Fatal("Difference contains wrong result!")
 	}
 }
+
+// populateBenchTree fills the tree with entities and groups directly
+// through the database, since creating this many entities through the
+// Manager would dominate the time taken.  Every entity is in 3
+// groups, and some of the groups include or exclude others.
+func populateBenchTree(t testing.TB, em *Manager, entities, groups int) {
+	for i := 0; i < groups; i++ {
+		g := &pb.Group{
+			Name:   proto.String(fmt.Sprintf("group%d", i)),
+			Number: proto.Int32(int32(i)),
+		}
+		if i%10 == 0 && i+1 < groups {
+			g.Expansions = append(g.Expansions, fmt.Sprintf("INCLUDE:group%d", i+1))
+		}
+		if i%50 == 0 && i+2 < groups {
+			g.Expansions = append(g.Expansions, fmt.Sprintf("EXCLUDE:group%d", i+2))
+		}
+		if err := em.db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < entities; i++ {
+		e := &pb.Entity{
+			ID:     proto.String(fmt.Sprintf("entity%d", i)),
+			Number: proto.Int32(int32(i)),
+			Meta: &pb.EntityMeta{
+				Groups: []string{
+					fmt.Sprintf("group%d", i%groups),
+					fmt.Sprintf("group%d", (i*7)%groups),
+					fmt.Sprintf("group%d", (i*13)%groups),
+				},
+			},
+		}
+		if err := em.db.SaveEntity(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scanMembers finds the members of a group the way the tree did
+// before it kept a membership graph, by loading every entity.  It is
+// only here to give the benchmarks a baseline.
+func scanMembers(m *Manager, groupName string) ([]*pb.Entity, error) {
+	g, err := m.db.LoadGroup(groupName)
+	if err != nil {
+		return nil, err
+	}
+	el, err := m.allEntities()
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []*pb.Entity
+	for _, e := range el {
+		for _, name := range m.getDirectGroups(e) {
+			if name == groupName {
+				entities = append(entities, e)
+			}
+		}
+	}
+
+	var exclude []*pb.Entity
+	for _, exp := range g.GetExpansions() {
+		parts := strings.Split(exp, ":")
+		ents, err := scanMembers(m, parts[1])
+		if err != nil {
+			return nil, err
+		}
+		switch parts[0] {
+		case "INCLUDE":
+			entities = append(entities, ents...)
+		case "EXCLUDE":
+			exclude = append(exclude, ents...)
+		}
+	}
+
+	entities = dedupEntityList(entities)
+	if len(exclude) > 0 {
+		entities = entityListDifference(entities, dedupEntityList(exclude))
+	}
+	return entities, nil
+}
+
+// scanMemberships finds the groups an entity is in by listing the
+// members of every group with scanMembers.
+func scanMemberships(m *Manager, e *pb.Entity) ([]string, error) {
+	grps, err := m.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, g := range grps {
+		members, err := scanMembers(m, g.GetName())
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.GetID() == e.GetID() {
+				groups = append(groups, g.GetName())
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+func TestScanMatchesGraph(t *testing.T) {
+	em := getNewEntityManager(t)
+	populateBenchTree(t, em, 100, 20)
+
+	for _, name := range []string{"group0", "group10", "group19"} {
+		members, err := em.ListMembers(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanned, err := scanMembers(em, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != len(scanned) {
+			t.Errorf("%s: graph has %d members, scan has %d", name, len(members), len(scanned))
+		}
+	}
+
+	e, err := em.GetEntity("entity42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := scanMemberships(em, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := em.GetMemberships(e, true); len(got) != len(groups) {
+		t.Errorf("Graph has memberships %v, scan has %v", got, groups)
+	}
+}
+
+func BenchmarkGetMemberships(b *testing.B) {
+	em := getNewEntityManager(b)
+	populateBenchTree(b, em, 10000, 1000)
+	e, err := em.GetEntity("entity42")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(em.GetMemberships(e, true)) == 0 {
+			b.Fatal("No memberships")
+		}
+	}
+}
+
+func BenchmarkListMembers(b *testing.B) {
+	em := getNewEntityManager(b)
+	populateBenchTree(b, em, 10000, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := em.ListMembers("group10"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetMembershipsScan and BenchmarkListMembersScan measure
+// the same lookups without the membership graph.
+func BenchmarkGetMembershipsScan(b *testing.B) {
+	em := getNewEntityManager(b)
+	populateBenchTree(b, em, 10000, 1000)
+	e, err := em.GetEntity("entity42")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		groups, err := scanMemberships(em, e)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(groups) == 0 {
+			b.Fatal("No memberships")
+		}
+	}
+}
+
+func BenchmarkListMembersScan(b *testing.B) {
+	em := getNewEntityManager(b)
+	populateBenchTree(b, em, 10000, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := scanMembers(em, "group10"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetMembershipsAfterChange measures the worst case, where
+// the tree changes between every lookup.
+func BenchmarkGetMembershipsAfterChange(b *testing.B) {
+	em := getNewEntityManager(b)
+	populateBenchTree(b, em, 10000, 1000)
+	e, err := em.GetEntity("entity42")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			err = em.AddEntityToGroup("entity43", "group999")
+		} else {
+			err = em.RemoveEntityFromGroup("entity43", "group999")
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(em.GetMemberships(e, true)) == 0 {
+			b.Fatal("No memberships")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tree
+
+import (
+	"sync"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// revisionedDB gives each entity and group a new revision every time
+// it is saved.  The revision lets a client that read a record detect
+// that someone else has changed it since.  A record is only saved if
+// the stored copy is still at the revision it was loaded at, so a
+// change made from a stale copy is refused with ErrStaleRevision
+// rather than overwriting the changes made since.
+type revisionedDB struct {
+	db.DB
+
+	// mu is held while a revision is checked and moved on, and
+	// for the whole of a transaction so that nothing can change
+	// a record between its check and the commit.  It is nil
+	// inside a transaction, where it is already held.
+	mu *sync.Mutex
+}
+
+// SaveEntity moves the entity on to its next revision and saves it.
+func (d revisionedDB) SaveEntity(e *pb.Entity) error {
+	if d.mu != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+
+	cur, err := d.DB.LoadEntity(e.GetID())
+	switch {
+	case err == db.ErrUnknownEntity:
+	case err != nil:
+		return err
+	case cur.GetRevision() != e.GetRevision():
+		return ErrStaleRevision
+	}
+
+	e.Revision = proto.Uint64(e.GetRevision() + 1)
+	return d.DB.SaveEntity(e)
+}
+
+// SaveGroup moves the group on to its next revision and saves it.
+func (d revisionedDB) SaveGroup(g *pb.Group) error {
+	if d.mu != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+	}
+
+	cur, err := d.DB.LoadGroup(g.GetName())
+	switch {
+	case err == db.ErrUnknownGroup:
+	case err != nil:
+		return err
+	case cur.GetRevision() != g.GetRevision():
+		return ErrStaleRevision
+	}
+
+	g.Revision = proto.Uint64(g.GetRevision() + 1)
+	return d.DB.SaveGroup(g)
+}
+
+// Update runs a transaction on the wrapped database if it supports
+// them, with the records saved inside it being revisioned as well.
+func (d revisionedDB) Update(fn func(db.DB) error) error {
+	t, ok := d.DB.(db.Transactor)
+	if !ok {
+		return fn(d)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return t.Update(func(tx db.DB) error {
+		return fn(revisionedDB{DB: tx})
+	})
+}
+
+// checkRevision returns ErrStaleRevision if a record is not at the
+// revision the caller expects it to be.  An expected revision of 0
+// skips the check for callers that don't care.
+func checkRevision(have, want uint64) error {
+	if want != 0 && have != want {
+		return ErrStaleRevision
+	}
+	return nil
+}
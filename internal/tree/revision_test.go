@@ -0,0 +1,184 @@
+package tree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+func TestRevisionIncreases(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewGroup("foo", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+	g, err := em.GetGroupByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetRevision() != 1 {
+		t.Errorf("New group is at revision %d", g.GetRevision())
+	}
+
+	if err := em.NewEntity("bar", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := e.GetRevision()
+
+	// Changes made in a transaction are revisioned too.
+	if err := em.AddEntityToGroup("bar", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.update(func(tm *Manager) error {
+		return tm.RemoveEntityFromGroup("bar", "foo")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = em.GetEntity("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetRevision() != rev+2 {
+		t.Errorf("Entity is at revision %d, want %d", e.GetRevision(), rev+2)
+	}
+}
+
+func TestStaleSaveRefused(t *testing.T) {
+	em := getNewCopyingEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else changes the entity after it was loaded, so
+	// saving the loaded copy would undo their change.
+	if err := em.LockEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+	e.Meta.Shell = proto.String("/bin/sh")
+	if err := em.db.SaveEntity(e); err != ErrStaleRevision {
+		t.Fatal(err)
+	}
+
+	e, err = em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.GetMeta().GetLocked() || e.GetMeta().GetShell() != "" {
+		t.Errorf("Stale save was applied: %v", e)
+	}
+}
+
+func TestUpdateEntityMetaStale(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := e.GetRevision()
+
+	meta := &pb.EntityMeta{Shell: proto.String("/bin/sh")}
+	if err := em.UpdateEntityMeta("foo", rev, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same revision is now out of date.
+	meta = &pb.EntityMeta{Shell: proto.String("/bin/zsh")}
+	if err := em.UpdateEntityMeta("foo", rev, meta); err != ErrStaleRevision {
+		t.Fatal(err)
+	}
+
+	e, err = em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetMeta().GetShell() != "/bin/sh" {
+		t.Errorf("Stale update was applied: %v", e)
+	}
+	if e.GetRevision() != rev+1 {
+		t.Errorf("Entity is at revision %d, want %d", e.GetRevision(), rev+1)
+	}
+}
+
+func TestUpdateGroupMetaStale(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewGroup("foo", "", "", -1); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &pb.Group{DisplayName: proto.String("First"), Revision: proto.Uint64(1)}
+	if err := em.UpdateGroupMeta("foo", update.GetRevision(), update); err != nil {
+		t.Fatal(err)
+	}
+
+	update = &pb.Group{DisplayName: proto.String("Second"), Revision: proto.Uint64(1)}
+	if err := em.UpdateGroupMeta("foo", update.GetRevision(), update); err != ErrStaleRevision {
+		t.Fatal(err)
+	}
+
+	g, err := em.GetGroupByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetDisplayName() != "First" || g.GetRevision() != 2 {
+		t.Errorf("Wrong group after updates: %v", g)
+	}
+}
+
+func TestUpdateEntityMetaConcurrent(t *testing.T) {
+	em := getNewCopyingEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := e.GetRevision()
+
+	// Everyone read the same revision, so only one of them may
+	// win.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- em.UpdateEntityMeta("foo", rev, &pb.EntityMeta{Shell: proto.String("/bin/sh")})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	ok := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			ok++
+		case ErrStaleRevision:
+		default:
+			t.Error(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d updates succeeded", ok)
+	}
+}
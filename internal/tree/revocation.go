@@ -0,0 +1,91 @@
+package tree
+
+import (
+	"log"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// GetTokenRevocation returns the revocation state for the tokens of
+// the named entity.  If the entity does not exist an error is
+// returned, and any tokens it may have held should be considered
+// invalid.
+func (m *Manager) GetTokenRevocation(ID string) (*pb.TokenRevocation, error) {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &pb.TokenRevocation{}
+	if e.GetTokenRevocation() != nil {
+		proto.Merge(r, e.GetTokenRevocation())
+	}
+	return r, nil
+}
+
+// RevokeToken revokes a single token issued to the named entity.  The
+// expiry of the token is recorded so that the revocation can be
+// forgotten once the token would no longer be valid anyway.
+func (m *Manager) RevokeToken(ID, tokenID string, expires time.Time) error {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return err
+	}
+
+	if e.TokenRevocation == nil {
+		e.TokenRevocation = &pb.TokenRevocation{}
+	}
+
+	// Drop revocations for tokens that have expired on their
+	// own.
+	now := time.Now().Unix()
+	var tokens []*pb.RevokedToken
+	for _, t := range e.TokenRevocation.GetTokens() {
+		if t.GetExpires() > now {
+			tokens = append(tokens, t)
+		}
+	}
+	tokens = append(tokens, &pb.RevokedToken{
+		ID:      &tokenID,
+		Expires: proto.Int64(expires.Unix()),
+	})
+	e.TokenRevocation.Tokens = tokens
+
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	log.Printf("Revoked token '%s' for '%s'", tokenID, ID)
+	return nil
+}
+
+// RevokeAllTokens revokes every token that has been issued to the
+// named entity up to now.
+func (m *Manager) RevokeAllTokens(ID string) error {
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return err
+	}
+
+	revokeAllTokens(e)
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	log.Printf("Revoked all tokens for '%s'", ID)
+	return nil
+}
+
+// revokeAllTokens sets the cutoff on the entity so that all tokens
+// issued to it so far are no longer valid.  The cutoff is kept to the
+// nanosecond so that a token issued in the same second, but after
+// the cutoff, is still accepted.  Individual revocations are covered
+// by the cutoff and are dropped.  The entity is not saved.
+func revokeAllTokens(e *pb.Entity) {
+	e.TokenRevocation = &pb.TokenRevocation{
+		IssuedBefore: proto.Int64(time.Now().UnixNano()),
+	}
+}
@@ -0,0 +1,249 @@
+package tree
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/NetAuth/NetAuth/internal/token/jwt"
+	"github.com/NetAuth/NetAuth/internal/token/revocation"
+)
+
+func TestRevokeToken(t *testing.T) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := em.GetTokenRevocation("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutoff := r.GetIssuedBefore()
+
+	// The expired revocation should be dropped when the next one
+	// is added.
+	if err := em.RevokeToken("foo", "old", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.RevokeToken("foo", "new", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = em.GetTokenRevocation("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.GetTokens()) != 1 || r.GetTokens()[0].GetID() != "new" {
+		t.Errorf("Wrong revoked tokens: %v", r.GetTokens())
+	}
+	if r.GetIssuedBefore() != cutoff {
+		t.Errorf("Cutoff changed unexpectedly: %d", r.GetIssuedBefore())
+	}
+}
+
+func TestRevokeAllTokens(t *testing.T) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.RevokeToken("foo", "tkn", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().UnixNano()
+	if err := em.RevokeAllTokens("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := em.GetTokenRevocation("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetIssuedBefore() < before {
+		t.Errorf("Cutoff not set: %d", r.GetIssuedBefore())
+	}
+	if len(r.GetTokens()) != 0 {
+		t.Errorf("Individual revocations were kept: %v", r.GetTokens())
+	}
+}
+
+func TestTokensIssuedAfterRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flag.Set("jwt_ed25519_privatekey", filepath.Join(dir, "token.key"))
+	flag.Set("jwt_ed25519_publickey", filepath.Join(dir, "token.pem"))
+	flag.Set("jwt_ed25519_generate", "true")
+
+	x, err := jwt.NewEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := getNewEntityManager(t)
+	s := revocation.New(x, em)
+
+	// Tokens are issued within the same second as the entity is
+	// created and its tokens are revoked, and only the ones issued
+	// before the revocation may be refused.
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.Generate(token.Claims{EntityID: "foo"}, token.GetConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(before); err != nil {
+		t.Errorf("Token issued after creation was refused: %v", err)
+	}
+
+	if err := em.RevokeAllTokens("foo"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Generate(token.Claims{EntityID: "foo"}, token.GetConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(after); err != nil {
+		t.Errorf("Token issued after revocation was refused: %v", err)
+	}
+	if _, err := s.Validate(before); err != token.ErrTokenInvalid {
+		t.Errorf("Revoked token was accepted: %v", err)
+	}
+}
+
+func TestLockRevokesTokens(t *testing.T) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.LockEntity("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := em.GetTokenRevocation("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GetIssuedBefore() == 0 {
+		t.Error("Locking did not revoke tokens")
+	}
+}
+
+// staticTokenService accepts every token as carrying its claims.
+type staticTokenService struct {
+	token.Claims
+}
+
+func (staticTokenService) Generate(token.Claims, token.Config) (string, error) { return "", nil }
+func (s staticTokenService) Validate(string) (token.Claims, error)             { return s.Claims, nil }
+
+// tokenIssuedEarlier makes the entity look like it was created a
+// while ago, and returns a revocation service that sees a token that
+// was issued to it since.
+func tokenIssuedEarlier(t *testing.T, em *Manager, ID string) token.Service {
+	e, err := em.db.LoadEntity(ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.TokenRevocation.IssuedBefore = proto.Int64(time.Now().Add(-2 * time.Minute).UnixNano())
+	if err := em.db.SaveEntity(e); err != nil {
+		t.Fatal(err)
+	}
+
+	s := revocation.New(staticTokenService{token.Claims{
+		EntityID: ID,
+		IssuedAt: time.Now().Add(-time.Minute),
+	}}, em)
+	if _, err := s.Validate(""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRecreatedEntityRefusesOldTokens(t *testing.T) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := tokenIssuedEarlier(t, em, "foo")
+
+	if err := em.DeleteEntityByID("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(""); err != token.ErrTokenInvalid {
+		t.Errorf("Token for the old entity was accepted: %v", err)
+	}
+}
+
+func TestRenamedEntityRefusesOldTokens(t *testing.T) {
+	em := getNewEntityManager(t)
+	for _, ID := range []string{"foo", "bar"} {
+		if err := em.NewEntity(ID, -1, ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renamed := tokenIssuedEarlier(t, em, "foo")
+	replaced := tokenIssuedEarlier(t, em, "bar")
+
+	// The entity takes the ID of one that has been deleted, and
+	// neither the tokens issued to it nor those issued to the
+	// deleted entity may be used.
+	if err := em.DeleteEntityByID("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.RenameEntity("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renamed.Validate(""); err != token.ErrTokenInvalid {
+		t.Errorf("Token for the old ID was accepted: %v", err)
+	}
+	if _, err := replaced.Validate(""); err != token.ErrTokenInvalid {
+		t.Errorf("Token for the deleted entity was accepted: %v", err)
+	}
+}
+
+func TestRevocationUnknownEntity(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if _, err := em.GetTokenRevocation("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	if err := em.RevokeToken("foo", "tkn", time.Now()); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+	if err := em.RevokeAllTokens("foo"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
+
+func TestTokenRevocationRedacted(t *testing.T) {
+	em := getNewEntityManager(t)
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.RevokeAllTokens("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetTokenRevocation() != nil {
+		t.Error("Token revocations were not removed")
+	}
+}
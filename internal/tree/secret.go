@@ -0,0 +1,126 @@
+package tree
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+var (
+	secretHistory = flag.Int("secret_history", 0, "Number of previous secrets, besides the current one, that may not be reused")
+	secretMaxAge  = flag.Duration("secret_max_age", 0, "Age at which secrets expire, 0 disables expiry")
+)
+
+// checkSecretHistory returns a SecretPolicyError if the secret
+// matches the entity's current secret or any of the ones kept in its
+// history.  The history holds secretHistory secrets on top of the
+// current one.  If history is not being kept nothing is checked.
+func (m *Manager) checkSecretHistory(e *pb.Entity, secret string) error {
+	if m.secretHistory <= 0 {
+		return nil
+	}
+
+	hashes := e.GetSecretHistory()
+	if e.GetSecret() != "" {
+		hashes = append([]string{e.GetSecret()}, hashes...)
+	}
+	for _, h := range hashes {
+		if err := m.crypto.VerifySecret(secret, h); err == nil {
+			return &SecretPolicyError{
+				Reason: fmt.Sprintf("must not be the same as the current secret or any of the %d before it", m.secretHistory),
+			}
+		}
+	}
+	return nil
+}
+
+// rotateSecret moves the entity's current secret into its history,
+// drops any history beyond what is being kept, and installs the new
+// secured secret.  The time of the change is left to the caller.
+func (m *Manager) rotateSecret(e *pb.Entity, ssecret string) {
+	var history []string
+	if m.secretHistory > 0 {
+		if e.GetSecret() != "" {
+			history = append(history, e.GetSecret())
+		}
+		history = append(history, e.GetSecretHistory()...)
+		if len(history) > m.secretHistory {
+			history = history[:m.secretHistory]
+		}
+	}
+	e.SecretHistory = history
+	e.Secret = &ssecret
+}
+
+// secretExpiry returns the time at which the entity's secret expires.
+// If secrets do not expire, or the entity has no record of when its
+// secret was last changed, the zero time is returned.
+func (m *Manager) secretExpiry(e *pb.Entity) time.Time {
+	if m.secretMaxAge <= 0 || e.GetSecretChanged() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(e.GetSecretChanged(), 0).Add(m.secretMaxAge)
+}
+
+// secretExpired returns true if the entity's secret has expired.
+func (m *Manager) secretExpired(e *pb.Entity) bool {
+	exp := m.secretExpiry(e)
+	return !exp.IsZero() && time.Now().After(exp)
+}
+
+// ImportEntitySecretByID sets the secret on a given entity to a hash
+// that was secured elsewhere, such as one taken from /etc/shadow.
+// The hash is stored as is, and will be secured again by the current
+// crypto engine the first time the entity authenticates.  Since the
+// plaintext isn't known, secret policy hooks aren't run.  The time
+// the hash was made isn't known either, so no change time is
+// recorded and the imported secret doesn't expire until it is next
+// changed.
+func (m *Manager) ImportEntitySecretByID(ID string, hash string) error {
+	if r, ok := m.crypto.(crypto.Recognizer); ok && !r.Recognizes(hash) {
+		return ErrUnknownSecretFormat
+	}
+	if p, ok := m.crypto.(crypto.Parser); ok && !p.Parses(hash) {
+		return ErrUnknownSecretFormat
+	}
+
+	e, err := m.db.LoadEntity(ID)
+	if err != nil {
+		return err
+	}
+
+	m.rotateSecret(e, hash)
+	e.SecretChanged = nil
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+
+	log.Printf("Secret imported for '%s'", e.GetID())
+	return nil
+}
+
+// rehashSecret secures the secret again with the current crypto
+// engine if the engine reports that the stored hash is outdated.  The
+// secret itself is unchanged, so its history and age are left alone.
+func (m *Manager) rehashSecret(e *pb.Entity, secret string) error {
+	r, ok := m.crypto.(crypto.Rehasher)
+	if !ok || !r.NeedsRehash(e.GetSecret()) {
+		return nil
+	}
+
+	ssecret, err := m.crypto.SecureSecret(secret)
+	if err != nil {
+		return err
+	}
+	e.Secret = &ssecret
+	if err := m.db.SaveEntity(e); err != nil {
+		return err
+	}
+	log.Printf("Secret for '%s' has been rehashed", e.GetID())
+	return nil
+}
@@ -0,0 +1,288 @@
+package tree
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NetAuth/NetAuth/internal/crypto"
+	_ "github.com/NetAuth/NetAuth/internal/crypto/argon2"
+	_ "github.com/NetAuth/NetAuth/internal/crypto/crypt"
+	"github.com/NetAuth/NetAuth/internal/db"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestSecretHistory(t *testing.T) {
+	em := getNewEntityManager(t)
+	em.secretHistory = 2
+
+	if err := em.NewEntity("foo", -1, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := []struct {
+		secret string
+		wantOK bool
+	}{
+		{"a", false}, // Current secret
+		{"b", true},
+		{"c", true},
+		{"a", false}, // Still in history
+		{"b", false},
+		{"d", true},
+		{"a", true}, // Fallen out of the history
+	}
+
+	for i, c := range s {
+		err := em.SetEntitySecretByID("foo", c.secret)
+		if c.wantOK && err != nil {
+			t.Errorf("%d: Unexpected error: %s", i, err)
+		}
+		if !c.wantOK {
+			if spe, ok := err.(*SecretPolicyError); !ok {
+				t.Errorf("%d: Wrong error: %v", i, err)
+			} else if !strings.Contains(spe.Reason, "any of the 2 before it") {
+				t.Errorf("%d: Wrong reason: %s", i, spe.Reason)
+			}
+		}
+	}
+
+	e, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.GetSecretHistory()) != 2 {
+		t.Errorf("Wrong history length: %v", e.GetSecretHistory())
+	}
+
+	// The history must not leave the server.
+	safe, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if safe.GetSecretHistory() != nil {
+		t.Error("Secret history was not redacted")
+	}
+}
+
+func TestSecretHistoryDisabled(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.NewEntity("foo", -1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.SetEntitySecretByID("foo", "a"); err != nil {
+		t.Error(err)
+	}
+	e, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.GetSecretHistory()) != 0 {
+		t.Error("History was kept when disabled")
+	}
+}
+
+func TestSecretExpiry(t *testing.T) {
+	em := getNewEntityManager(t)
+	em.secretMaxAge = time.Hour
+
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh secret is fine.
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetSecretExpires() != e.GetSecretChanged()+3600 {
+		t.Errorf("Wrong expiry: %d", e.GetSecretExpires())
+	}
+
+	// Age the secret.
+	raw, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*raw.SecretChanged -= 7200
+	if err := em.db.SaveEntity(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.ValidateSecret("foo", "foo"); err != ErrSecretExpired {
+		t.Fatal(err)
+	}
+
+	// A bad secret is still just a bad secret.
+	if err := em.ValidateSecret("foo", "bar"); err == ErrSecretExpired {
+		t.Fatal("Expired reported for a bad secret")
+	}
+
+	// Changing the secret fixes things.
+	if err := em.SetEntitySecretByID("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ValidateSecret("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretExpiryLegacyEntity(t *testing.T) {
+	em := getNewEntityManager(t)
+	em.secretMaxAge = time.Hour
+
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entities from before the change time was recorded never
+	// expire.
+	raw, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.SecretChanged = nil
+	if err := em.db.SaveEntity(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := em.GetEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SecretExpires != nil {
+		t.Error("Expiry reported for legacy entity")
+	}
+}
+
+// rehashCrypto stores secrets in the clear, marked with the version of
+// the "algorithm" that secured them.
+type rehashCrypto struct{}
+
+func (rehashCrypto) SecureSecret(s string) (string, error) { return "new:" + s, nil }
+
+func (rehashCrypto) VerifySecret(s, h string) error {
+	if h != "new:"+s && h != "old:"+s {
+		return crypto.ErrAuthorizationFailure
+	}
+	return nil
+}
+
+func (rehashCrypto) NeedsRehash(h string) bool { return strings.HasPrefix(h, "old:") }
+
+func TestRehashSecret(t *testing.T) {
+	em := getNewEntityManager(t)
+	em.crypto = rehashCrypto{}
+
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Secret = proto.String("old:foo")
+	changed := raw.GetSecretChanged()
+	if err := em.db.SaveEntity(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed validation must not rehash.
+	if err := em.ValidateSecret("foo", "bar"); err != crypto.ErrAuthorizationFailure {
+		t.Fatal(err)
+	}
+	raw, err = em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.GetSecret() != "old:foo" {
+		t.Errorf("Secret changed on failure: %s", raw.GetSecret())
+	}
+
+	if err := em.ValidateSecret("foo", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.GetSecret() != "new:foo" {
+		t.Errorf("Secret was not rehashed: %s", raw.GetSecret())
+	}
+	if raw.GetSecretChanged() != changed {
+		t.Error("Rehash changed the secret age")
+	}
+}
+
+func TestImportEntitySecret(t *testing.T) {
+	em := getNewEntityManager(t)
+	m, err := crypto.NewMulti("nocrypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	em.crypto = m
+
+	if err := em.NewEntity("foo", -1, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashes that are recognized by an engine but which no secret
+	// could match are refused too.
+	for _, h := range []string{
+		"not a hash",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$garbage",
+		"$6$saltstring$truncated",
+		"$6$",
+	} {
+		if err := em.ImportEntitySecretByID("foo", h); err != ErrUnknownSecretFormat {
+			t.Errorf("%s: %v", h, err)
+		}
+	}
+
+	hash := "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"
+	if err := em.ImportEntitySecretByID("foo", hash); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.GetSecret() != hash {
+		t.Errorf("Secret was not imported as is: %s", raw.GetSecret())
+	}
+	if raw.GetSecretChanged() != 0 {
+		t.Error("Imported secret was given a change time")
+	}
+
+	// The first good authentication moves the secret to the
+	// primary engine.
+	if err := em.ValidateSecret("foo", "password"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = em.db.LoadEntity("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.GetSecret() != "password" {
+		t.Errorf("Secret was not upgraded: %s", raw.GetSecret())
+	}
+	if err := em.ValidateSecret("foo", "password"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportEntitySecretUnknownEntity(t *testing.T) {
+	em := getNewEntityManager(t)
+
+	if err := em.ImportEntitySecretByID("foo", "bar"); err != db.ErrUnknownEntity {
+		t.Error(err)
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"testing"
 
 	"github.com/NetAuth/NetAuth/internal/crypto/nocrypto"
+	"github.com/NetAuth/NetAuth/internal/db"
 	"github.com/NetAuth/NetAuth/internal/db/memdb"
 )
 
-func getNewEntityManager(t *testing.T) *Manager {
+func getNewEntityManager(t testing.TB) *Manager {
 	db, err := memdb.New()
 	if err != nil {
 		t.Fatal(err)
@@ -20,3 +21,32 @@ func getNewEntityManager(t *testing.T) *Manager {
 
 	return New(db, crypto)
 }
+
+// noTxnDB hides the transaction support of the database it wraps, to
+// test the paths taken for backends that have none.
+type noTxnDB struct {
+	db.DB
+}
+
+func getNewNoTxnEntityManager(t testing.TB) *Manager {
+	m := getNewEntityManager(t)
+	m.db = noTxnDB{m.db}
+	return m
+}
+
+// getNewCopyingEntityManager returns a Manager on a database that may
+// be used from many goroutines, and that hands out copies of records
+// as a real backend would.
+func getNewCopyingEntityManager(t testing.TB) *Manager {
+	db, err := memdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crypto, err := nocrypto.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(&lockedDB{DB: db}, crypto)
+}
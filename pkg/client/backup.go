@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// Backup asks the server for an archive of all entities and groups
+// and writes it to w.  If passphrase is not empty the archive is
+// encrypted to it.  The archive contains secrets, so the token must
+// carry GLOBAL_ROOT.
+func (n *NetAuthClient) Backup(t, passphrase string, w io.Writer) error {
+	request := pb.BackupRequest{
+		AuthToken:  &t,
+		Passphrase: &passphrase,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	stream, err := n.c.Backup(context.Background(), &request)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.GetData()); err != nil {
+			return err
+		}
+	}
+}
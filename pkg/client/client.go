@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/NetAuth/NetAuth/internal/token"
 	"github.com/NetAuth/NetAuth/internal/tree"
@@ -24,7 +25,11 @@ type NetAuthClient struct {
 	cfg        *NACLConfig
 	tokenStore TokenStore
 
-	tokenService token.Service
+	// Token services are created as tokens signed with
+	// different algorithms are seen.  The client may be shared
+	// between goroutines, so the map is guarded by tsMu.
+	tsMu          sync.Mutex
+	tokenServices map[string]token.Service
 }
 
 // The NACLConfig configures the library to make connections to a
@@ -90,6 +95,29 @@ func (n *NetAuthClient) Authenticate(entity string, secret string) (*pb.SimpleRe
 	return result, nil
 }
 
+// ImportSecret sets the secret of the named entity to a hash that was
+// secured elsewhere, such as one from /etc/shadow.  The hash is sent
+// as is.
+func (n *NetAuthClient) ImportSecret(t, entity, hash string) (*pb.SimpleResult, error) {
+	request := pb.NetAuthRequest{
+		Entity: &pb.Entity{
+			ID:     &entity,
+			Secret: &hash,
+		},
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.ImportSecret(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
 // ValidateToken sends the token to the server for validation.  This
 // is effectively asking the server to authenticate the token and not
 // do anything else.  Returns a comment from the server and an error.
@@ -188,6 +216,26 @@ func (n *NetAuthClient) RemoveEntity(id, token string) (*pb.SimpleResult, error)
 	return result, nil
 }
 
+// RenameEntity changes the ID of an entity.  The number and secret
+// of the entity are kept.  This action must be authorized.
+func (n *NetAuthClient) RenameEntity(id, newID, t string) (*pb.SimpleResult, error) {
+	request := pb.RenameRequest{
+		Name:      &id,
+		NewName:   &newID,
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.RenameEntity(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
 // EntityInfo btains the entity object with the secure fields
 // redacted.  This is primarily used for displaying the values of the
 // metadata struct internally.
@@ -211,10 +259,18 @@ func (n *NetAuthClient) EntityInfo(id string) (*pb.Entity, error) {
 // ModifyEntityMeta makes an authenticated request to the server to
 // update the metadata of an entity.
 func (n *NetAuthClient) ModifyEntityMeta(id, t string, meta *pb.EntityMeta) (*pb.SimpleResult, error) {
+	return n.ModifyEntityMetaAt(id, t, 0, meta)
+}
+
+// ModifyEntityMetaAt is the same as ModifyEntityMeta, but the update
+// is refused if the entity is no longer at the given revision.  A
+// revision of 0 updates the entity whatever its revision.
+func (n *NetAuthClient) ModifyEntityMetaAt(id, t string, revision uint64, meta *pb.EntityMeta) (*pb.SimpleResult, error) {
 	request := pb.ModEntityRequest{
 		Entity: &pb.Entity{
-			ID:   &id,
-			Meta: meta,
+			ID:       &id,
+			Meta:     meta,
+			Revision: &revision,
 		},
 		AuthToken: &t,
 		Info: &pb.ClientInfo{
@@ -317,12 +373,25 @@ func (n *NetAuthClient) NewGroup(name, displayname, managedby, t string, number
 }
 
 // DeleteGroup removes a group by name.  This action must be
-// authorized.
+// authorized.  The group is not removed while anything still refers
+// to it.
 func (n *NetAuthClient) DeleteGroup(name, t string) (*pb.SimpleResult, error) {
+	return n.deleteGroup(name, t, false)
+}
+
+// ForceDeleteGroup removes a group by name along with every reference
+// to it, such as memberships and expansions.  This action must be
+// authorized.
+func (n *NetAuthClient) ForceDeleteGroup(name, t string) (*pb.SimpleResult, error) {
+	return n.deleteGroup(name, t, true)
+}
+
+func (n *NetAuthClient) deleteGroup(name, t string, force bool) (*pb.SimpleResult, error) {
 	request := pb.ModGroupRequest{
 		Group: &pb.Group{
 			Name: &name,
 		},
+		Force:     &force,
 		AuthToken: &t,
 		Info: &pb.ClientInfo{
 			ID:      &n.cfg.ClientID,
@@ -337,6 +406,27 @@ func (n *NetAuthClient) DeleteGroup(name, t string) (*pb.SimpleResult, error) {
 	return result, nil
 }
 
+// RenameGroup changes the name of a group, along with every reference
+// to it.  The number of the group is kept.  This action must be
+// authorized.
+func (n *NetAuthClient) RenameGroup(name, newName, t string) (*pb.SimpleResult, error) {
+	request := pb.RenameRequest{
+		Name:      &name,
+		NewName:   &newName,
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.RenameGroup(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
 // ListGroups returns a list of groups to the caller.  This action
 // does not require authorization.
 func (n *NetAuthClient) ListGroups(entity string, indirects bool) ([]*pb.Group, error) {
@@ -596,6 +686,85 @@ func (n *NetAuthClient) UnlockEntity(t, e string) (*pb.SimpleResult, error) {
 	return result, nil
 }
 
+// GetAuthFailures returns the failed authentications recorded
+// against an entity.
+func (n *NetAuthClient) GetAuthFailures(t, e string) (*pb.AuthFailures, error) {
+	request := pb.NetAuthRequest{
+		Entity: &pb.Entity{
+			ID: &e,
+		},
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.GetAuthFailures(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ClearAuthFailures clears the failed authentications recorded
+// against an entity, ending any temporary lockout.
+func (n *NetAuthClient) ClearAuthFailures(t, e string) (*pb.SimpleResult, error) {
+	request := pb.NetAuthRequest{
+		Entity: &pb.Entity{
+			ID: &e,
+		},
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.ClearAuthFailures(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fsck asks the server to check its data for inconsistencies, and
+// optionally to repair the ones that can be repaired safely.
+func (n *NetAuthClient) Fsck(t string, repair bool) ([]*pb.FsckProblem, error) {
+	request := pb.FsckRequest{
+		AuthToken: &t,
+		Repair:    &repair,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.Fsck(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result.GetProblems(), nil
+}
+
+// QueryAudit returns the audit records on the server that match the
+// actor, target, action, time range and limit set in the query,
+// oldest first.
+func (n *NetAuthClient) QueryAudit(t string, query *pb.QueryAuditRequest) ([]*pb.AuditRecord, error) {
+	request := proto.Clone(query).(*pb.QueryAuditRequest)
+	request.AuthToken = &t
+	request.Info = &pb.ClientInfo{
+		ID:      &n.cfg.ClientID,
+		Service: &n.cfg.ServiceID,
+	}
+
+	result, err := n.c.QueryAudit(context.Background(), request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result.GetRecords(), nil
+}
+
 func ensureClientID(clientID string) string {
 	if clientID == "" {
 		hostname, err := os.Hostname()
@@ -7,8 +7,6 @@ import (
 	"os"
 
 	"github.com/NetAuth/NetAuth/internal/token"
-	// Register the token services on import
-	_ "github.com/NetAuth/NetAuth/internal/token/all"
 
 	"github.com/BurntSushi/toml"
 	"google.golang.org/grpc"
@@ -80,19 +78,12 @@ func New(cfg *NACLConfig) (*NetAuthClient, error) {
 		log.Println(err)
 	}
 
-	// Get a token service, don't be a fatal error as most queries
-	// don't require authentication anyway.
-	ts, err := token.New()
-	if err != nil {
-		log.Println(err)
-	}
-
 	// Create a client to use later on.
 	client := NetAuthClient{
-		c:            pb.NewNetAuthClient(conn),
-		cfg:          cfg,
-		tokenStore:   t,
-		tokenService: ts,
+		c:             pb.NewNetAuthClient(conn),
+		cfg:           cfg,
+		tokenStore:    t,
+		tokenServices: make(map[string]token.Service),
 	}
 
 	return &client, nil
@@ -3,8 +3,10 @@ package client
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/NetAuth/NetAuth/internal/token"
+	"github.com/NetAuth/NetAuth/internal/token/jwt"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -12,6 +14,10 @@ import (
 	pb "github.com/NetAuth/Protocol"
 )
 
+// renewWindow is how close to expiry a cached token must be before
+// the client will try to renew it.
+var renewWindow = 30 * time.Minute
+
 // GetToken is identical to Authenticate except on success it will
 // return a token which can be used to authorize additional later
 // requests.
@@ -19,10 +25,9 @@ func (n *NetAuthClient) GetToken(entity, secret string) (string, error) {
 	// See if we have a local copy first.
 	t, err := n.getTokenFromStore(entity)
 	if err == nil {
-		var err error
-		if _, err = n.InspectToken(t); err == nil {
-			log.Println("Using cached token")
-			return t, nil
+		claims, err := n.InspectToken(t)
+		if err == nil {
+			return n.maybeRenewToken(entity, t, claims), nil
 		}
 		log.Println("Not using cached token: ", err)
 	} else {
@@ -56,18 +61,126 @@ func (n *NetAuthClient) GetToken(entity, secret string) (string, error) {
 	return t, err
 }
 
-// InspectToken proxies through to the tokenService since the inner
-// function may oneday be significantly more complicated, but hte
-// function in the client should not change.
+// RenewToken exchanges a valid token for a fresh one, which is then
+// placed in the token store.
+func (n *NetAuthClient) RenewToken(entity, t string) (string, error) {
+	request := pb.NetAuthRequest{
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+	tokenResult, err := n.c.RenewToken(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return "", err
+	}
+
+	t = tokenResult.GetToken()
+	if err := n.tokenStore.DestroyToken(entity); err != nil {
+		return "", err
+	}
+	err = n.putTokenInStore(entity, t)
+	return t, err
+}
+
+// maybeRenewToken renews a cached token if it is close to expiring
+// and still has renewals left.  If the renewal fails the cached token
+// is still good for a while, so it is returned instead.
+func (n *NetAuthClient) maybeRenewToken(entity, t string, claims token.Claims) string {
+	if claims.RenewalsLeft <= 0 || time.Until(claims.Expires) > renewWindow {
+		log.Println("Using cached token")
+		return t
+	}
+
+	nt, err := n.RenewToken(entity, t)
+	if err != nil {
+		log.Println("Could not renew cached token: ", err)
+		return t
+	}
+	log.Println("Renewed cached token")
+	return nt
+}
+
+// InspectToken validates the token locally with the token service
+// that matches the algorithm the token was signed with.  Services are
+// kept for later tokens once they have been created.
 func (n *NetAuthClient) InspectToken(t string) (token.Claims, error) {
-	if n.tokenService == nil {
-		return token.Claims{}, token.ErrKeyUnavailable
+	alg, err := jwt.Algorithm(t)
+	if err != nil {
+		return token.Claims{}, err
+	}
+
+	ts, err := n.tokenService(alg)
+	if err != nil {
+		return token.Claims{}, err
 	}
-	return n.tokenService.Validate(t)
+	return ts.Validate(t)
 }
 
-// DestroyToken proxies inwards to the tokenStore to shield the client
-// API for the future.
+// tokenService returns the token service for the algorithm, creating
+// it if this is the first token signed with that algorithm.
+func (n *NetAuthClient) tokenService(alg string) (token.Service, error) {
+	n.tsMu.Lock()
+	defer n.tsMu.Unlock()
+
+	if ts, ok := n.tokenServices[alg]; ok {
+		return ts, nil
+	}
+	ts, err := jwt.NewForAlgorithm(alg)
+	if err != nil {
+		return nil, err
+	}
+	n.tokenServices[alg] = ts
+	return ts, nil
+}
+
+// DestroyToken revokes the stored token on the server and then
+// removes the local copy.  A token that can't be revoked is still
+// removed locally.
 func (n *NetAuthClient) DestroyToken(name string) error {
+	if t, err := n.getTokenFromStore(name); err == nil {
+		if _, err := n.RevokeToken(t); err != nil {
+			log.Println("Could not revoke token: ", err)
+		}
+	}
 	return n.tokenStore.DestroyToken(name)
 }
+
+// RevokeToken asks the server to revoke the provided token.
+func (n *NetAuthClient) RevokeToken(t string) (*pb.SimpleResult, error) {
+	request := pb.NetAuthRequest{
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.RevokeToken(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RevokeAllTokens asks the server to revoke every token that has been
+// issued to the named entity.
+func (n *NetAuthClient) RevokeAllTokens(t, e string) (*pb.SimpleResult, error) {
+	request := pb.NetAuthRequest{
+		Entity: &pb.Entity{
+			ID: &e,
+		},
+		AuthToken: &t,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	result, err := n.c.RevokeAllTokens(context.Background(), &request)
+	if status.Code(err) != codes.OK {
+		return nil, err
+	}
+	return result, nil
+}
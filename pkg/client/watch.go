@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/NetAuth/Protocol"
+)
+
+// WatchChanges asks the server to report changes to entities and
+// groups.  Changes after the given revision are delivered on the
+// returned channel in order; a revision of 0 watches only for new
+// changes.  When the stream ends the channel is closed and the
+// reason is sent on the error channel, after which the watch may be
+// resumed from the revision of the last change received.  Cancel ctx
+// to stop watching.
+func (n *NetAuthClient) WatchChanges(ctx context.Context, t string, revision uint64) (<-chan *pb.ChangeEvent, <-chan error, error) {
+	request := pb.WatchRequest{
+		AuthToken: &t,
+		Revision:  &revision,
+		Info: &pb.ClientInfo{
+			ID:      &n.cfg.ClientID,
+			Service: &n.cfg.ServiceID,
+		},
+	}
+
+	stream, err := n.c.WatchChanges(ctx, &request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan *pb.ChangeEvent)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(events)
+		for {
+			ev, err := stream.Recv()
+			if err == io.EOF {
+				errc <- nil
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return events, errc, nil
+}